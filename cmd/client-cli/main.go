@@ -13,6 +13,11 @@ import (
 	"github.com/briandowns/spinner"
 )
 
+const (
+	userID       = "client-cli"
+	usageCommand = "/usage"
+)
+
 func main() {
 	reader := bufio.NewReader(os.Stdin)
 	spin := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
@@ -30,6 +35,18 @@ func main() {
 			fmt.Println("Error reading input:", err)
 			return
 		}
+		if strings.TrimSpace(string(input)) == usageCommand {
+			report, err := callUsage(botSrv, serverURL)
+			if err != nil {
+				fmt.Println("Error during receiving usage:", err)
+
+				continue
+			}
+
+			fmt.Print(">> ", report, "\n")
+			continue
+		}
+
 		spin.Start()
 
 		// Send to AI server
@@ -53,7 +70,7 @@ func callServer(client *http.Client, baseURL string, input string) (string, erro
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("X-User-ID", "client-cli")
+	req.Header.Set("X-User-ID", userID)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := client.Do(req)
@@ -69,3 +86,32 @@ func callServer(client *http.Client, baseURL string, input string) (string, erro
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	return response.Response, err
 }
+
+// callUsage returns the token usage and cost summary of the CLI user
+func callUsage(client *http.Client, baseURL string) (string, error) {
+	resp, err := client.Get(fmt.Sprintf("%s/usage?user=%s", baseURL, url.QueryEscape(userID)))
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var report struct {
+		Total struct {
+			Calls          int     `json:"calls"`
+			InputTokens    int     `json:"inputTokens"`
+			OutputTokens   int     `json:"outputTokens"`
+			ThinkingTokens int     `json:"thinkingTokens"`
+			Cost           float64 `json:"cost"`
+		} `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return "", err
+	}
+
+	t := report.Total
+	return fmt.Sprintf("%d calls, %d input, %d output, %d thinking tokens, estimated cost: $%.4f", t.Calls, t.InputTokens, t.OutputTokens, t.ThinkingTokens, t.Cost), nil
+}
@@ -2,15 +2,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"hairy-botter/pkg/httpBotter"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
-	"syscall"
 	"sync"
+	"syscall"
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
@@ -39,7 +43,18 @@ func main() {
 		}
 	}
 
-	l := New(aiSrv, usernameLimits)
+	chatsFile := os.Getenv("CHATS_FILE")
+	if chatsFile == "" {
+		chatsFile = "telegram-chats.json"
+	}
+
+	l, err := New(aiSrv, usernameLimits, chatsFile)
+	if err != nil {
+		fmt.Println("failed to load the chats:", err)
+
+		os.Exit(1)
+		return
+	}
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
@@ -77,6 +92,13 @@ func main() {
 		}
 	}()
 
+	// Register the push endpoint, so the server could send the scheduled messages to the right chat
+	if callbackURL := os.Getenv("CALLBACK_URL"); callbackURL != "" {
+		if err := l.httpB.Register(sessionPrefix, callbackURL, os.Getenv("CHANNEL_TOKEN")); err != nil {
+			fmt.Println("failed to register callback URL:", err)
+		}
+	}
+
 	b.Start(ctx)
 
 	// Graceful shutdown of HTTP server
@@ -85,18 +107,65 @@ func main() {
 	}
 }
 
+const sessionPrefix = "tg-"
+
 type Logic struct {
 	httpB      *httpBotter.Logic
 	userLimits []string
 	chatID     int64
+	chats      map[int64]struct{} // The allowed chats, the pushed messages are only sent to them
+	chatsFile  string             // The chats are persisted, so the pushes keep working after a restart
 	mu         sync.RWMutex
 	bot        *bot.Bot
 }
 
-func New(baseURL string, userLimit []string) *Logic {
-	return &Logic{
+func New(baseURL string, userLimit []string, chatsFile string) (*Logic, error) {
+	l := &Logic{
 		httpB:      httpBotter.New(baseURL),
 		userLimits: userLimit,
+		chats:      make(map[int64]struct{}),
+		chatsFile:  chatsFile,
+	}
+
+	b, err := os.ReadFile(chatsFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		var chats []int64
+		if err := json.Unmarshal(b, &chats); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", chatsFile, err)
+		}
+		for _, id := range chats {
+			l.chats[id] = struct{}{}
+		}
+	}
+
+	return l, nil
+}
+
+// addChat allows the pushes to the chat, the new chats are saved
+func (l *Logic) addChat(chatID int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.chatID = chatID
+	if _, ok := l.chats[chatID]; ok {
+		return
+	}
+	l.chats[chatID] = struct{}{}
+
+	chats := make([]int64, 0, len(l.chats))
+	for id := range l.chats {
+		chats = append(chats, id)
+	}
+	slices.Sort(chats)
+	b, err := json.Marshal(chats)
+	if err == nil {
+		err = os.WriteFile(l.chatsFile, b, 0644)
+	}
+	if err != nil {
+		fmt.Println("failed to save the chats:", err)
 	}
 }
 
@@ -112,14 +181,32 @@ func (l *Logic) httpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The server sends the session of the chat, the last chat is only a fallback for the older senders
+	var chatID int64
+	if sessionID := r.FormValue("sessionId"); sessionID != "" {
+		id, err := strconv.ParseInt(strings.TrimPrefix(sessionID, sessionPrefix), 10, 64)
+		if err != nil || !strings.HasPrefix(sessionID, sessionPrefix) {
+			http.Error(w, "Invalid sessionId", http.StatusBadRequest)
+			return
+		}
+		chatID = id
+	}
+
 	l.mu.RLock()
-	chatID := l.chatID
+	if chatID == 0 {
+		chatID = l.chatID
+	}
+	_, known := l.chats[chatID]
 	l.mu.RUnlock()
 
 	if chatID == 0 {
 		http.Error(w, "No active chat found. Please send a message to the bot first.", http.StatusServiceUnavailable)
 		return
 	}
+	if !known {
+		http.Error(w, "Unknown chat, the user must send a message to the bot first.", http.StatusForbidden)
+		return
+	}
 
 	_, err := l.bot.SendMessage(r.Context(), &bot.SendMessageParams{
 		ParseMode: models.ParseModeMarkdown,
@@ -163,9 +250,7 @@ func (l *Logic) Handler(ctx context.Context, b *bot.Bot, update *models.Update)
 		}
 	}
 
-	l.mu.Lock()
-	l.chatID = update.Message.Chat.ID
-	l.mu.Unlock()
+	l.addChat(update.Message.Chat.ID)
 
 	var payloads [][]byte
 	msg := update.Message.Text
@@ -205,7 +290,7 @@ func (l *Logic) Handler(ctx context.Context, b *bot.Bot, update *models.Update)
 	}
 
 	fmt.Println("Sending message to AI service:", msg)
-	res, err := l.httpB.Send(fmt.Sprintf("tg-%d", update.Message.Chat.ID), msg, payloads)
+	res, err := l.httpB.Send(fmt.Sprintf("%s%d", sessionPrefix, update.Message.Chat.ID), msg, payloads)
 	if err != nil {
 		fmt.Println("error sending message to AI service:", err)
 		return
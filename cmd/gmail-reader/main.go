@@ -107,6 +107,7 @@ func saveToken(path string, token *oauth2.Token) {
 type Configuration struct {
 	PollingInterval time.Duration
 	WebhookURL      string
+	WebhookSecret   string
 	SearchQuery     string
 }
 
@@ -120,6 +121,7 @@ func loadConfig() Configuration {
 	if val := os.Getenv("WEBHOOK_URL"); val != "" {
 		cfg.WebhookURL = val
 	}
+	cfg.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
 	if val := os.Getenv("SEARCH_QUERY"); val != "" {
 		cfg.SearchQuery = val
 	}
@@ -200,11 +202,11 @@ func pollEmails(srv *gmail.Service, cfg Configuration) {
 	}
 
 	for _, m := range r.Messages {
-		processMessage(srv, user, m.Id, cfg.WebhookURL)
+		processMessage(srv, user, m.Id, cfg)
 	}
 }
 
-func processMessage(srv *gmail.Service, user string, msgId string, webhookURL string) {
+func processMessage(srv *gmail.Service, user string, msgId string, cfg Configuration) {
 	msg, err := srv.Users.Messages.Get(user, msgId).Format("full").Do()
 	if err != nil {
 		log.Printf("Unable to get message %s: %v", msgId, err)
@@ -214,7 +216,7 @@ func processMessage(srv *gmail.Service, user string, msgId string, webhookURL st
 	data := extractEmailData(srv, user, msg)
 
 	// Send to webhook
-	err = forwardToWebhook(data, webhookURL)
+	err = forwardToWebhook(data, cfg.WebhookURL, cfg.WebhookSecret)
 	if err != nil {
 		log.Printf("Failed to forward message %s: %v", msgId, err)
 		// Leave as unread so it gets picked up again
@@ -295,7 +297,7 @@ func extractParts(srv *gmail.Service, user string, msgId string, part *gmail.Mes
 	}
 }
 
-func forwardToWebhook(data emailData, webhookURL string) error {
+func forwardToWebhook(data emailData, webhookURL, webhookSecret string) error {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 
@@ -344,6 +346,7 @@ func forwardToWebhook(data emailData, webhookURL string) error {
 	}
 
 	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-Webhook-Secret", webhookSecret)
 
 	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"hairy-botter/internal/ai/agent"
+	genkit_embedding "hairy-botter/internal/ai/genkit-embedding"
+	genkit_summarizer "hairy-botter/internal/ai/genkit-summarizer"
+	"hairy-botter/internal/ai/provider"
+	"hairy-botter/internal/history"
+	"hairy-botter/internal/memory"
+	"hairy-botter/internal/persona"
+	"hairy-botter/internal/rag"
+	"hairy-botter/internal/tools"
+	"hairy-botter/internal/usage"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// agentBuilder holds the dependencies shared between the named agents
+type agentBuilder struct {
+	logger   *slog.Logger
+	g        *genkit.Genkit
+	provider *provider.Provider
+
+	embedder       ai.Embedder
+	nativeTools    []ai.Tool
+	memory         *memory.Logic
+	usage          *usage.Logic
+	customConfig   any
+	historySummary int
+	defaultModel   string // Used by the agents without a model
+	retryPolicy    agent.RetryPolicy
+	outputRetries  int
+
+	models   map[string]ai.Model
+	ragLs    []*rag.Logic
+	watchCtx context.Context
+}
+
+// model defines every model only once, since genkit doesn't allow duplicated registrations
+func (b *agentBuilder) model(name string) (ai.Model, error) {
+	if name == "" {
+		name = b.defaultModel
+	}
+	if name == "" {
+		name = b.provider.DefaultModel()
+	}
+	if m, ok := b.models[name]; ok {
+		return m, nil
+	}
+
+	m, err := b.provider.Model(b.g, name)
+	if err != nil {
+		return nil, err
+	}
+	b.models[name] = m
+
+	return m, nil
+}
+
+func (b *agentBuilder) build(cfg agent.Config) (*agent.Logic, error) {
+	logger := b.logger.With("agent", cfg.Name)
+
+	model, err := b.model(cfg.Model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to define model: %w", err)
+	}
+
+	retry := agent.RetryConfig{Policy: b.retryPolicy, OutputRetries: b.outputRetries}
+	for _, name := range cfg.Fallbacks {
+		fallback, err := b.model(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to define fallback model %s: %w", name, err)
+		}
+		retry.Fallbacks = append(retry.Fallbacks, fallback)
+	}
+
+	var ragL *rag.Logic
+	if cfg.RAGPath != "" {
+		if err := os.MkdirAll(cfg.RAGPath, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create RAG directory: %w", err)
+		}
+
+		embedFn := b.usage.Embedding(b.embedder.Name(), usage.EmbedFunc(genkit_embedding.New(b.g, b.embedder)))
+		ragL, err = rag.New(logger, cfg.RAGPath, rag.EmbeddingFunc(embedFn))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create RAG logic: %w", err)
+		}
+		b.ragLs = append(b.ragLs, ragL)
+	}
+
+	if err := os.MkdirAll(cfg.HistoryPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	hist := history.New(logger, cfg.HistoryPath, history.Config{
+		HistorySummary: b.historySummary,
+		Summarizer:     genkit_summarizer.New(b.g, model, b.summaryUsage(model)),
+	})
+
+	personaL, err := persona.New(logger, cfg.Personality)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load personality: %w", err)
+	}
+	go personaL.Watch(b.watchCtx, 5*time.Second)
+
+	return agent.New(logger, b.g, model, hist, b.memory, personaL, cfg.MCPServers, cfg.MCPHeaders, tools.Select(b.nativeTools, cfg.NativeTools), ragL, b.customConfig, b.usage, retry)
+}
+
+// summaryUsage records the calls of the summarizer with the given model
+func (b *agentBuilder) summaryUsage(model ai.Model) ai.ModelMiddleware {
+	return b.usage.Middleware(usage.KindSummary, model.Name)
+}
+
+// close flushes the RAG databases of every agent
+func (b *agentBuilder) close() {
+	for _, ragL := range b.ragLs {
+		if err := ragL.Close(); err != nil {
+			b.logger.Error("failed to persist the database", slog.String("err", err.Error()))
+		}
+	}
+}
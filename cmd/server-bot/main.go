@@ -10,15 +10,22 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"hairy-botter/internal/ai/agent"
-	"hairy-botter/internal/ai/gemini"
-	genkit_embedding "hairy-botter/internal/ai/genkit-embedding"
 	genkit_summarizer "hairy-botter/internal/ai/genkit-summarizer"
-	"hairy-botter/internal/history"
-	"hairy-botter/internal/rag"
+	"hairy-botter/internal/ai/provider"
+	"hairy-botter/internal/delivery"
+	"hairy-botter/internal/jobs"
+	"hairy-botter/internal/memory"
+	"hairy-botter/internal/scheduler"
+	"hairy-botter/internal/schemas"
 	"hairy-botter/internal/server"
+	"hairy-botter/internal/tools"
+	"hairy-botter/internal/usage"
+	"hairy-botter/internal/webhook"
 
+	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
 )
 
@@ -38,7 +45,6 @@ func logLevelEnv() slog.Level {
 	}
 }
 
-
 func main() {
 
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
@@ -50,13 +56,6 @@ func main() {
 		addr = ":8080"
 	}
 
-	geminiKey := os.Getenv("GEMINI_API_KEY")
-	if geminiKey == "" {
-		logger.Error("GEMINI_API_KEY is not set")
-
-		return
-	}
-
 	historySummaryEnv := os.Getenv("HISTORY_SUMMARY")
 	historySummary := 20 // Default to 20
 	if historySummaryEnv != "" {
@@ -79,70 +78,357 @@ func main() {
 			mcpClientAddrs = append(mcpClientAddrs, s)
 		}
 	}
+	var mcpHeaders map[string]string
+	if token := os.Getenv("MCP_AUTH_TOKEN"); token != "" {
+		mcpHeaders = map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	modelFallbacks := make([]string, 0)
+	if modelFallbacksEnv := os.Getenv("MODEL_FALLBACKS"); modelFallbacksEnv != "" {
+		for _, m := range strings.Split(modelFallbacksEnv, ",") {
+			modelFallbacks = append(modelFallbacks, strings.TrimSpace(m))
+		}
+	}
+
+	retryPolicy := agent.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    8 * time.Second,
+	}
+	if retryAttemptsEnv := os.Getenv("RETRY_MAX_ATTEMPTS"); retryAttemptsEnv != "" {
+		p, err := strconv.Atoi(retryAttemptsEnv)
+		if err != nil {
+			logger.Error("failed to parse RETRY_MAX_ATTEMPTS", slog.String("err", err.Error()))
+
+			return
+		}
+		retryPolicy.MaxAttempts = p
+	}
+	if retryBaseDelayEnv := os.Getenv("RETRY_BASE_DELAY"); retryBaseDelayEnv != "" {
+		d, err := time.ParseDuration(retryBaseDelayEnv)
+		if err != nil {
+			logger.Error("failed to parse RETRY_BASE_DELAY", slog.String("err", err.Error()))
+
+			return
+		}
+		retryPolicy.BaseDelay = d
+	}
+	if retryMaxDelayEnv := os.Getenv("RETRY_MAX_DELAY"); retryMaxDelayEnv != "" {
+		d, err := time.ParseDuration(retryMaxDelayEnv)
+		if err != nil {
+			logger.Error("failed to parse RETRY_MAX_DELAY", slog.String("err", err.Error()))
+
+			return
+		}
+		retryPolicy.MaxDelay = d
+	}
+
+	outputRetries := 2
+	if outputRetriesEnv := os.Getenv("OUTPUT_RETRIES"); outputRetriesEnv != "" {
+		p, err := strconv.Atoi(outputRetriesEnv)
+		if err != nil {
+			logger.Error("failed to parse OUTPUT_RETRIES", slog.String("err", err.Error()))
+
+			return
+		}
+		outputRetries = p
+	}
+
+	schemasDir := os.Getenv("SCHEMAS_DIR")
+	if schemasDir == "" {
+		schemasDir = "schemas/"
+	}
+	schemaRegistry, err := schemas.Load(schemasDir)
+	if err != nil {
+		logger.Error("failed to load schemas", slog.String("err", err.Error()))
+
+		return
+	}
+
+	var callbackAllowedHosts []string
+	if allowedHostsEnv := os.Getenv("CALLBACK_ALLOWED_HOSTS"); allowedHostsEnv != "" {
+		for _, h := range strings.Split(allowedHostsEnv, ",") {
+			callbackAllowedHosts = append(callbackAllowedHosts, strings.TrimSpace(h))
+		}
+	}
+
+	outbound, err := delivery.New(logger, "delivery/", delivery.Config{AllowedHosts: callbackAllowedHosts})
+	if err != nil {
+		logger.Error("failed to create delivery", slog.String("err", err.Error()))
+
+		return
+	}
+	if callbacksEnv := os.Getenv("CHANNEL_CALLBACKS"); callbacksEnv != "" {
+		// Format: prefix=url,prefix=url, the adapters could register themselves too
+		for _, c := range strings.Split(callbacksEnv, ",") {
+			prefix, u, ok := strings.Cut(strings.TrimSpace(c), "=")
+			if !ok {
+				logger.Error("failed to parse CHANNEL_CALLBACKS", slog.String("value", c))
+
+				return
+			}
+			if _, err := outbound.Register(context.Background(), strings.TrimSpace(prefix), strings.TrimSpace(u)); err != nil {
+				logger.Error("failed to register channel callback", slog.String("err", err.Error()))
+
+				return
+			}
+		}
+	}
+
+	var webhooks *webhook.Registry
+	if webhooksConfig := os.Getenv("WEBHOOKS_CONFIG"); webhooksConfig != "" {
+		webhooks, err = webhook.LoadConfig(webhooksConfig)
+		if err != nil {
+			logger.Error("failed to load webhooks config", slog.String("err", err.Error()))
+
+			return
+		}
+	}
+
+	jobsConfig := jobs.Config{Notifier: outbound}
+	if workersEnv := os.Getenv("JOB_WORKERS"); workersEnv != "" {
+		p, err := strconv.Atoi(workersEnv)
+		if err != nil {
+			logger.Error("failed to parse JOB_WORKERS", slog.String("err", err.Error()))
+
+			return
+		}
+		jobsConfig.Workers = p
+	}
+	if queueSizeEnv := os.Getenv("JOB_QUEUE_SIZE"); queueSizeEnv != "" {
+		p, err := strconv.Atoi(queueSizeEnv)
+		if err != nil {
+			logger.Error("failed to parse JOB_QUEUE_SIZE", slog.String("err", err.Error()))
+
+			return
+		}
+		jobsConfig.QueueSize = p
+	}
+	jobQueue, err := jobs.New(logger, "jobs/", jobsConfig)
+	if err != nil {
+		logger.Error("failed to create job queue", slog.String("err", err.Error()))
+
+		return
+	}
+
+	schedulerConfig := scheduler.Config{Delivery: outbound}
+	if tz := os.Getenv("SCHEDULER_TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			logger.Error("failed to parse SCHEDULER_TIMEZONE", slog.String("err", err.Error()))
+
+			return
+		}
+		schedulerConfig.Location = loc
+	}
+	jobScheduler, err := scheduler.New(logger, "scheduler/", schedulerConfig)
+	if err != nil {
+		logger.Error("failed to create scheduler", slog.String("err", err.Error()))
+
+		return
+	}
+
+	personalityFile := os.Getenv("PERSONALITY_FILE")
+	if personalityFile == "" {
+		personalityFile = "personality.txt"
+	}
+
+	userMemoryEnable := false
+	userMemory := os.Getenv("USER_MEMORY")
+	if userMemory == "true" || userMemory == "1" {
+		userMemoryEnable = true
+		logger.Info("user memory extraction is enabled")
+	}
+
+	nativeToolGroups := make([]string, 0)
+	if nativeToolsEnv := os.Getenv("NATIVE_TOOLS"); nativeToolsEnv != "" {
+		for _, t := range strings.Split(nativeToolsEnv, ",") {
+			nativeToolGroups = append(nativeToolGroups, strings.TrimSpace(t))
+		}
+	}
 
 	searchEnable := true
 	searchDisabled := os.Getenv("GEMINI_SEARCH_DISABLED")
 	if searchDisabled == "true" || searchDisabled == "1" {
 		searchEnable = false
-		logger.Info("Gemini search plugin is disabled")
+		logger.Info("search grounding is disabled")
 	}
 
-	// Initialize the Gemini AI logic
-	ga := gemini.ConfigPlugin(geminiKey)
-	g := genkit.Init(context.Background(), genkit.WithPlugins(ga))
+	var prices map[string]usage.Price
+	if pricesFile := os.Getenv("USAGE_PRICES"); pricesFile != "" {
+		var err error
+		prices, err = usage.LoadPrices(pricesFile)
+		if err != nil {
+			logger.Error("failed to load usage prices", slog.String("err", err.Error()))
 
-	model, err := gemini.ConfigModel(g, ga, os.Getenv("GEMINI_MODEL"))
+			return
+		}
+	}
+
+	registryConfig := agent.RegistryConfig{
+		Default: "default",
+		Agents: []agent.Config{{
+			Name:        "default",
+			Personality: personalityFile,
+			Fallbacks:   modelFallbacks,
+			MCPServers:  mcpClientAddrs,
+			MCPHeaders:  mcpHeaders,
+			NativeTools: nativeToolGroups,
+			RAGPath:     "bot-context/",
+			HistoryPath: "history-gemini/",
+		}},
+	}
+	if agentsConfig := os.Getenv("AGENTS_CONFIG"); agentsConfig != "" {
+		var err error
+		registryConfig, err = agent.LoadRegistryConfig(agentsConfig)
+		if err != nil {
+			logger.Error("failed to load agents config", slog.String("err", err.Error()))
+
+			return
+		}
+	}
+
+	// Initialize the AI provider plugin
+	providerConfig := provider.Config{
+		Provider:       os.Getenv("PROVIDER"),
+		EmbeddingModel: os.Getenv("EMBEDDING_MODEL"),
+		SearchEnable:   searchEnable,
+	}
+	switch providerConfig.Provider {
+	case "", provider.Gemini:
+		providerConfig.APIKey = os.Getenv("GEMINI_API_KEY")
+	case provider.Ollama:
+		providerConfig.BaseURL = os.Getenv("OLLAMA_ADDR")
+	case provider.OpenAI:
+		providerConfig.APIKey = os.Getenv("OPENAI_API_KEY")
+		providerConfig.BaseURL = os.Getenv("OPENAI_BASE_URL")
+	case provider.Fake:
+		logger.Warn("using the offline fake provider, the model only echoes the messages")
+	}
+	aiProvider, err := provider.New(providerConfig)
+	if err != nil {
+		logger.Error("failed to configure the AI provider", slog.String("err", err.Error()))
+
+		return
+	}
+	g := genkit.Init(context.Background(), genkit.WithPlugins(aiProvider.Plugin()))
+
+	defaultModel := os.Getenv("MODEL")
+	if defaultModel == "" {
+		defaultModel = os.Getenv("GEMINI_MODEL") // Kept for the existing setups
+	}
+
+	builder := &agentBuilder{
+		logger:         logger,
+		g:              g,
+		provider:       aiProvider,
+		customConfig:   aiProvider.CustomConfig(),
+		historySummary: historySummary,
+		defaultModel:   defaultModel,
+		retryPolicy:    retryPolicy,
+		outputRetries:  outputRetries,
+		models:         make(map[string]ai.Model),
+	}
+
+	builder.usage, err = usage.New(logger, "usage/", prices)
+	if err != nil {
+		logger.Error("failed to create usage logic", slog.String("err", err.Error()))
+
+		return
+	}
+
+	model, err := builder.model("")
 	if err != nil {
 		logger.Error("failed to define model", slog.String("err", err.Error()))
 
 		return
 	}
-	customModelConfig := gemini.CustomConfig(searchEnable)
 
-	embedder, err := gemini.ConfigEmbedder(g, ga, "gemini-embedding-001")
+	builder.embedder, err = aiProvider.Embedder(g)
 	if err != nil {
 		logger.Error("failed to define embedder", slog.String("err", err.Error()))
 
 		return
 	}
 
-	ragL, err := rag.New(logger, "bot-context/", rag.EmbeddingFunc(genkit_embedding.New(g, embedder)))
+	memoryConfig := memory.Config{MaxFacts: 50}
+	if userMemoryEnable {
+		memoryConfig.Summarizer = genkit_summarizer.New(g, model, builder.summaryUsage(model))
+	}
+	builder.memory, err = memory.New(logger, "user-memory/", memoryConfig)
 	if err != nil {
-		logger.Error("failed to create RAG logic", slog.String("err", err.Error()))
+		logger.Error("failed to create user memory", slog.String("err", err.Error()))
 
 		return
 	}
 
-	hist := history.New(logger, "history-gemini/", history.Config{
-		HistorySummary: historySummary,
-		Summarizer:     genkit_summarizer.New(g, model),
+	// Create every group used by any agent once, so the agents share the tool state (e.g. the notes)
+	allToolGroups := make([]string, 0)
+	for _, a := range registryConfig.Agents {
+		allToolGroups = append(allToolGroups, a.NativeTools...)
+	}
+	builder.nativeTools, err = tools.New(logger, tools.Config{
+		Enabled:   allToolGroups,
+		NotesPath: "bot-notes/",
+		Scheduler: jobScheduler,
 	})
-
-	aiLogic, err := agent.New(logger, g, model, hist, mcpClientAddrs, ragL, customModelConfig)
 	if err != nil {
-		logger.Error("failed to create AI logic", slog.String("err", err.Error()))
+		logger.Error("failed to define native tools", slog.String("err", err.Error()))
 
 		return
 	}
 
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	builder.watchCtx = watchCtx
+
+	registry := agent.NewRegistry(registryConfig.Default, registryConfig.Routes)
+	for _, a := range registryConfig.Agents {
+		aiLogic, err := builder.build(a)
+		if err != nil {
+			logger.Error("failed to create AI logic", slog.String("agent", a.Name), slog.String("err", err.Error()))
+
+			return
+		}
+		registry.Register(a.Name, aiLogic)
+		logger.Info("agent registered", slog.String("agent", a.Name))
+	}
+	jobScheduler.Start(registry)
+	jobQueue.Start(registry)
+
 	corsOrigin := os.Getenv("CORS_ALLOWED_ORIGIN")
 	if corsOrigin == "" {
 		corsOrigin = "*"
 	}
 	corsMethods := os.Getenv("CORS_ALLOWED_METHODS")
 	if corsMethods == "" {
-		corsMethods = "POST, OPTIONS"
+		corsMethods = "GET, POST, PUT, DELETE, OPTIONS"
 	}
 	corsHeaders := os.Getenv("CORS_ALLOWED_HEADERS")
 	if corsHeaders == "" {
-		corsHeaders = "Content-Type, X-User-ID"
+		corsHeaders = "Content-Type, X-User-ID, X-Channel, X-Locale, X-Agent"
 	}
 
-	srv := server.New(addr, aiLogic, server.Config{
+	serverConfig := server.Config{
 		AllowedOrigin:  corsOrigin,
 		AllowedMethods: corsMethods,
 		AllowedHeaders: corsHeaders,
-	})
+		Usage:          builder.usage,
+		Schemas:        schemaRegistry,
+		Scheduler:      jobScheduler,
+		Delivery:       outbound,
+		Jobs:           jobQueue,
+		Branches:       registry,
+		ChannelToken:   os.Getenv("CHANNEL_TOKEN"),
+		Logger:         logger,
+	}
+	if userMemoryEnable {
+		serverConfig.Memory = builder.memory
+	}
+	if webhooks != nil { // A nil pointer in the interface would register the routes
+		serverConfig.Webhooks = webhooks
+	}
+	srv := server.New(addr, registry, serverConfig)
 
 	stopCh := make(chan os.Signal, 1)
 	signal.Notify(stopCh, os.Interrupt, syscall.SIGTERM)
@@ -155,11 +441,17 @@ func main() {
 			logger.Error("failed to stop server", slog.String("err", err.Error()))
 		}
 
+		logger.Info("waiting for scheduled jobs")
+		jobScheduler.Close()
+
+		logger.Info("stopping job workers")
+		jobQueue.Close()
+
+		logger.Info("waiting for user memory extraction")
+		builder.memory.Close()
+
 		logger.Info("flushing RAG database")
-		err = ragL.Close()
-		if err != nil {
-			logger.Error("failed to persist the database", slog.String("err", err.Error()))
-		}
+		builder.close()
 		close(finishedCh)
 	}()
 
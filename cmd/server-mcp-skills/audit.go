@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// auditEntryKey holds the audit entry of the running tool call
+const auditEntryKey contextKey = "audit-entry"
+
+// maxAuditArg is the longest argument which is logged as is, the longer ones are hashed
+const maxAuditArg = 256
+
+// auditEntry is a line of the audit log
+type auditEntry struct {
+	Time       time.Time      `json:"time"`
+	Session    string         `json:"session"`
+	Tool       string         `json:"tool"`
+	Args       map[string]any `json:"args,omitempty"`
+	Error      bool           `json:"error,omitempty"`
+	ExitCode   *int           `json:"exitCode,omitempty"` // Only for the commands
+	DurationMs int64          `json:"durationMs"`
+	OutputSize int            `json:"outputSize"`
+}
+
+// auditLog is an append-only JSONL file of the tool calls
+type auditLog struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the audit log: %w", err)
+	}
+
+	return &auditLog{path: path, f: f}, nil
+}
+
+func (a *auditLog) write(e *auditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, err = a.f.Write(append(line, '\n'))
+
+	return err
+}
+
+// middleware records every tool call after it finished
+func (a *auditLog) middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		e := &auditEntry{
+			Time:    time.Now().UTC(),
+			Session: sessionID(ctx),
+			Tool:    req.Params.Name,
+			Args:    auditArgs(req.GetArguments()),
+		}
+
+		res, err := next(context.WithValue(ctx, auditEntryKey, e), req)
+
+		e.DurationMs = time.Since(e.Time).Milliseconds()
+		e.Error = err != nil || (res != nil && res.IsError)
+		if res != nil {
+			for _, c := range res.Content {
+				if tc, ok := c.(mcp.TextContent); ok {
+					e.OutputSize += len(tc.Text)
+				}
+			}
+		}
+		if werr := a.write(e); werr != nil {
+			slog.ErrorContext(ctx, "failed to write the audit log", slog.String("err", werr.Error()))
+		}
+
+		return res, err
+	}
+}
+
+// auditExitCode records the exit code of the command in the audit entry of the call
+func auditExitCode(ctx context.Context, code int) {
+	if e, ok := ctx.Value(auditEntryKey).(*auditEntry); ok {
+		e.ExitCode = &code
+	}
+}
+
+// auditArgs replaces the long strings (e.g. file contents) with their hash
+func auditArgs(args map[string]any) map[string]any {
+	if len(args) == 0 {
+		return nil
+	}
+
+	out := make(map[string]any, len(args))
+	for k, v := range args {
+		if s, ok := v.(string); ok && len(s) > maxAuditArg {
+			sum := sha256.Sum256([]byte(s))
+			v = fmt.Sprintf("sha256:%s (%d bytes)", hex.EncodeToString(sum[:]), len(s))
+		}
+		out[k] = v
+	}
+
+	return out
+}
+
+// query returns the last entries matching the session and the tool, the empty filters match everything
+func (a *auditLog) query(session, tool string, since time.Time, limit int) ([]auditEntry, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []auditEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // A partly written line
+		}
+		if (session != "" && e.Session != session) || (tool != "" && e.Tool != tool) || e.Time.Before(since) {
+			continue
+		}
+
+		entries = append(entries, e)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// handleQuery serves the entries filtered by the session, tool, since (RFC 3339) and limit query parameters
+func (a *auditLog) handleQuery(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, 1000)
+	}
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid since, it should be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := a.query(q.Get("session"), q.Get("tool"), since, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to read the audit log", slog.String("err", err.Error()))
+		http.Error(w, "failed to read the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
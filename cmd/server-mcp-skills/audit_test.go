@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// named sets the tool name of the requests, like the MCP server
+func named(name string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		req.Params.Name = name
+		return handler(ctx, req)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	audit, err := openAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := commandLimits{Timeout: 5 * time.Second, MaxOutput: 1000}
+
+	content := strings.Repeat("x", 1000)
+	callTool(t, named("write_file", audit.middleware(handleWriteFile(ws))), "s1", map[string]any{"path": "a.txt", "content": content})
+	callTool(t, named("execute_command", audit.middleware(handleExecuteCommand(ws, limits, nil))), "s1", map[string]any{"command": "echo hi; exit 3"})
+	callTool(t, named("execute_command", audit.middleware(handleExecuteCommand(ws, limits, nil))), "s2", map[string]any{"command": "echo other"})
+
+	entries, err := audit.query("s1", "", time.Time{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries of the session, got %+v", entries)
+	}
+	if got := entries[0].Args["content"]; !strings.HasPrefix(got.(string), "sha256:") || !strings.HasSuffix(got.(string), "(1000 bytes)") {
+		t.Errorf("expected the hashed content, got %v", got)
+	}
+	if entries[0].ExitCode != nil {
+		t.Errorf("unexpected exit code of write_file: %d", *entries[0].ExitCode)
+	}
+	cmd := entries[1]
+	if cmd.ExitCode == nil || *cmd.ExitCode != 3 || !cmd.Error || cmd.OutputSize == 0 || cmd.Args["command"] != "echo hi; exit 3" {
+		t.Errorf("unexpected command entry: %+v", cmd)
+	}
+
+	rec := httptest.NewRecorder()
+	audit.handleQuery(rec, httptest.NewRequest(http.MethodGet, "/audit?tool=execute_command&limit=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+	var got []auditEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Session != "s2" || *got[0].ExitCode != 0 {
+		t.Errorf("expected the last command, got %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	audit.handleQuery(rec, httptest.NewRequest(http.MethodGet, "/audit?limit=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request, got %d", rec.Code)
+	}
+}
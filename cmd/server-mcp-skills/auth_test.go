@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	called := false
+	h := requireToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	for _, auth := range []string{"", "secret", "Bearer wrong", "Basic secret", "Bearer secret2"} {
+		req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusUnauthorized || called {
+			t.Errorf("expected %q to be rejected, got %d", auth, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !called {
+		t.Errorf("expected the valid token to pass, got %d", rec.Code)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	if cfg, err := tlsConfig(""); cfg != nil || err != nil {
+		t.Errorf("expected no mTLS without a CA, got %v %v", cfg, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlsConfig(path); err == nil {
+		t.Error("expected an error for an invalid CA file")
+	}
+}
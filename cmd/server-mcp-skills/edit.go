@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// lineNumbers returns the line numbers where the matches of the text start
+func lineNumbers(content, text string) []string {
+	var lines []string
+	for i, pos := 0, 0; ; {
+		j := strings.Index(content[pos:], text)
+		if j < 0 {
+			return lines
+		}
+		i += strings.Count(content[pos:pos+j], "\n")
+		lines = append(lines, fmt.Sprint(i+1))
+		pos += j + max(len(text), 1)
+		i += strings.Count(text, "\n")
+	}
+}
+
+// handleEditFile creates the handler for replacing an exact text in a file.
+func handleEditFile(ws *workspaces) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		pathArg := req.GetString("path", "")
+		oldText := req.GetString("old_text", "")
+		newText := req.GetString("new_text", "")
+		replaceAll := req.GetBool("replace_all", false)
+		if pathArg == "" || oldText == "" {
+			return mcp.NewToolResultError("path and old_text parameters are required"), nil
+		}
+		slog.InfoContext(ctx, "audit: edit_file called", slog.String("session", sessionID(ctx)), slog.String("path", pathArg), slog.Int("old_len", len(oldText)), slog.Int("new_len", len(newText)))
+
+		baseDir, err := ws.dir(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		safePath, err := ensureSafePath(baseDir, pathArg)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid path: %v", err)), nil
+		}
+
+		b, err := os.ReadFile(safePath)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to read file: %v", err)), nil
+		}
+		content := string(b)
+
+		matches := lineNumbers(content, oldText)
+		switch {
+		case len(matches) == 0:
+			msg := fmt.Sprintf("old_text was not found in %s. It must match the file exactly, including the whitespace and the indentation; read the file again before retrying.", pathArg)
+			if strings.Contains(content, strings.TrimSpace(oldText)) {
+				msg += " The text was found with different leading or trailing whitespace."
+			}
+			return mcp.NewToolResultError(msg), nil
+		case len(matches) > 1 && !replaceAll:
+			return mcp.NewToolResultError(fmt.Sprintf("old_text matches %d times in %s (starting at lines %s). Add more surrounding lines to make it unique, or set replace_all to replace every match.", len(matches), pathArg, strings.Join(matches, ", "))), nil
+		}
+
+		updated := strings.Replace(content, oldText, newText, len(matches))
+		if err := ws.checkQuota(baseDir, int64(len(updated)-len(content))); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if err := os.WriteFile(safePath, []byte(updated), 0644); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to write file: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("Replaced %d occurrence(s) in %s at line(s) %s", len(matches), pathArg, strings.Join(matches, ", "))), nil
+	}
+}
+
+// patchedFile is the result of a file patch which is only written if every file could be patched
+type patchedFile struct {
+	path    string
+	content string
+	remove  bool
+	summary string
+}
+
+// handleApplyPatch creates the handler for applying a unified diff.
+func handleApplyPatch(ws *workspaces) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		patchArg := req.GetString("patch", "")
+		if patchArg == "" {
+			return mcp.NewToolResultError("patch parameter is required"), nil
+		}
+		slog.InfoContext(ctx, "audit: apply_patch called", slog.String("session", sessionID(ctx)), slog.Int("patch_len", len(patchArg)))
+
+		baseDir, err := ws.dir(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		files, err := parsePatch(patchArg)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid patch: %v", err)), nil
+		}
+
+		var results []patchedFile
+		var growth int64
+		for _, f := range files {
+			res, delta, err := patchFile(baseDir, f)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Patch not applied, no file was changed: %v", err)), nil
+			}
+			results = append(results, res)
+			growth += delta
+		}
+		if err := ws.checkQuota(baseDir, growth); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		summaries := make([]string, 0, len(results))
+		for _, res := range results {
+			if res.remove {
+				err = os.Remove(res.path)
+			} else if err = os.MkdirAll(filepath.Dir(res.path), 0755); err == nil {
+				err = os.WriteFile(res.path, []byte(res.content), 0644)
+			}
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to write %s after %v: %v", res.summary, summaries, err)), nil
+			}
+			summaries = append(summaries, res.summary)
+		}
+
+		return mcp.NewToolResultText("Patch applied: " + strings.Join(summaries, ", ")), nil
+	}
+}
+
+// patchFile returns the patched content and the size change, without writing it
+func patchFile(baseDir string, f filePatch) (patchedFile, int64, error) {
+	path := f.newPath
+	if path == "/dev/null" {
+		path = f.oldPath
+	}
+	safePath, err := ensureSafePath(baseDir, path)
+	if err != nil {
+		return patchedFile{}, 0, fmt.Errorf("invalid path: %w", err)
+	}
+
+	var content string
+	b, err := os.ReadFile(safePath)
+	switch {
+	case f.oldPath == "/dev/null" && err == nil:
+		return patchedFile{}, 0, fmt.Errorf("%s already exists, the patch creates it", path)
+	case f.oldPath != "/dev/null" && errors.Is(err, fs.ErrNotExist):
+		return patchedFile{}, 0, fmt.Errorf("%s does not exist, use /dev/null as the old path to create it", path)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return patchedFile{}, 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	content = string(b)
+
+	if f.newPath == "/dev/null" {
+		return patchedFile{path: safePath, remove: true, summary: "deleted " + path}, -int64(len(content)), nil
+	}
+
+	patched, err := applyHunks(path, content, f.hunks)
+	if err != nil {
+		return patchedFile{}, 0, err
+	}
+
+	summary := fmt.Sprintf("patched %s (%d hunks)", path, len(f.hunks))
+	if f.oldPath == "/dev/null" {
+		summary = "created " + path
+	}
+
+	return patchedFile{path: safePath, content: patched, summary: summary}, int64(len(patched) - len(content)), nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEditFile(t *testing.T) {
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := handleEditFile(ws)
+	if out, isErr := callTool(t, handleWriteFile(ws), "s", map[string]any{"path": "main.go", "content": "a := 1\nb := 1\nc := 2\n"}); isErr {
+		t.Fatal(out)
+	}
+
+	tests := []struct {
+		name     string
+		args     map[string]any
+		isErr    bool
+		contains string
+	}{
+		{name: "not found", args: map[string]any{"path": "main.go", "old_text": "d := 1", "new_text": "x"}, isErr: true, contains: "was not found"},
+		{name: "whitespace hint", args: map[string]any{"path": "main.go", "old_text": "  c := 2", "new_text": "x"}, isErr: true, contains: "different leading or trailing whitespace"},
+		{name: "not unique", args: map[string]any{"path": "main.go", "old_text": ":= 1", "new_text": ":= 3"}, isErr: true, contains: "matches 2 times in main.go (starting at lines 1, 2)"},
+		{name: "unique", args: map[string]any{"path": "main.go", "old_text": "b := 1\n", "new_text": "b := 5\n"}, contains: "Replaced 1 occurrence(s) in main.go at line(s) 2"},
+		{name: "replace all", args: map[string]any{"path": "main.go", "old_text": " := ", "new_text": " = ", "replace_all": true}, contains: "Replaced 3 occurrence(s)"},
+		{name: "missing file", args: map[string]any{"path": "other.go", "old_text": "a", "new_text": "b"}, isErr: true, contains: "Failed to read file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, isErr := callTool(t, edit, "s", tt.args)
+			if isErr != tt.isErr || !strings.Contains(out, tt.contains) {
+				t.Errorf("unexpected result: %v %s", isErr, out)
+			}
+		})
+	}
+
+	if out, _ := callTool(t, handleReadFile(ws), "s", map[string]any{"path": "main.go"}); out != "a = 1\nb = 5\nc = 2\n" {
+		t.Errorf("unexpected content: %q", out)
+	}
+}
+
+func TestReadFileLines(t *testing.T) {
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := handleReadFile(ws)
+	if out, isErr := callTool(t, handleWriteFile(ws), "s", map[string]any{"path": "f.txt", "content": "one\ntwo\nthree\n"}); isErr {
+		t.Fatal(out)
+	}
+
+	if out, _ := callTool(t, read, "s", map[string]any{"path": "f.txt", "start_line": 2}); out != "     2\ttwo\n     3\tthree\n" {
+		t.Errorf("unexpected lines: %q", out)
+	}
+	if out, _ := callTool(t, read, "s", map[string]any{"path": "f.txt", "end_line": 1}); out != "     1\tone\n" {
+		t.Errorf("unexpected lines: %q", out)
+	}
+	if out, isErr := callTool(t, read, "s", map[string]any{"path": "f.txt", "start_line": 5}); !isErr || !strings.Contains(out, "it has 3 lines") {
+		t.Errorf("expected a range error, got %q", out)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := handleApplyPatch(ws)
+	write := handleWriteFile(ws)
+	for path, content := range map[string]string{
+		"a.txt":   "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+		"old.txt": "bye\n",
+	} {
+		if out, isErr := callTool(t, write, "s", map[string]any{"path": path, "content": content}); isErr {
+			t.Fatal(out)
+		}
+	}
+
+	// The line numbers of the second hunk are off by one, it is found nearby
+	patch := `diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ 1
+-2
++two
+ 3
+@@ -7,3 +7,4 @@
+ 8
+ 9
++9.5
+ 10
+--- /dev/null
++++ b/dir/new.txt
+@@ -0,0 +1,2 @@
++hello
++world
+--- a/old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+`
+	out, isErr := callTool(t, apply, "s", map[string]any{"patch": patch})
+	if isErr {
+		t.Fatal(out)
+	}
+	if out != "Patch applied: patched a.txt (2 hunks), created dir/new.txt, deleted old.txt" {
+		t.Errorf("unexpected result: %s", out)
+	}
+
+	dir := filepath.Join(ws.root, "s")
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(b) != "1\ntwo\n3\n4\n5\n6\n7\n8\n9\n9.5\n10\n" {
+		t.Errorf("unexpected patched file: %q", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "dir", "new.txt")); string(b) != "hello\nworld\n" {
+		t.Errorf("unexpected created file: %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be deleted, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		patch    string
+		contains string
+	}{
+		{name: "not a diff", patch: "just text", contains: "no file changes found"},
+		{name: "mismatch", patch: "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n 1\n-2\n+3\n", contains: `line 2 is "two" but the hunk expects "2"`},
+		{name: "escape", patch: "--- a/../x.txt\n+++ b/../x.txt\n@@ -1 +1 @@\n-a\n+b\n", contains: "path traversal attempt detected"},
+		{name: "exists", patch: "--- /dev/null\n+++ b/a.txt\n@@ -0,0 +1 @@\n+x\n", contains: "a.txt already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, isErr := callTool(t, apply, "s", map[string]any{"patch": tt.patch})
+			if !isErr || !strings.Contains(out, tt.contains) {
+				t.Errorf("unexpected result: %v %s", isErr, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// commandLimits caps the resources of the execute_command runs
+type commandLimits struct {
+	Timeout     time.Duration // Wall-clock limit of a run, the whole process group is killed after it
+	MaxOutput   int           // Max bytes of the combined output returned, the middle is cut out above it
+	CPUSeconds  int           // CPU time limit of the processes, 0 means unlimited
+	MemoryBytes int64         // Virtual memory limit of the processes, 0 means unlimited
+	FileBytes   int64         // Max size of a file written by the processes, set from the free space of the workspace, 0 means unlimited
+}
+
+// limitedOutput keeps the head and the tail of the written bytes
+type limitedOutput struct {
+	max   int
+	head  []byte
+	tail  []byte // Ring buffer of the last bytes, start is the oldest byte
+	start int
+	total int
+}
+
+func newLimitedOutput(max int) *limitedOutput {
+	return &limitedOutput{max: max}
+}
+
+// Write never fails, so the commands are not blocked or broken by the limit
+func (o *limitedOutput) Write(p []byte) (int, error) {
+	n := len(p)
+	o.total += n
+
+	if room := o.max/2 + o.max%2 - len(o.head); room > 0 {
+		k := min(room, len(p))
+		o.head = append(o.head, p[:k]...)
+		p = p[k:]
+	}
+
+	tailSize := o.max / 2
+	if tailSize == 0 {
+		return n, nil
+	}
+	if len(p) > tailSize {
+		p = p[len(p)-tailSize:]
+	}
+	for _, b := range p {
+		if len(o.tail) < tailSize {
+			o.tail = append(o.tail, b)
+			continue
+		}
+		o.tail[o.start] = b
+		o.start = (o.start + 1) % tailSize
+	}
+
+	return n, nil
+}
+
+// String returns the output with a marker in place of the dropped bytes
+func (o *limitedOutput) String() string {
+	tail := append(append([]byte{}, o.tail[o.start:]...), o.tail[:o.start]...)
+	dropped := o.total - len(o.head) - len(tail)
+	if dropped == 0 {
+		return string(o.head) + string(tail)
+	}
+
+	return fmt.Sprintf("%s\n... [truncated %d bytes] ...\n%s", o.head, dropped, tail)
+}
+
+// shellScript prepends the ulimit calls of the limits, the command is not run if they fail
+func shellScript(command string, limits commandLimits) string {
+	var script string
+	if limits.CPUSeconds > 0 {
+		script += fmt.Sprintf("ulimit -t %d || exit 126\n", limits.CPUSeconds)
+	}
+	if limits.MemoryBytes > 0 {
+		script += fmt.Sprintf("ulimit -v %d || exit 126\n", limits.MemoryBytes/1024)
+	}
+	if limits.FileBytes > 0 {
+		// The file size is in 512-byte blocks for sh
+		script += fmt.Sprintf("ulimit -f %d || exit 126\n", max(limits.FileBytes/512, 1))
+	}
+
+	return script + command
+}
+
+// runCommand runs the command with sh -c in the directory within the limits, it returns the output and whether the timeout was hit
+// A nil env means the environment of the server.
+func runCommand(ctx context.Context, dir, command string, env []string, limits commandLimits) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+
+	out := newLimitedOutput(limits.MaxOutput)
+	cmd := exec.CommandContext(ctx, "sh", "-c", shellScript(command, limits))
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	killProcessGroup(cmd)
+	// The background children could keep the output open after the kill
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	killGroup(cmd) // The background children must not outlive the call
+	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+
+	return out.String(), timedOut, err
+}
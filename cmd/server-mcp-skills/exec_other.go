@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killProcessGroup only kills the shell, the process groups are unix only
+func killProcessGroup(cmd *exec.Cmd) {}
+
+// killGroup is a no-op without the process groups
+func killGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitedOutput(t *testing.T) {
+	out := newLimitedOutput(10)
+	_, _ = out.Write([]byte("0123"))
+	if got := out.String(); got != "0123" {
+		t.Errorf("unexpected short output: %q", got)
+	}
+
+	for _, chunk := range []string{"4567", "89abcdef", "ghij"} {
+		_, _ = out.Write([]byte(chunk))
+	}
+	if got := out.String(); got != "01234\n... [truncated 10 bytes] ...\nfghij" {
+		t.Errorf("unexpected truncated output: %q", got)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	limits := commandLimits{Timeout: 5 * time.Second, MaxOutput: 100}
+
+	out, timedOut, err := runCommand(context.Background(), t.TempDir(), "yes | head -c 1000", nil, limits)
+	if err != nil || timedOut {
+		t.Fatalf("unexpected result: %v %v", err, timedOut)
+	}
+	if !strings.Contains(out, "[truncated 900 bytes]") {
+		t.Errorf("expected truncated output, got %q", out)
+	}
+
+	// The background child is in the process group, it is killed too
+	limits.Timeout = 200 * time.Millisecond
+	start := time.Now()
+	out, timedOut, err = runCommand(context.Background(), t.TempDir(), "echo started; sleep 30 & sleep 30", nil, limits)
+	if err == nil || !timedOut {
+		t.Fatalf("expected a timeout, got %v %v", err, timedOut)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("the command was not killed in time: %s", time.Since(start))
+	}
+	if out != "started\n" {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	// The children left in the background are killed after the shell exited
+	limits.Timeout = 5 * time.Second
+	out, _, err = runCommand(context.Background(), t.TempDir(), "sleep 30 >/dev/null 2>&1 & echo $!", nil, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("/proc/self"); err == nil && processAlive(strings.TrimSpace(out)) {
+		t.Errorf("the background child %s is still running", strings.TrimSpace(out))
+	}
+
+	limits = commandLimits{Timeout: 5 * time.Second, MaxOutput: 100, CPUSeconds: 1}
+	if out, _, err = runCommand(context.Background(), t.TempDir(), "ulimit -t", nil, limits); err != nil || strings.TrimSpace(out) != "1" {
+		t.Errorf("expected the CPU limit, got %q %v", out, err)
+	}
+}
+
+// processAlive reports whether the process is running after a short wait, the zombies count as stopped
+func processAlive(pid string) bool {
+	for range 20 {
+		stat, err := os.ReadFile("/proc/" + pid + "/stat")
+		if err != nil {
+			return false
+		}
+		// The state follows the command name in parentheses
+		if _, state, _ := strings.Cut(string(stat), ") "); strings.HasPrefix(state, "Z") {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return true
+}
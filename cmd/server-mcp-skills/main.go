@@ -7,6 +7,8 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -19,13 +21,47 @@ func main() {
 	var disableReadFile bool
 	var disableWriteFile bool
 	var disableExecuteCommand bool
+	var disableSearch bool
+	var disableProcesses bool
+	var commandTimeout time.Duration
+	var maxOutput int
+	var cpuLimit int
+	var memoryLimitMB int
+	var workspaceQuotaMB int
+	var workspaceTTL time.Duration
+	var policyFile string
+	var maxProcesses int
+	var processBuffer int
+	var auditFile string
+	var authToken string
+	var tlsCert string
+	var tlsKey string
+	var tlsClientCA string
+	var skillsDir string
 
 	flag.StringVar(&port, "port", "", "Port to listen on (default 8081 or PORT env var)")
 	flag.StringVar(&baseDir, "base-dir", "", "Base directory for file operations (default . or BASE_DIR env var)")
 	flag.BoolVar(&disableListFiles, "disable-list-files", false, "Disable the list_files tool (or DISABLE_LIST_FILES env var)")
 	flag.BoolVar(&disableReadFile, "disable-read-file", false, "Disable the read_file tool (or DISABLE_READ_FILE env var)")
-	flag.BoolVar(&disableWriteFile, "disable-write-file", false, "Disable the write_file tool (or DISABLE_WRITE_FILE env var)")
+	flag.BoolVar(&disableWriteFile, "disable-write-file", false, "Disable the write_file, edit_file and apply_patch tools (or DISABLE_WRITE_FILE env var)")
+	flag.BoolVar(&disableSearch, "disable-search", false, "Disable the search_files and glob_files tools (or DISABLE_SEARCH env var)")
 	flag.BoolVar(&disableExecuteCommand, "disable-execute-command", false, "Disable the execute_command tool (or DISABLE_EXECUTE_COMMAND env var)")
+	flag.BoolVar(&disableProcesses, "disable-processes", false, "Disable the background process tools (or DISABLE_PROCESSES env var)")
+	flag.DurationVar(&commandTimeout, "command-timeout", 0, "Wall-clock timeout of execute_command (default 60s or COMMAND_TIMEOUT env var)")
+	flag.IntVar(&maxOutput, "max-output", 0, "Max bytes of the execute_command output, the middle is truncated above it (default 65536 or MAX_OUTPUT_BYTES env var)")
+	flag.IntVar(&cpuLimit, "cpu-limit", 0, "CPU seconds limit of the executed commands, 0 means unlimited (or COMMAND_CPU_LIMIT env var)")
+	flag.IntVar(&memoryLimitMB, "memory-limit", 0, "Virtual memory limit of the executed commands in MB, 0 means unlimited (or COMMAND_MEMORY_LIMIT env var)")
+	flag.IntVar(&workspaceQuotaMB, "workspace-quota", 0, "Max size of a session workspace in MB, 0 means unlimited (or WORKSPACE_QUOTA env var)")
+	flag.DurationVar(&workspaceTTL, "workspace-ttl", 0, "Unused session workspaces are deleted after this, 0 means never (or WORKSPACE_TTL env var)")
+	flag.StringVar(&policyFile, "command-policy", "", "JSON policy file of the allowed and denied commands of execute_command (or COMMAND_POLICY env var)")
+	flag.IntVar(&maxProcesses, "max-processes", 0, "Max running background processes per session (default 5 or MAX_PROCESSES env var)")
+	flag.IntVar(&processBuffer, "process-buffer", 0, "Kept output bytes of a background process (default 1048576 or PROCESS_BUFFER_BYTES env var)")
+	flag.StringVar(&auditFile, "audit-log", "", "JSONL file of the tool calls, it is served on GET /audit (or AUDIT_LOG env var)")
+	flag.StringVar(&authToken, "auth-token", "", "Bearer token required from the clients (or MCP_AUTH_TOKEN env var)")
+	flag.StringVar(&tlsCert, "tls-cert", "", "TLS certificate file, it serves HTTPS if set (or TLS_CERT env var)")
+	flag.StringVar(&tlsKey, "tls-key", "", "TLS key file (or TLS_KEY env var)")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "CA file of the required client certificates, it enables mTLS (or TLS_CLIENT_CA env var)")
+	flag.StringVar(&skillsDir, "skills-dir", "", "Directory of the skill markdown files, published as prompts and resources (default skills or SKILLS_DIR env var)")
 	flag.Parse()
 
 	if port == "" {
@@ -50,59 +86,315 @@ func main() {
 	if !disableWriteFile {
 		disableWriteFile = os.Getenv("DISABLE_WRITE_FILE") == "true" || os.Getenv("DISABLE_WRITE_FILE") == "1"
 	}
+	if !disableSearch {
+		disableSearch = os.Getenv("DISABLE_SEARCH") == "true" || os.Getenv("DISABLE_SEARCH") == "1"
+	}
 	if !disableExecuteCommand {
 		disableExecuteCommand = os.Getenv("DISABLE_EXECUTE_COMMAND") == "true" || os.Getenv("DISABLE_EXECUTE_COMMAND") == "1"
 	}
+	if !disableProcesses {
+		disableProcesses = os.Getenv("DISABLE_PROCESSES") == "true" || os.Getenv("DISABLE_PROCESSES") == "1"
+	}
+
+	if commandTimeout == 0 {
+		commandTimeout = envDuration("COMMAND_TIMEOUT", time.Minute)
+	}
+	if maxOutput == 0 {
+		maxOutput = envInt("MAX_OUTPUT_BYTES", 64*1024)
+	}
+	if cpuLimit == 0 {
+		cpuLimit = envInt("COMMAND_CPU_LIMIT", 0)
+	}
+	if memoryLimitMB == 0 {
+		memoryLimitMB = envInt("COMMAND_MEMORY_LIMIT", 0)
+	}
+	if workspaceQuotaMB == 0 {
+		workspaceQuotaMB = envInt("WORKSPACE_QUOTA", 0)
+	}
+	if workspaceTTL == 0 {
+		workspaceTTL = envDuration("WORKSPACE_TTL", 0)
+	}
+	if maxProcesses == 0 {
+		maxProcesses = envInt("MAX_PROCESSES", 5)
+	}
+	if processBuffer == 0 {
+		processBuffer = envInt("PROCESS_BUFFER_BYTES", 1<<20)
+	}
+	if commandTimeout <= 0 || maxOutput <= 0 || maxProcesses <= 0 || processBuffer <= 0 {
+		slog.Error("the command timeout, the max output, the max processes and the process buffer must be positive")
+		os.Exit(1)
+	}
+	limits := commandLimits{
+		Timeout:     commandTimeout,
+		MaxOutput:   maxOutput,
+		CPUSeconds:  cpuLimit,
+		MemoryBytes: int64(memoryLimitMB) << 20,
+	}
+
+	if policyFile == "" {
+		policyFile = os.Getenv("COMMAND_POLICY")
+	}
+	var policy *commandPolicy
+	if policyFile != "" {
+		var err error
+		if policy, err = loadPolicy(policyFile); err != nil {
+			slog.Error("failed to load the command policy", slog.String("err", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	if skillsDir == "" {
+		skillsDir = os.Getenv("SKILLS_DIR")
+		if skillsDir == "" {
+			skillsDir = "skills"
+		}
+	}
+	skills, err := loadSkills(skillsDir)
+	if err != nil {
+		slog.Error("failed to load the skills", slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+
+	if auditFile == "" {
+		auditFile = os.Getenv("AUDIT_LOG")
+	}
+	if authToken == "" {
+		authToken = os.Getenv("MCP_AUTH_TOKEN")
+	}
+	if tlsCert == "" {
+		tlsCert = os.Getenv("TLS_CERT")
+	}
+	if tlsKey == "" {
+		tlsKey = os.Getenv("TLS_KEY")
+	}
+	if tlsClientCA == "" {
+		tlsClientCA = os.Getenv("TLS_CLIENT_CA")
+	}
+	if (tlsCert == "") != (tlsKey == "") || (tlsClientCA != "" && tlsCert == "") {
+		slog.Error("the TLS certificate and key must be set together, and the client CA needs both of them")
+		os.Exit(1)
+	}
+	tlsCfg, err := tlsConfig(tlsClientCA)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	if authToken == "" && tlsCfg == nil {
+		slog.Warn("no authentication is configured, anyone reaching the port could run commands")
+	}
+	var serverOpts []server.ServerOption
+	var audit *auditLog
+	if auditFile != "" {
+		if audit, err = openAuditLog(auditFile); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		serverOpts = append(serverOpts, server.WithToolHandlerMiddleware(audit.middleware))
+	}
+
+	processes := newProcessManager(processConfig{
+		MaxProcesses: maxProcesses,
+		BufferSize:   processBuffer,
+	}, limits, policy)
+
+	ws, err := newWorkspaces(baseDir, workspaceConfig{
+		Quota:    int64(workspaceQuotaMB) << 20,
+		TTL:      workspaceTTL,
+		OnExpire: processes.killSession,
+	})
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	ws.startCleanup(context.Background())
+
+	srv := server.NewMCPServer("Skills Server", "0.0.1", serverOpts...)
 
-	srv := server.NewMCPServer("Skills Server", "0.0.1")
+	// Register Skill Prompts and Resources
+	addSkills(srv, skills)
 
 	// Register List Files Tool
 	if !disableListFiles {
 		srv.AddTool(mcp.NewTool("list_files",
-			mcp.WithDescription("List files and directories in a given path relative to the base directory."),
+			mcp.WithDescription("List files and directories in a given path in the session workspace."),
 			mcp.WithString("path", mcp.Description("The directory path to list files from (default: current directory).")),
-		), handleListFiles(baseDir))
+		), handleListFiles(ws))
 	}
 
 	// Register Read File Tool
 	if !disableReadFile {
 		srv.AddTool(mcp.NewTool("read_file",
-			mcp.WithDescription("Read the contents of a file relative to the base directory."),
+			mcp.WithDescription("Read the contents of a file in the session workspace."),
 			mcp.WithString("path", mcp.Required(), mcp.Description("The file path to read.")),
-		), handleReadFile(baseDir))
+			mcp.WithNumber("start_line", mcp.Description("First line to read, starting from 1. If a line range is given, the lines are prefixed with their numbers.")),
+			mcp.WithNumber("end_line", mcp.Description("Last line to read, inclusive (default: the end of the file).")),
+		), handleReadFile(ws))
 	}
 
 	// Register Write File Tool
 	if !disableWriteFile {
 		srv.AddTool(mcp.NewTool("write_file",
-			mcp.WithDescription("Write content to a file relative to the base directory, creating directories as needed and overwriting existing files."),
+			mcp.WithDescription("Write content to a file in the session workspace, creating directories as needed and overwriting existing files."),
 			mcp.WithString("path", mcp.Required(), mcp.Description("The file path to write to.")),
 			mcp.WithString("content", mcp.Required(), mcp.Description("The content to write.")),
-		), handleWriteFile(baseDir))
+		), handleWriteFile(ws))
+	}
+
+	// Register Edit File Tool
+	if !disableWriteFile {
+		srv.AddTool(mcp.NewTool("edit_file",
+			mcp.WithDescription("Replace an exact text in a file in the session workspace. The old text must match the file exactly, including whitespace, and must be unique unless replace_all is set."),
+			mcp.WithString("path", mcp.Required(), mcp.Description("The file path to edit.")),
+			mcp.WithString("old_text", mcp.Required(), mcp.Description("The exact text to replace, include enough surrounding lines to make it unique.")),
+			mcp.WithString("new_text", mcp.Required(), mcp.Description("The replacement text, it could be empty to delete the old text.")),
+			mcp.WithBoolean("replace_all", mcp.Description("Replace every occurrence instead of requiring a unique match.")),
+		), handleEditFile(ws))
+	}
+
+	// Register Apply Patch Tool
+	if !disableWriteFile {
+		srv.AddTool(mcp.NewTool("apply_patch",
+			mcp.WithDescription("Apply a unified diff to the files in the session workspace. Use /dev/null as the old or new path to create or delete a file. Either every file is changed or none."),
+			mcp.WithString("patch", mcp.Required(), mcp.Description("The unified diff with --- and +++ file headers and @@ hunks.")),
+		), handleApplyPatch(ws))
+	}
+
+	// Register Search Tools
+	if !disableSearch {
+		srv.AddTool(mcp.NewTool("search_files",
+			mcp.WithDescription("Search a regexp in the file contents of the session workspace, like grep. The .gitignore files are respected and the binary files are skipped. The results are path:line:text, the context lines are path-line-text."),
+			mcp.WithString("pattern", mcp.Required(), mcp.Description("The regexp to search (Go RE2 syntax), it is matched against single lines.")),
+			mcp.WithString("path", mcp.Description("The directory or file to search in (default: current directory).")),
+			mcp.WithString("glob", mcp.Description("Only search the files matching this glob, e.g. *.go or src/**/*.ts.")),
+			mcp.WithNumber("context_lines", mcp.Description("Lines to show before and after the matches, at most 10 (default: 0).")),
+			mcp.WithNumber("max_matches", mcp.Description("Stop after this many matches, at most 1000 (default: 100).")),
+			mcp.WithBoolean("case_insensitive", mcp.Description("Ignore the case of the letters.")),
+		), handleSearchFiles(ws))
+
+		srv.AddTool(mcp.NewTool("glob_files",
+			mcp.WithDescription("Find files by a glob pattern in the session workspace. The .gitignore files are respected. The patterns without slash match the file names at any depth, ** matches any number of directories."),
+			mcp.WithString("pattern", mcp.Required(), mcp.Description("The glob, e.g. *.go or cmd/**/main.go.")),
+			mcp.WithString("path", mcp.Description("The directory to search in (default: current directory).")),
+			mcp.WithNumber("limit", mcp.Description("Max number of files to return, at most 5000 (default: 200).")),
+		), handleGlobFiles(ws))
 	}
 
 	// Register Execute Command Tool
 	if !disableExecuteCommand {
 		srv.AddTool(mcp.NewTool("execute_command",
-			mcp.WithDescription("Execute a shell command in the base directory and get the output. Supports pipes and redirections via sh -c."),
+			mcp.WithDescription("Execute a shell command in the session workspace and get the output. Supports pipes and redirections via sh -c. The command only starts in the workspace, it is not isolated from the rest of the server."),
 			mcp.WithString("command", mcp.Required(), mcp.Description("The shell command to execute.")),
-		), handleExecuteCommand(baseDir))
+			mcp.WithNumber("timeout", mcp.Description("Timeout in seconds, it could only be shorter than the server limit.")),
+		), handleExecuteCommand(ws, limits, policy))
+	}
+
+	// Register Process Tools
+	if !disableExecuteCommand && !disableProcesses {
+		srv.AddTool(mcp.NewTool("start_process",
+			mcp.WithDescription("Start a long running shell command in the background in the session workspace, e.g. a dev server or a build. Returns a process ID. The command only starts in the workspace, it is not isolated from the rest of the server."),
+			mcp.WithString("command", mcp.Required(), mcp.Description("The shell command to start.")),
+		), handleStartProcess(ws, processes))
+
+		srv.AddTool(mcp.NewTool("process_status",
+			mcp.WithDescription("Get the state, the exit code and the output size of a background process, or of every process of the session without an ID."),
+			mcp.WithString("id", mcp.Description("The process ID, every process is listed without it.")),
+		), handleProcessStatus(ws, processes))
+
+		srv.AddTool(mcp.NewTool("read_process_output",
+			mcp.WithDescription("Read the combined stdout and stderr of a background process from an offset. The result ends with the next offset to read from."),
+			mcp.WithString("id", mcp.Required(), mcp.Description("The process ID.")),
+			mcp.WithNumber("offset", mcp.Description("The byte offset to read from, 0 by default.")),
+			mcp.WithNumber("max_bytes", mcp.Description("Max bytes to read, 16384 by default.")),
+		), handleReadProcessOutput(ws, processes))
+
+		srv.AddTool(mcp.NewTool("write_process_stdin",
+			mcp.WithDescription("Write to the standard input of a background process."),
+			mcp.WithString("id", mcp.Required(), mcp.Description("The process ID.")),
+			mcp.WithString("input", mcp.Description("The text to write, include the newline if the process reads lines.")),
+			mcp.WithBoolean("close", mcp.Description("Close the standard input after writing.")),
+		), handleWriteProcessStdin(ws, processes))
+
+		srv.AddTool(mcp.NewTool("kill_process",
+			mcp.WithDescription("Kill a background process and its children. A process which already exited is removed."),
+			mcp.WithString("id", mcp.Required(), mcp.Description("The process ID.")),
+		), handleKillProcess(ws, processes))
 	}
 
 	// Setup Streamable HTTP Server
 	streamableSrv := server.NewStreamableHTTPServer(srv,
 		server.WithHTTPContextFunc(func(ctx context.Context, r *http.Request) context.Context {
-			return context.WithValue(ctx, "x-session-id", r.Header.Get("x-session-id"))
+			return context.WithValue(ctx, sessionIDKey, r.Header.Get("x-session-id"))
 		}))
 
 	slog.Info("starting Skills MCP Server",
 		slog.String("port", port),
 		slog.String("base-dir", baseDir),
+		slog.String("workspaces", ws.root),
+		slog.Duration("command-timeout", commandTimeout),
+		slog.String("command-policy", policyFile),
+		slog.String("audit-log", auditFile),
+		slog.Int("skills", len(skills)),
+		slog.Bool("token-auth", authToken != ""),
+		slog.Bool("tls", tlsCert != ""),
+		slog.Bool("mtls", tlsCfg != nil),
 	)
 
-	if err := streamableSrv.Start(":" + port); err != nil {
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", streamableSrv)
+	if audit != nil {
+		mux.HandleFunc("GET /audit", audit.handleQuery)
+	}
+
+	var handler http.Handler = mux
+	if authToken != "" {
+		handler = requireToken(authToken, mux)
+	}
+	httpSrv := &http.Server{
+		Addr:      ":" + port,
+		Handler:   handler,
+		TLSConfig: tlsCfg,
+	}
+
+	if tlsCert != "" {
+		err = httpSrv.ListenAndServeTLS(tlsCert, tlsKey)
+	} else {
+		err = httpSrv.ListenAndServe()
+	}
+	if err != nil {
 		if !errors.Is(err, http.ErrServerClosed) {
 			slog.Error(err.Error())
 		}
 	}
 }
+
+// envInt returns the integer env var, the default if it is unset, it exits on invalid values
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Error("invalid "+name, slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+
+	return i
+}
+
+// envDuration returns the duration env var, the default if it is unset, it exits on invalid values
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Error("invalid "+name, slog.String("err", err.Error()))
+		os.Exit(1)
+	}
+
+	return d
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// filePatch is the change of a single file in a unified diff, /dev/null paths mean creation or deletion
+type filePatch struct {
+	oldPath string
+	newPath string
+	hunks   []hunk
+}
+
+type hunk struct {
+	header   string
+	oldStart int
+	lines    []string // With the ' ', '-' or '+' prefix
+}
+
+// old returns the lines the hunk expects in the file
+func (h hunk) old() []string {
+	var lines []string
+	for _, l := range h.lines {
+		if l[0] != '+' {
+			lines = append(lines, l[1:])
+		}
+	}
+	return lines
+}
+
+// new returns the lines which replace the old ones
+func (h hunk) new() []string {
+	var lines []string
+	for _, l := range h.lines {
+		if l[0] != '-' {
+			lines = append(lines, l[1:])
+		}
+	}
+	return lines
+}
+
+// patchPath strips the timestamp and the a/ or b/ prefix of the git diffs
+func patchPath(s string) string {
+	s, _, _ = strings.Cut(s, "\t")
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return s
+	}
+	if rest, ok := strings.CutPrefix(s, "a/"); ok {
+		return rest
+	}
+	if rest, ok := strings.CutPrefix(s, "b/"); ok {
+		return rest
+	}
+	return s
+}
+
+// parsePatch parses a unified diff, the line counts of the hunk headers are not trusted, the hunks end at the first line which is not part of them
+func parsePatch(patch string) ([]filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+
+	var files []filePatch
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			files = append(files, filePatch{
+				oldPath: patchPath(line[4:]),
+				newPath: patchPath(lines[i+1][4:]),
+			})
+			i++
+
+		case strings.HasPrefix(line, "@@"):
+			if len(files) == 0 {
+				return nil, fmt.Errorf("line %d: hunk %q before the --- and +++ file header lines", i+1, line)
+			}
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: invalid hunk header %q, expected the form @@ -12,3 +12,4 @@", i+1, line)
+			}
+			oldStart, _ := strconv.Atoi(m[1])
+			h := hunk{header: m[0], oldStart: oldStart}
+
+			for i+1 < len(lines) {
+				next := lines[i+1]
+				if strings.HasPrefix(next, "@@") || (strings.HasPrefix(next, "--- ") && i+2 < len(lines) && strings.HasPrefix(lines[i+2], "+++ ")) {
+					break
+				}
+				if next == "" { // Some editors strip the space of the empty context lines
+					next = " "
+				}
+				if next[0] == '\\' { // \ No newline at end of file
+					i++
+					continue
+				}
+				if next[0] != ' ' && next[0] != '-' && next[0] != '+' {
+					break
+				}
+				h.lines = append(h.lines, next)
+				i++
+			}
+			// The empty lines at the end of the patch are not context
+			for len(h.lines) > 0 && h.lines[len(h.lines)-1] == " " && i == len(lines)-1 {
+				h.lines = h.lines[:len(h.lines)-1]
+			}
+			if len(h.lines) == 0 {
+				return nil, fmt.Errorf("line %d: empty hunk %q", i+1, line)
+			}
+
+			f := &files[len(files)-1]
+			f.hunks = append(f.hunks, h)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file changes found, the patch must be a unified diff with --- and +++ file header lines and @@ hunks")
+	}
+	for _, f := range files {
+		if len(f.hunks) == 0 && f.newPath != "/dev/null" {
+			return nil, fmt.Errorf("no hunks for %s", f.newPath)
+		}
+	}
+
+	return files, nil
+}
+
+// splitLines returns the lines of the content and whether it ends with a newline
+func splitLines(content string) ([]string, bool) {
+	if content == "" {
+		return nil, true
+	}
+	trailing := strings.HasSuffix(content, "\n")
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n"), trailing
+}
+
+func joinLines(lines []string, trailing bool) string {
+	s := strings.Join(lines, "\n")
+	if trailing && len(lines) > 0 {
+		s += "\n"
+	}
+	return s
+}
+
+func matchesAt(lines, want []string, at int) bool {
+	if at < 0 || at+len(want) > len(lines) {
+		return false
+	}
+	for i, w := range want {
+		if lines[at+i] != w {
+			return false
+		}
+	}
+	return true
+}
+
+// applyHunks applies the hunks in order, a hunk is searched around its line number if the earlier changes moved it
+func applyHunks(path, content string, hunks []hunk) (string, error) {
+	lines, trailing := splitLines(content)
+
+	offset := 0 // The line difference of the applied hunks
+	minPos := 0 // The hunks could not overlap
+	for n, h := range hunks {
+		old, repl := h.old(), h.new()
+
+		expected := max(h.oldStart-1, 0) + offset
+		if len(old) == 0 && h.oldStart > 0 { // Pure addition after the line
+			expected = h.oldStart + offset
+		}
+
+		pos := -1
+		for d := 0; pos < 0 && (expected-d >= minPos || expected+d <= len(lines)); d++ {
+			switch {
+			case expected-d >= minPos && matchesAt(lines, old, expected-d):
+				pos = expected - d
+			case expected+d >= minPos && matchesAt(lines, old, expected+d):
+				pos = expected + d
+			}
+		}
+		if pos < 0 {
+			return "", hunkMismatch(path, n+1, h, lines, old, expected)
+		}
+
+		lines = append(lines[:pos], append(repl, lines[pos+len(old):]...)...)
+		offset += len(repl) - len(old)
+		minPos = pos + len(repl)
+	}
+
+	return joinLines(lines, trailing), nil
+}
+
+// hunkMismatch describes the first line which differs at the expected position
+func hunkMismatch(path string, n int, h hunk, lines, old []string, expected int) error {
+	msg := fmt.Sprintf("hunk %d (%s) of %s does not apply", n, h.header, path)
+	for i, want := range old {
+		at := expected + i
+		if at >= len(lines) {
+			return fmt.Errorf("%s: the file has only %d lines, the hunk expects %q at line %d", msg, len(lines), want, at+1)
+		}
+		if lines[at] != want {
+			return fmt.Errorf("%s: line %d is %q but the hunk expects %q, and the context lines were not found elsewhere; read the file again and regenerate the patch", msg, at+1, lines[at], want)
+		}
+	}
+
+	return fmt.Errorf("%s: the context lines were not found; read the file again and regenerate the patch", msg)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// commandPolicy restricts the commands of execute_command, it is loaded from a JSON file
+type commandPolicy struct {
+	AllowedBinaries []string          `json:"allowedBinaries"` // Empty means every binary, the shell builtins are always allowed
+	DeniedBinaries  []string          `json:"deniedBinaries"`
+	DeniedPatterns  []string          `json:"deniedPatterns"` // Regexps matched against the whole command and the parsed words of every simple command, e.g. curl.*\|\s*sh
+	DenyNetwork     bool              `json:"denyNetwork"`    // Rejects the known network clients, it is a best-effort check, not a network sandbox
+	ScrubEnv        bool              `json:"scrubEnv"`       // Only the EnvAllow variables of the server are passed to the commands
+	EnvAllow        []string          `json:"envAllow"`       // A trailing * matches a prefix, empty means the defaultEnvAllow
+	Env             map[string]string `json:"env"`            // Set for every command
+
+	denied []*regexp.Regexp
+}
+
+var defaultEnvAllow = []string{"PATH", "HOME", "USER", "SHELL", "LANG", "LC_*", "TERM", "TZ", "TMPDIR"}
+
+// serverEnv are the credentials of the server, they are never passed to the commands
+var serverEnv = []string{"MCP_AUTH_TOKEN", "TLS_*"}
+
+var (
+	assignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+	// The words of the wrappers which are not the wrapped command, e.g. the options or the durations
+	wrapperArg = regexp.MustCompile(`^(-.*|[A-Za-z_][A-Za-z0-9_]*=.*|[0-9.]+[smhd]?|\{\})$`)
+	// devTCP is the network redirection of bash
+	devTCP = regexp.MustCompile(`/dev/(tcp|udp)/`)
+	// devicePaths could be used out of the workspace
+	devicePaths = map[string]bool{"/dev/null": true, "/dev/zero": true, "/dev/random": true, "/dev/urandom": true, "/dev/stdin": true, "/dev/stdout": true, "/dev/stderr": true}
+)
+
+var (
+	// reservedWords could start a simple command, the command name is after them
+	reservedWords = map[string]bool{"!": true, "{": true, "}": true, "if": true, "then": true, "else": true, "elif": true, "fi": true, "while": true, "until": true, "do": true, "done": true, "esac": true}
+	// compoundWords start the commands whose words are not command names
+	compoundWords = map[string]bool{"for": true, "case": true, "select": true, "function": true}
+	builtins      = map[string]bool{"cd": true, "echo": true, "printf": true, "export": true, "unset": true, "set": true, "true": true, "false": true, "exit": true, "pwd": true, "test": true, "[": true, "[[": true, "]]": true, "read": true, "wait": true, "shift": true, "return": true, "local": true, "break": true, "continue": true, ":": true}
+	wrappers      = map[string]bool{"env": true, "nohup": true, "nice": true, "timeout": true, "time": true, "xargs": true, "exec": true, "command": true, "sudo": true, "doas": true, "stdbuf": true, "busybox": true, "setsid": true, "ionice": true, "strace": true, "watch": true}
+	shells        = map[string]bool{"sh": true, "bash": true, "ash": true, "dash": true, "zsh": true, "ksh": true}
+	// networkBinaries are rejected in the network-deny mode
+	networkBinaries    = map[string]bool{"curl": true, "wget": true, "nc": true, "ncat": true, "netcat": true, "socat": true, "ssh": true, "scp": true, "sftp": true, "rsync": true, "telnet": true, "ftp": true, "ping": true, "nslookup": true, "dig": true, "host": true, "apk": true, "apt": true, "apt-get": true, "pip": true, "pip3": true, "npm": true, "npx": true, "yarn": true}
+	gitNetworkCommands = map[string]bool{"clone": true, "fetch": true, "pull": true, "push": true, "ls-remote": true, "submodule": true}
+	// gitOptionsWithValue are the global git options whose value is the next word, e.g. git -C dir pull
+	gitOptionsWithValue = map[string]bool{"-C": true, "-c": true, "--git-dir": true, "--work-tree": true, "--namespace": true, "--exec-path": true}
+)
+
+// loadPolicy reads the policy file and compiles its patterns
+func loadPolicy(path string) (*commandPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p commandPolicy
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse command policy: %w", err)
+	}
+	for _, pattern := range p.DeniedPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid denied pattern %q: %w", pattern, err)
+		}
+		p.denied = append(p.denied, re)
+	}
+	if p.ScrubEnv && len(p.EnvAllow) == 0 {
+		p.EnvAllow = defaultEnvAllow
+	}
+
+	return &p, nil
+}
+
+// check returns the reason of the rejection if the command is not allowed in the workspace directory
+func (p *commandPolicy) check(command, dir string) error {
+	if err := p.checkPatterns(command); err != nil {
+		return err
+	}
+	if p.DenyNetwork && devTCP.MatchString(command) {
+		return errors.New("network redirections are denied by the policy")
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	return p.checkCommandLine(command, dir, 0)
+}
+
+func (p *commandPolicy) checkCommandLine(command, dir string, depth int) error {
+	if depth > 5 {
+		return errors.New("the command is nested too deep to be checked")
+	}
+
+	commands, redirects, err := parseShell(command)
+	if err != nil {
+		return fmt.Errorf("the command could not be parsed: %w", err)
+	}
+	for _, args := range commands {
+		// The quotes and the escapes are removed by the parser, so 'rm' -rf / matches the patterns too
+		if err := p.checkPatterns(strings.Join(args, " ")); err != nil {
+			return err
+		}
+		if err := p.checkArgs(args, dir, depth); err != nil {
+			return fmt.Errorf("%w (in `%s`)", err, strings.Join(args, " "))
+		}
+		if p.restricted() {
+			words := args
+			// The binaries are checked by their names
+			if filepath.IsAbs(words[0]) {
+				words = words[1:]
+			}
+			if err := checkPaths(words, dir); err != nil {
+				return fmt.Errorf("%w (in `%s`)", err, strings.Join(args, " "))
+			}
+		}
+	}
+	if p.restricted() {
+		if err := checkPaths(redirects, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkPaths rejects the words which are paths out of the workspace, e.g. /etc/passwd, ../other or --out=/tmp/x
+// It is a best-effort check: the relative paths are resolved from the workspace, without following the cd commands or expanding the variables.
+func checkPaths(words []string, dir string) error {
+	for _, w := range words {
+		paths := []string{w}
+		if _, value, ok := strings.Cut(w, "="); ok {
+			paths = append(paths, value)
+		}
+		for _, path := range paths {
+			if outsideDir(path, dir) {
+				return fmt.Errorf("the path %q is out of the workspace, the policy only allows the paths in it", path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// outsideDir reports whether the path of the word is out of the directory
+func outsideDir(word, dir string) bool {
+	switch {
+	case devicePaths[word]:
+		return false
+	case strings.HasPrefix(word, "~"):
+		return true
+	case !filepath.IsAbs(word) && !slices.Contains(strings.Split(word, "/"), ".."):
+		return false
+	case !filepath.IsAbs(word):
+		word = filepath.Join(dir, word)
+	}
+
+	rel, err := filepath.Rel(dir, filepath.Clean(word))
+	return err != nil || rel == ".." || strings.HasPrefix(rel, "../")
+}
+
+func (p *commandPolicy) checkPatterns(command string) error {
+	for i, re := range p.denied {
+		if re.MatchString(command) {
+			return fmt.Errorf("the command matches the denied pattern %q", p.DeniedPatterns[i])
+		}
+	}
+
+	return nil
+}
+
+// restricted reports whether the command names are checked
+func (p *commandPolicy) restricted() bool {
+	return len(p.AllowedBinaries) > 0 || len(p.DeniedBinaries) > 0 || p.DenyNetwork
+}
+
+// checkArgs checks the command name of the simple command and the commands run by it, e.g. by sh -c or xargs
+func (p *commandPolicy) checkArgs(args []string, dir string, depth int) error {
+	for len(args) > 0 && (assignment.MatchString(args[0]) || reservedWords[args[0]]) {
+		args = args[1:]
+	}
+	if len(args) == 0 || compoundWords[args[0]] {
+		return nil
+	}
+
+	name := args[0]
+	if strings.Contains(name, "$") {
+		if p.restricted() {
+			return fmt.Errorf("the command name %q is not known before running it, use the binary name directly", name)
+		}
+		return nil
+	}
+	name = filepath.Base(name)
+	if err := p.checkBinary(name); err != nil {
+		return err
+	}
+
+	rest := args[1:]
+	switch {
+	case shells[name]:
+		for i, a := range rest {
+			if strings.HasPrefix(a, "-") && !strings.HasPrefix(a, "--") && strings.Contains(a, "c") && i+1 < len(rest) {
+				return p.checkCommandLine(rest[i+1], dir, depth+1)
+			}
+		}
+		// The commands of a script file or the standard input can't be checked
+		if p.restricted() {
+			return fmt.Errorf("%s could only run the commands given with -c under the policy, not a script file or the standard input", name)
+		}
+	case name == "cd" && len(rest) == 0:
+		if p.restricted() {
+			return errors.New("cd without a directory leaves the workspace")
+		}
+	case name == "source" || name == ".":
+		if p.restricted() {
+			return fmt.Errorf("the commands of the sourced script can't be checked by the policy")
+		}
+	case name == "eval":
+		return p.checkCommandLine(strings.Join(rest, " "), dir, depth+1)
+	case wrappers[name]:
+		for len(rest) > 0 && wrapperArg.MatchString(rest[0]) {
+			rest = rest[1:]
+		}
+		return p.checkArgs(rest, dir, depth+1)
+	case name == "find":
+		for i, a := range rest {
+			if a != "-exec" && a != "-execdir" && a != "-ok" {
+				continue
+			}
+			end := slices.IndexFunc(rest[i+1:], func(s string) bool { return s == ";" || s == "+" })
+			if end < 0 {
+				end = len(rest) - i - 1
+			}
+			if err := p.checkArgs(rest[i+1:i+1+end], dir, depth+1); err != nil {
+				return err
+			}
+		}
+	case name == "git" && p.DenyNetwork:
+		for i := 0; i < len(rest); i++ {
+			a := rest[i]
+			if gitOptionsWithValue[a] {
+				i++ // The value could be anything, e.g. git -C pull fetch
+				continue
+			}
+			if strings.HasPrefix(a, "-") {
+				continue
+			}
+			if gitNetworkCommands[a] {
+				return fmt.Errorf("git %s needs network access, which is denied by the policy", a)
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+func (p *commandPolicy) checkBinary(name string) error {
+	switch {
+	case slices.Contains(p.DeniedBinaries, name):
+		return fmt.Errorf("the binary %q is denied by the policy", name)
+	case p.DenyNetwork && networkBinaries[name]:
+		return fmt.Errorf("the binary %q needs network access, which is denied by the policy", name)
+	case len(p.AllowedBinaries) > 0 && !builtins[name] && !slices.Contains(p.AllowedBinaries, name):
+		return fmt.Errorf("the binary %q is not allowed, the allowed binaries are: %s", name, strings.Join(p.AllowedBinaries, ", "))
+	}
+
+	return nil
+}
+
+// environ returns the environment of the commands, the serverEnv variables are removed even without a policy
+func (p *commandPolicy) environ() []string {
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		name, _, _ := strings.Cut(kv, "=")
+		return matchEnv(serverEnv, name)
+	})
+	if p == nil {
+		return env
+	}
+
+	if p.ScrubEnv {
+		env = slices.DeleteFunc(env, func(kv string) bool {
+			name, _, _ := strings.Cut(kv, "=")
+			return !matchEnv(p.EnvAllow, name)
+		})
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(p.Env)) {
+		env = append(env, name+"="+p.Env[name])
+	}
+
+	return env
+}
+
+// matchEnv reports whether the variable is in the list, a trailing * matches a prefix
+func matchEnv(names []string, name string) bool {
+	return slices.ContainsFunc(names, func(pattern string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			return strings.HasPrefix(name, prefix)
+		}
+		return name == pattern
+	})
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ringBuffer keeps the last bytes of the output, the offsets count every byte written since the start
+// The byte of an offset is at offset % size, the buffer grows up to the size and then it is overwritten in place.
+type ringBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	size  int
+	total int64
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size}
+}
+
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	if r.total+int64(n) <= int64(r.size) {
+		r.buf = append(r.buf, p...)
+		r.total += int64(n)
+		return n, nil
+	}
+
+	if len(r.buf) < r.size {
+		r.buf = append(r.buf, make([]byte, r.size-len(r.buf))...)
+	}
+	// Only the last size bytes are kept from a big write
+	if len(p) > r.size {
+		p = p[len(p)-r.size:]
+	}
+	start := r.total + int64(n-len(p))
+	k := copy(r.buf[start%int64(r.size):], p)
+	copy(r.buf, p[k:])
+	r.total += int64(n)
+
+	return n, nil
+}
+
+// read returns at most max bytes from the offset, the next offset and the number of the dropped bytes before the kept ones
+func (r *ringBuffer) read(offset int64, max int) ([]byte, int64, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	oldest := r.total - min(r.total, int64(r.size))
+	var dropped int64
+	if offset < oldest {
+		dropped = oldest - offset
+		offset = oldest
+	}
+	if offset > r.total {
+		offset = r.total
+	}
+
+	data := make([]byte, min(r.total-offset, int64(max)))
+	if len(data) > 0 {
+		k := copy(data, r.buf[offset%int64(r.size):])
+		copy(data[k:], r.buf)
+	}
+
+	return data, offset + int64(len(data)), dropped
+}
+
+func (r *ringBuffer) written() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.total
+}
+
+// process is a background command of a session
+type process struct {
+	id        string
+	session   string
+	command   string
+	startedAt time.Time
+
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	stdin  io.WriteCloser
+	output *ringBuffer
+
+	done       chan struct{} // Closed when the process exited
+	exitCode   int
+	finishedAt time.Time
+}
+
+func (p *process) running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *process) status() string {
+	if p.running() {
+		return fmt.Sprintf("%s: running for %s, pid %d, %d output bytes, command: %s", p.id, time.Since(p.startedAt).Round(time.Second), p.cmd.Process.Pid, p.output.written(), p.command)
+	}
+
+	return fmt.Sprintf("%s: exited with code %d after %s, %d output bytes, command: %s", p.id, p.exitCode, p.finishedAt.Sub(p.startedAt).Round(time.Millisecond), p.output.written(), p.command)
+}
+
+// processConfig .
+type processConfig struct {
+	MaxProcesses int // Running processes per session, the same number of exited ones are kept for reading their output
+	BufferSize   int // Kept output bytes per process
+}
+
+// processManager runs the background processes, every process belongs to the session which started it
+type processManager struct {
+	cfg    processConfig
+	limits commandLimits // Only the CPU and memory limits apply, the processes have no timeout
+	policy *commandPolicy
+
+	mu        sync.Mutex
+	processes map[string]*process
+}
+
+func newProcessManager(cfg processConfig, limits commandLimits, policy *commandPolicy) *processManager {
+	return &processManager{
+		cfg:       cfg,
+		limits:    limits,
+		policy:    policy,
+		processes: make(map[string]*process),
+	}
+}
+
+// start runs the command in the directory, the files written by it are limited to the fileBytes
+func (m *processManager) start(sessionID, dir, command string, fileBytes int64) (*process, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	running := 0
+	var exited []*process
+	for _, p := range m.processes {
+		if p.session != sessionID {
+			continue
+		}
+		if p.running() {
+			running++
+		} else {
+			exited = append(exited, p)
+		}
+	}
+	if running >= m.cfg.MaxProcesses {
+		return nil, fmt.Errorf("the session already has %d running processes, kill one of them first", running)
+	}
+
+	// The oldest exited processes are dropped, so their output doesn't pile up
+	slices.SortFunc(exited, func(a, b *process) int { return a.finishedAt.Compare(b.finishedAt) })
+	for len(exited) >= m.cfg.MaxProcesses {
+		delete(m.processes, exited[0].id)
+		exited = exited[1:]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &process{
+		id:        strings.ToLower(rand.Text()[:8]),
+		session:   sessionID,
+		command:   command,
+		startedAt: time.Now(),
+		cancel:    cancel,
+		output:    newRingBuffer(m.cfg.BufferSize),
+		done:      make(chan struct{}),
+	}
+	limits := m.limits
+	limits.FileBytes = fileBytes
+	p.cmd = exec.CommandContext(ctx, "sh", "-c", shellScript(command, limits))
+	p.cmd.Dir = dir
+	p.cmd.Env = m.policy.environ()
+	p.cmd.Stdout = p.output
+	p.cmd.Stderr = p.output
+	killProcessGroup(p.cmd)
+	p.cmd.WaitDelay = time.Second
+
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	p.stdin = stdin
+	if err := p.cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start: %w", err)
+	}
+
+	go func() {
+		_ = p.cmd.Wait()
+		killGroup(p.cmd)
+		p.exitCode = p.cmd.ProcessState.ExitCode()
+		p.finishedAt = time.Now()
+		cancel()
+		close(p.done)
+	}()
+
+	m.processes[p.id] = p
+
+	return p, nil
+}
+
+// get returns the process if it belongs to the session
+func (m *processManager) get(sessionID, id string) (*process, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.processes[id]
+	if !ok || p.session != sessionID {
+		return nil, fmt.Errorf("unknown process %q, use process_status to list the processes of the session", id)
+	}
+
+	return p, nil
+}
+
+// list returns the processes of the session ordered by their start
+func (m *processManager) list(sessionID string) []*process {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var processes []*process
+	for _, p := range m.processes {
+		if p.session == sessionID {
+			processes = append(processes, p)
+		}
+	}
+	slices.SortFunc(processes, func(a, b *process) int { return a.startedAt.Compare(b.startedAt) })
+
+	return processes
+}
+
+// kill stops the process group and waits for the exit
+func (m *processManager) kill(p *process) {
+	p.cancel()
+	<-p.done
+}
+
+// forget removes the process, its output can't be read anymore
+func (m *processManager) forget(p *process) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.processes, p.id)
+}
+
+// killSession stops and forgets the processes of the session, e.g. when its workspace expires
+func (m *processManager) killSession(sessionID string) {
+	for _, p := range m.list(sessionID) {
+		slog.Info("stopping the process of an expired session", slog.String("session", sessionID), slog.String("process", p.id))
+		m.kill(p)
+		m.forget(p)
+	}
+}
+
+// handleStartProcess creates the handler for starting a background process.
+func handleStartProcess(ws *workspaces, m *processManager) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		cmdArg := req.GetString("command", "")
+		if cmdArg == "" {
+			return mcp.NewToolResultError("command parameter is required"), nil
+		}
+		slog.InfoContext(ctx, "audit: start_process called", slog.String("session", sessionID(ctx)), slog.String("command", cmdArg))
+
+		baseDir, err := ws.dir(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		if m.policy != nil {
+			if err := m.policy.check(cmdArg, baseDir); err != nil {
+				slog.WarnContext(ctx, "audit: start_process rejected", slog.String("session", sessionID(ctx)), slog.String("reason", err.Error()))
+				return mcp.NewToolResultError(fmt.Sprintf("Command rejected by the policy: %v", err)), nil
+			}
+		}
+
+		fileBytes, err := ws.fileLimit(baseDir)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		p, err := m.start(sessionID(ctx), baseDir, cmdArg, fileBytes)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to start process: %v", err)), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("Started process %s (pid %d), use read_process_output to see its output", p.id, p.cmd.Process.Pid)), nil
+	}
+}
+
+// handleProcessStatus creates the handler for the status of one or every process of the session.
+func handleProcessStatus(ws *workspaces, m *processManager) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		idArg := req.GetString("id", "")
+		slog.InfoContext(ctx, "audit: process_status called", slog.String("session", sessionID(ctx)), slog.String("id", idArg))
+
+		// The use of the processes keeps the workspace from expiring
+		if _, err := ws.dir(ctx); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		if idArg != "" {
+			p, err := m.get(sessionID(ctx), idArg)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			return mcp.NewToolResultText(p.status()), nil
+		}
+
+		processes := m.list(sessionID(ctx))
+		if len(processes) == 0 {
+			return mcp.NewToolResultText("No processes"), nil
+		}
+		lines := make([]string, 0, len(processes))
+		for _, p := range processes {
+			lines = append(lines, p.status())
+		}
+
+		return mcp.NewToolResultText(strings.Join(lines, "\n")), nil
+	}
+}
+
+// handleReadProcessOutput creates the handler for reading the output of a process from an offset.
+func handleReadProcessOutput(ws *workspaces, m *processManager) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		idArg := req.GetString("id", "")
+		offset := max(int64(req.GetInt("offset", 0)), 0)
+		maxBytes := min(max(req.GetInt("max_bytes", 16*1024), 1), 256*1024)
+		slog.InfoContext(ctx, "audit: read_process_output called", slog.String("session", sessionID(ctx)), slog.String("id", idArg), slog.Int64("offset", offset))
+
+		if _, err := ws.dir(ctx); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		p, err := m.get(sessionID(ctx), idArg)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		// The state is read first, so no output is missed after an exited status
+		running := p.running()
+		data, next, dropped := p.output.read(offset, maxBytes)
+
+		var b strings.Builder
+		if dropped > 0 {
+			fmt.Fprintf(&b, "[%d older bytes were dropped from the buffer]\n", dropped)
+		}
+		b.Write(data)
+		state := "running"
+		if !running {
+			state = fmt.Sprintf("exited with code %d", p.exitCode)
+		}
+		more := ""
+		if next < p.output.written() {
+			more = ", more output is available"
+		}
+		fmt.Fprintf(&b, "\n[next offset: %d, process %s%s]", next, state, more)
+
+		return mcp.NewToolResultText(b.String()), nil
+	}
+}
+
+// handleWriteProcessStdin creates the handler for writing to the standard input of a process.
+func handleWriteProcessStdin(ws *workspaces, m *processManager) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		idArg := req.GetString("id", "")
+		inputArg := req.GetString("input", "")
+		closeArg := req.GetBool("close", false)
+		slog.InfoContext(ctx, "audit: write_process_stdin called", slog.String("session", sessionID(ctx)), slog.String("id", idArg), slog.Int("input_len", len(inputArg)))
+
+		if _, err := ws.dir(ctx); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		p, err := m.get(sessionID(ctx), idArg)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if !p.running() {
+			return mcp.NewToolResultError(fmt.Sprintf("process %s already exited with code %d", p.id, p.exitCode)), nil
+		}
+
+		if inputArg != "" {
+			if _, err := io.WriteString(p.stdin, inputArg); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to write stdin: %v", err)), nil
+			}
+		}
+		if closeArg {
+			if err := p.stdin.Close(); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				return mcp.NewToolResultError(fmt.Sprintf("Failed to close stdin: %v", err)), nil
+			}
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("Wrote %d bytes to process %s", len(inputArg), p.id)), nil
+	}
+}
+
+// handleKillProcess creates the handler for stopping a process.
+func handleKillProcess(ws *workspaces, m *processManager) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		idArg := req.GetString("id", "")
+		slog.InfoContext(ctx, "audit: kill_process called", slog.String("session", sessionID(ctx)), slog.String("id", idArg))
+
+		if _, err := ws.dir(ctx); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		p, err := m.get(sessionID(ctx), idArg)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		if !p.running() {
+			m.forget(p)
+			return mcp.NewToolResultText(fmt.Sprintf("Process %s had already exited with code %d, it was removed", p.id, p.exitCode)), nil
+		}
+		m.kill(p)
+
+		return mcp.NewToolResultText(fmt.Sprintf("Killed process %s, its output can still be read, kill it again to remove it", p.id)), nil
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRingBuffer(t *testing.T) {
+	r := newRingBuffer(8)
+	_, _ = r.Write([]byte("0123"))
+	if data, next, dropped := r.read(0, 100); string(data) != "0123" || next != 4 || dropped != 0 {
+		t.Errorf("unexpected read: %q %d %d", data, next, dropped)
+	}
+
+	_, _ = r.Write([]byte("456789ab"))
+	if data, next, dropped := r.read(2, 3); string(data) != "456" || next != 7 || dropped != 2 {
+		t.Errorf("unexpected read after the overflow: %q %d %d", data, next, dropped)
+	}
+	if data, next, _ := r.read(10, 100); string(data) != "ab" || next != 12 {
+		t.Errorf("unexpected tail read: %q %d", data, next)
+	}
+	if data, next, _ := r.read(50, 100); len(data) != 0 || next != 12 {
+		t.Errorf("unexpected read after the end: %q %d", data, next)
+	}
+
+	// The small writes wrap around, a big one keeps only its end
+	for _, s := range []string{"cd", "ef", "gh"} {
+		_, _ = r.Write([]byte(s))
+	}
+	if data, next, dropped := r.read(0, 100); string(data) != "abcdefgh" || next != 18 || dropped != 10 {
+		t.Errorf("unexpected read after the wrap: %q %d %d", data, next, dropped)
+	}
+	_, _ = r.Write([]byte("ABCDEFGHIJKL"))
+	if data, next, _ := r.read(20, 100); string(data) != "EFGHIJKL" || next != 30 {
+		t.Errorf("unexpected read after the big write: %q %d", data, next)
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newProcessManager(processConfig{MaxProcesses: 1, BufferSize: 1024}, commandLimits{}, nil)
+
+	out, isErr := callTool(t, handleStartProcess(ws, m), "s1", map[string]any{"command": "read line; echo got $line; sleep 30"})
+	if isErr {
+		t.Fatalf("failed to start: %s", out)
+	}
+	id := strings.Fields(out)[2]
+
+	if out, isErr = callTool(t, handleStartProcess(ws, m), "s1", map[string]any{"command": "true"}); !isErr {
+		t.Errorf("expected the process limit, got %q", out)
+	}
+	if out, isErr = callTool(t, handleProcessStatus(ws, m), "s2", map[string]any{"id": id}); !isErr {
+		t.Errorf("expected an unknown process for another session, got %q", out)
+	}
+
+	if out, isErr = callTool(t, handleWriteProcessStdin(ws, m), "s1", map[string]any{"id": id, "input": "hello\n"}); isErr {
+		t.Fatalf("failed to write: %s", out)
+	}
+	waitFor(t, func() bool {
+		out, _ = callTool(t, handleReadProcessOutput(ws, m), "s1", map[string]any{"id": id})
+		return strings.HasPrefix(out, "got hello\n")
+	})
+	if !strings.Contains(out, "[next offset: 10, process running]") {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	if out, isErr = callTool(t, handleKillProcess(ws, m), "s1", map[string]any{"id": id}); isErr {
+		t.Fatalf("failed to kill: %s", out)
+	}
+	if out, _ = callTool(t, handleProcessStatus(ws, m), "s1", nil); !strings.Contains(out, id+": exited") {
+		t.Errorf("unexpected status after the kill: %q", out)
+	}
+	if out, _ = callTool(t, handleReadProcessOutput(ws, m), "s1", map[string]any{"id": id, "offset": 4}); !strings.HasPrefix(out, "hello\n") {
+		t.Errorf("unexpected output from the offset: %q", out)
+	}
+
+	// A second kill removes the exited process
+	callTool(t, handleKillProcess(ws, m), "s1", map[string]any{"id": id})
+	if out, _ = callTool(t, handleProcessStatus(ws, m), "s1", nil); out != "No processes" {
+		t.Errorf("expected no processes, got %q", out)
+	}
+}
+
+func TestProcessesExpire(t *testing.T) {
+	m := newProcessManager(processConfig{MaxProcesses: 5, BufferSize: 1024}, commandLimits{}, nil)
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{TTL: time.Hour, OnExpire: m.killSession})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, isErr := callTool(t, handleStartProcess(ws, m), "old", map[string]any{"command": "sleep 30"}); isErr {
+		t.Fatalf("failed to start: %s", out)
+	}
+	p := m.list("old")[0]
+
+	ws.cleanup(time.Now().Add(2 * time.Hour))
+
+	if p.running() {
+		t.Error("expected the process to be killed")
+	}
+	if len(m.list("old")) != 0 {
+		t.Error("expected the process to be removed")
+	}
+}
+
+func TestProcessesRetention(t *testing.T) {
+	m := newProcessManager(processConfig{MaxProcesses: 1, BufferSize: 1024}, commandLimits{}, nil)
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{TTL: time.Hour, OnExpire: m.killSession})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the last exited process is kept
+	for range 3 {
+		if out, isErr := callTool(t, handleStartProcess(ws, m), "s1", map[string]any{"command": "true"}); isErr {
+			t.Fatalf("failed to start: %s", out)
+		}
+		waitFor(t, func() bool { return !m.list("s1")[len(m.list("s1"))-1].running() })
+	}
+	if n := len(m.list("s1")); n != 1 {
+		t.Errorf("expected 1 kept process, got %d", n)
+	}
+
+	// Polling the process keeps the workspace
+	if out, isErr := callTool(t, handleStartProcess(ws, m), "s1", map[string]any{"command": "sleep 30"}); isErr {
+		t.Fatalf("failed to start: %s", out)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(ws.root, "s1"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	callTool(t, handleProcessStatus(ws, m), "s1", nil)
+	ws.cleanup(time.Now())
+
+	processes := m.list("s1")
+	if len(processes) != 1 || !processes[0].running() {
+		t.Fatalf("expected the polled process to keep running, got %d processes", len(processes))
+	}
+	m.killSession("s1")
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
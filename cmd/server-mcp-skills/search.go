@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	maxSearchFileSize = 10 << 20 // Bigger files are skipped by search_files
+	maxLineLength     = 500      // Longer lines are cut in the search results
+)
+
+// errStopWalk stops the walk when the limit is reached
+var errStopWalk = errors.New("stop walk")
+
+// globRegexp converts a glob to a regexp, * and ? don't match /, ** matches any number of directories
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			b.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed [ in %q", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// gitignore holds the rules of the .gitignore files by their directory relative to the base
+type gitignore struct {
+	rules map[string][]ignoreRule
+}
+
+func (g *gitignore) load(baseDir, relDir string) {
+	b, err := os.ReadFile(filepath.Join(baseDir, filepath.FromSlash(relDir), ".gitignore"))
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var r ignoreRule
+		if line[0] == '!' {
+			r.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// The patterns without slash match at any depth, the others are relative to the .gitignore
+		if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		re, err := globRegexp(strings.TrimPrefix(line, "/"))
+		if err != nil {
+			continue
+		}
+		r.re = re
+		g.rules[relDir] = append(g.rules[relDir], r)
+	}
+}
+
+// ignored applies the rules from the root to the deepest .gitignore, the last matching rule wins
+func (g *gitignore) ignored(rel string, isDir bool) bool {
+	dirs := []string{"."}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		dirs = append(dirs, strings.Join(parts[:i], "/"))
+	}
+
+	ignored := false
+	for _, dir := range dirs {
+		sub := rel
+		if dir != "." {
+			sub = rel[len(dir)+1:]
+		}
+		for _, r := range g.rules[dir] {
+			if (!r.dirOnly || isDir) && r.re.MatchString(sub) {
+				ignored = !r.negate
+			}
+		}
+	}
+
+	return ignored
+}
+
+// walkFiles calls the function for the regular files under the start path which are not ignored by .gitignore, the paths are relative to the base
+func walkFiles(baseDir, start string, fn func(rel, path string) error) error {
+	relStart, err := filepath.Rel(baseDir, start)
+	if err != nil {
+		return err
+	}
+	relStart = filepath.ToSlash(relStart)
+
+	ig := &gitignore{rules: make(map[string][]ignoreRule)}
+	// The .gitignore files above the start directory apply too
+	if relStart != "." {
+		ig.load(baseDir, ".")
+		parts := strings.Split(relStart, "/")
+		for i := 1; i < len(parts); i++ {
+			ig.load(baseDir, strings.Join(parts[:i], "/"))
+		}
+	}
+
+	err = filepath.WalkDir(start, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == start {
+				return err
+			}
+			return nil // Skip the unreadable entries
+		}
+
+		rel, err := filepath.Rel(baseDir, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if path != start && (d.Name() == ".git" || ig.ignored(rel, true)) {
+				return filepath.SkipDir
+			}
+			ig.load(baseDir, rel)
+			return nil
+		}
+		if !d.Type().IsRegular() || (path != start && ig.ignored(rel, false)) {
+			return nil
+		}
+
+		return fn(rel, path)
+	})
+	if errors.Is(err, errStopWalk) {
+		return nil
+	}
+
+	return err
+}
+
+// isBinary reports whether the content has a NUL byte in its beginning
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
+
+func truncateLine(line string) string {
+	if len(line) > maxLineLength {
+		return line[:maxLineLength] + "..."
+	}
+	return line
+}
+
+// handleSearchFiles creates the handler for searching a regexp in the file contents.
+func handleSearchFiles(ws *workspaces) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		patternArg := req.GetString("pattern", "")
+		if patternArg == "" {
+			return mcp.NewToolResultError("pattern parameter is required"), nil
+		}
+		pathArg := req.GetString("path", ".")
+		globArg := req.GetString("glob", "")
+		contextLines := min(max(req.GetInt("context_lines", 0), 0), 10)
+		maxMatches := min(max(req.GetInt("max_matches", 100), 1), 1000)
+		slog.InfoContext(ctx, "audit: search_files called", slog.String("session", sessionID(ctx)), slog.String("pattern", patternArg), slog.String("path", pathArg))
+
+		if req.GetBool("case_insensitive", false) {
+			patternArg = "(?i)" + patternArg
+		}
+		re, err := regexp.Compile(patternArg)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid regexp (Go RE2 syntax): %v", err)), nil
+		}
+		var globRe *regexp.Regexp
+		if globArg != "" {
+			if globRe, err = fileGlob(globArg); err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("Invalid glob: %v", err)), nil
+			}
+		}
+
+		baseDir, err := ws.dir(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		safePath, err := ensureSafePath(baseDir, pathArg)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid path: %v", err)), nil
+		}
+
+		var out strings.Builder
+		matches := 0
+		stopped := false
+		err = walkFiles(baseDir, safePath, func(rel, path string) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if globRe != nil && !globRe.MatchString(rel) {
+				return nil
+			}
+			info, err := os.Stat(path)
+			if err != nil || info.Size() > maxSearchFileSize {
+				return nil
+			}
+			content, err := os.ReadFile(path)
+			if err != nil || isBinary(content) {
+				return nil
+			}
+
+			lines, _ := splitLines(string(content))
+			printed := -1 // The last printed line, the overlapping contexts are merged
+			for i, line := range lines {
+				if !re.MatchString(line) {
+					continue
+				}
+				if matches == maxMatches {
+					stopped = true
+					return errStopWalk
+				}
+				matches++
+
+				from := max(i-contextLines, printed+1)
+				if contextLines > 0 && out.Len() > 0 && (printed < 0 || from > printed+1) {
+					out.WriteString("--\n")
+				}
+				for j := from; j <= min(i+contextLines, len(lines)-1); j++ {
+					sep := "-"
+					if re.MatchString(lines[j]) {
+						sep = ":"
+					}
+					fmt.Fprintf(&out, "%s%s%d%s%s\n", rel, sep, j+1, sep, truncateLine(lines[j]))
+					printed = j
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to search files: %v", err)), nil
+		}
+
+		if matches == 0 {
+			return mcp.NewToolResultText("No matches found"), nil
+		}
+		if stopped {
+			fmt.Fprintf(&out, "[stopped after %d matches, narrow the pattern or the path to see more]\n", maxMatches)
+		}
+
+		return mcp.NewToolResultText(out.String()), nil
+	}
+}
+
+// fileGlob compiles the glob for the relative paths, the patterns without slash match the file name at any depth
+func fileGlob(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimPrefix(pattern, "./")
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+
+	return globRegexp(pattern)
+}
+
+// handleGlobFiles creates the handler for finding files by a glob pattern.
+func handleGlobFiles(ws *workspaces) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		patternArg := req.GetString("pattern", "")
+		if patternArg == "" {
+			return mcp.NewToolResultError("pattern parameter is required"), nil
+		}
+		pathArg := req.GetString("path", ".")
+		limit := min(max(req.GetInt("limit", 200), 1), 5000)
+		slog.InfoContext(ctx, "audit: glob_files called", slog.String("session", sessionID(ctx)), slog.String("pattern", patternArg), slog.String("path", pathArg))
+
+		re, err := fileGlob(patternArg)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid glob: %v", err)), nil
+		}
+
+		baseDir, err := ws.dir(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		safePath, err := ensureSafePath(baseDir, pathArg)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid path: %v", err)), nil
+		}
+
+		var files []string
+		truncated := false
+		err = walkFiles(baseDir, safePath, func(rel, path string) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !re.MatchString(rel) {
+				return nil
+			}
+			if len(files) == limit {
+				truncated = true
+				return errStopWalk
+			}
+			files = append(files, rel)
+			return nil
+		})
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Failed to find files: %v", err)), nil
+		}
+
+		if len(files) == 0 {
+			return mcp.NewToolResultText("No files found"), nil
+		}
+		result := strings.Join(files, "\n")
+		if truncated {
+			result += fmt.Sprintf("\n[stopped after %d files, narrow the pattern or the path to see more]", limit)
+		}
+
+		return mcp.NewToolResultText(result), nil
+	}
+}
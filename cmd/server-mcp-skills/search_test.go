@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{pattern: "*.go", path: "main.go", match: true},
+		{pattern: "*.go", path: "cmd/main.go", match: false},
+		{pattern: "**/*.go", path: "cmd/main.go", match: true},
+		{pattern: "**/*.go", path: "main.go", match: true},
+		{pattern: "cmd/**", path: "cmd/a/b.txt", match: true},
+		{pattern: "file?.[ch]", path: "file1.c", match: true},
+		{pattern: "file?.[!ch]", path: "file1.c", match: false},
+		{pattern: "a.b", path: "axb", match: false},
+	}
+	for _, tt := range tests {
+		re, err := globRegexp(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if re.MatchString(tt.path) != tt.match {
+			t.Errorf("%s on %s: expected %v", tt.pattern, tt.path, tt.match)
+		}
+	}
+}
+
+func newSearchWorkspace(t *testing.T) *workspaces {
+	t.Helper()
+
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(ws.root, "s")
+	for path, content := range map[string]string{
+		".gitignore":          "*.log\nbuild/\n!keep.log\n",
+		"main.go":             "package main\n\nfunc main() {\n\tprintln(\"hello\")\n}\n",
+		"pkg/util.go":         "package pkg\n\n// Hello says hello\nfunc Hello() {}\n",
+		"pkg/.gitignore":      "generated.go\n",
+		"pkg/generated.go":    "package pkg // hello\n",
+		"debug.log":           "hello\n",
+		"keep.log":            "hello\n",
+		"build/out.go":        "package build // hello\n",
+		"image.bin":           "hello\x00world",
+		".git/config":         "hello\n",
+		"docs/readme.md":      "Hello\n",
+		"docs/deep/guide.txt": "nothing\n",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return ws
+}
+
+func TestSearchFiles(t *testing.T) {
+	search := handleSearchFiles(newSearchWorkspace(t))
+
+	out, isErr := callTool(t, search, "s", map[string]any{"pattern": "hello"})
+	if isErr {
+		t.Fatal(out)
+	}
+	want := "keep.log:1:hello\nmain.go:4:\tprintln(\"hello\")\npkg/util.go:3:// Hello says hello\n"
+	if out != want {
+		t.Errorf("unexpected matches:\n%s", out)
+	}
+
+	out, _ = callTool(t, search, "s", map[string]any{"pattern": "hello", "path": "pkg", "case_insensitive": true, "context_lines": 1})
+	if out != "pkg/util.go-2-\npkg/util.go:3:// Hello says hello\npkg/util.go:4:func Hello() {}\n" {
+		t.Errorf("unexpected matches with context:\n%s", out)
+	}
+
+	out, _ = callTool(t, search, "s", map[string]any{"pattern": "(?i)hello", "glob": "*.md"})
+	if out != "docs/readme.md:1:Hello\n" {
+		t.Errorf("unexpected matches with glob:\n%s", out)
+	}
+
+	out, _ = callTool(t, search, "s", map[string]any{"pattern": "hello", "max_matches": 1})
+	if !strings.HasPrefix(out, "keep.log:1:hello\n[stopped after 1 matches") {
+		t.Errorf("expected the limit, got:\n%s", out)
+	}
+
+	if out, isErr := callTool(t, search, "s", map[string]any{"pattern": "hello", "path": "../other"}); !isErr || !strings.Contains(out, "path traversal") {
+		t.Errorf("expected a path error, got %s", out)
+	}
+	if out, isErr := callTool(t, search, "s", map[string]any{"pattern": "("}); !isErr || !strings.Contains(out, "Invalid regexp") {
+		t.Errorf("expected a regexp error, got %s", out)
+	}
+}
+
+func TestGlobFiles(t *testing.T) {
+	glob := handleGlobFiles(newSearchWorkspace(t))
+
+	if out, _ := callTool(t, glob, "s", map[string]any{"pattern": "*.go"}); out != "main.go\npkg/util.go" {
+		t.Errorf("unexpected files:\n%s", out)
+	}
+	if out, _ := callTool(t, glob, "s", map[string]any{"pattern": "docs/**"}); out != "docs/deep/guide.txt\ndocs/readme.md" {
+		t.Errorf("unexpected files:\n%s", out)
+	}
+	if out, _ := callTool(t, glob, "s", map[string]any{"pattern": "*", "limit": 2}); !strings.HasSuffix(out, "[stopped after 2 files, narrow the pattern or the path to see more]") {
+		t.Errorf("expected the limit, got:\n%s", out)
+	}
+	if out, _ := callTool(t, glob, "s", map[string]any{"pattern": "*.rs"}); out != "No files found" {
+		t.Errorf("unexpected result: %s", out)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// shellParser splits a shell command line into simple commands, it understands enough of the POSIX shell syntax to find every command name
+type shellParser struct {
+	src      []rune
+	pos      int
+	commands [][]string
+
+	args     []string
+	word     strings.Builder
+	inWord   bool
+	skipWord bool // The next word is a redirection target
+
+	heredoc   bool     // The skipped word is a here-document delimiter
+	heredocs  []string // Delimiters of the here-documents starting on the next line
+	dup       bool     // The redirection could duplicate a file descriptor, e.g. 2>&1
+	hereStr   bool     // The skipped word is the text of a here-string
+	redirects []string // Files of the redirections
+}
+
+// parseShell returns the argv of the simple commands of the command line and the files of the redirections
+// The pipes, the lists and the command substitutions are split into separate commands.
+func parseShell(command string) ([][]string, []string, error) {
+	p := &shellParser{src: []rune(command)}
+	if err := p.parse(); err != nil {
+		return nil, nil, err
+	}
+
+	return p.commands, p.redirects, nil
+}
+
+func (p *shellParser) peek(n int) rune {
+	if p.pos+n < len(p.src) {
+		return p.src[p.pos+n]
+	}
+	return 0
+}
+
+func (p *shellParser) endWord() {
+	if !p.inWord {
+		return
+	}
+	w := p.word.String()
+	p.word.Reset()
+	p.inWord = false
+
+	if p.skipWord {
+		p.skipWord = false
+		switch {
+		case p.heredoc:
+			p.heredoc = false
+			p.heredocs = append(p.heredocs, w)
+		case p.hereStr, p.dup && (isDigits(w) || w == "-"):
+		default:
+			p.redirects = append(p.redirects, w)
+		}
+		return
+	}
+	p.args = append(p.args, w)
+}
+
+func (p *shellParser) endCommand() {
+	p.endWord()
+	p.skipWord = false
+	if len(p.args) > 0 {
+		p.commands = append(p.commands, p.args)
+		p.args = nil
+	}
+}
+
+// skipHeredocs skips the bodies of the here-documents after a newline
+func (p *shellParser) skipHeredocs() {
+	for _, delim := range p.heredocs {
+		for p.pos < len(p.src) {
+			end := p.pos
+			for end < len(p.src) && p.src[end] != '\n' {
+				end++
+			}
+			line := strings.TrimLeft(string(p.src[p.pos:end]), "\t")
+			p.pos = min(end+1, len(p.src))
+			if line == delim {
+				break
+			}
+		}
+	}
+	p.heredocs = nil
+}
+
+// nested parses the command substitution and adds its commands
+func (p *shellParser) nested(inner string) error {
+	commands, redirects, err := parseShell(inner)
+	if err != nil {
+		return err
+	}
+	p.commands = append(p.commands, commands...)
+	p.redirects = append(p.redirects, redirects...)
+
+	return nil
+}
+
+// closing returns the position of the parenthesis which closes the one before the start
+func (p *shellParser) closing(start int) (int, error) {
+	depth := 1
+	for i := start; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '\'':
+			for i++; i < len(p.src) && p.src[i] != '\''; i++ {
+			}
+			if i == len(p.src) {
+				return 0, errors.New("unterminated single quote")
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+
+	return 0, errors.New("unterminated $(")
+}
+
+// dollar handles the $( and $(( forms, it returns false for the other variables
+func (p *shellParser) dollar() (bool, error) {
+	if p.peek(1) != '(' {
+		return false, nil
+	}
+
+	p.inWord = true
+	if p.peek(2) == '(' { // Arithmetic, kept as it is
+		end, err := p.closing(p.pos + 2)
+		if err != nil {
+			return false, err
+		}
+		p.word.WriteString(string(p.src[p.pos : end+1]))
+		p.pos = end + 1
+		return true, nil
+	}
+
+	end, err := p.closing(p.pos + 2)
+	if err != nil {
+		return false, err
+	}
+	if err := p.nested(string(p.src[p.pos+2 : end])); err != nil {
+		return false, err
+	}
+	p.word.WriteString("$(...)")
+	p.pos = end + 1
+
+	return true, nil
+}
+
+func (p *shellParser) backtick() error {
+	var inner strings.Builder
+	for i := p.pos + 1; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			if i+1 < len(p.src) {
+				inner.WriteRune(p.src[i+1])
+				i++
+			}
+		case '`':
+			p.inWord = true
+			p.word.WriteString("$(...)")
+			p.pos = i + 1
+			return p.nested(inner.String())
+		default:
+			inner.WriteRune(p.src[i])
+		}
+	}
+
+	return errors.New("unterminated backtick")
+}
+
+func (p *shellParser) doubleQuoted() error {
+	p.inWord = true
+	p.pos++
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return nil
+		case c == '\\' && strings.ContainsRune("$`\"\\\n", p.peek(1)):
+			if p.peek(1) != '\n' {
+				p.word.WriteRune(p.peek(1))
+			}
+			p.pos += 2
+		case c == '$':
+			ok, err := p.dollar()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				p.word.WriteRune(c)
+				p.pos++
+			}
+		case c == '`':
+			if err := p.backtick(); err != nil {
+				return err
+			}
+		default:
+			p.word.WriteRune(c)
+			p.pos++
+		}
+	}
+
+	return errors.New("unterminated double quote")
+}
+
+func (p *shellParser) parse() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.endWord()
+			p.pos++
+		case c == '\n':
+			p.endCommand()
+			p.pos++
+			p.skipHeredocs()
+		case c == ';' || c == '&' || c == '|' || c == '(' || c == ')':
+			p.endCommand()
+			p.pos++
+		case c == '#' && !p.inWord:
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '>' || c == '<':
+			// The number before the operator is a file descriptor, e.g. 2>&1
+			if p.inWord && isDigits(p.word.String()) {
+				p.word.Reset()
+				p.inWord = false
+			} else {
+				p.endWord()
+			}
+			p.heredoc = c == '<' && p.peek(1) == '<' && p.peek(2) != '<'
+			p.hereStr = c == '<' && p.peek(1) == '<' && p.peek(2) == '<'
+			p.pos++
+			p.dup = false
+			for p.pos < len(p.src) && strings.ContainsRune("<>&|-", p.src[p.pos]) {
+				p.dup = p.dup || p.src[p.pos] == '&'
+				p.pos++
+			}
+			p.skipWord = true
+		case c == '\\':
+			if p.peek(1) != '\n' {
+				p.inWord = true
+				p.word.WriteRune(p.peek(1))
+			}
+			p.pos += 2
+		case c == '\'':
+			end := p.pos + 1
+			for end < len(p.src) && p.src[end] != '\'' {
+				end++
+			}
+			if end == len(p.src) {
+				return errors.New("unterminated single quote")
+			}
+			p.inWord = true
+			p.word.WriteString(string(p.src[p.pos+1 : end]))
+			p.pos = end + 1
+		case c == '"':
+			if err := p.doubleQuoted(); err != nil {
+				return err
+			}
+		case c == '$':
+			ok, err := p.dollar()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				p.inWord = true
+				p.word.WriteRune(c)
+				p.pos++
+			}
+		case c == '`':
+			if err := p.backtick(); err != nil {
+				return err
+			}
+		default:
+			p.inWord = true
+			p.word.WriteRune(c)
+			p.pos++
+		}
+	}
+	p.endCommand()
+
+	return nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"gopkg.in/yaml.v3"
+)
+
+var validSkillName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// skillArgument is a prompt argument, it replaces the {{name}} placeholders of the body
+type skillArgument struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Required    bool   `yaml:"required"`
+}
+
+// skill is a markdown file with front-matter, published as a prompt and a resource
+type skill struct {
+	Name        string          `yaml:"name"`
+	Description string          `yaml:"description"`
+	Arguments   []skillArgument `yaml:"arguments"`
+
+	body string
+}
+
+func (s skill) uri() string {
+	return "skill://" + s.Name
+}
+
+// render replaces the argument placeholders, the missing optional ones are replaced with an empty string
+func (s skill) render(args map[string]string) (string, error) {
+	body := s.body
+	for _, a := range s.Arguments {
+		v, ok := args[a.Name]
+		if a.Required && (!ok || v == "") {
+			return "", fmt.Errorf("missing required argument: %s", a.Name)
+		}
+		body = strings.ReplaceAll(body, "{{"+a.Name+"}}", v)
+	}
+
+	return body, nil
+}
+
+// parseSkill reads the front-matter of the file, the name defaults to the file name
+func parseSkill(path string, content []byte) (skill, error) {
+	var s skill
+
+	content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	if rest, ok := bytes.CutPrefix(content, []byte("---\n")); ok {
+		front, body, found := bytes.Cut(rest, []byte("\n---\n"))
+		if !found {
+			return s, errors.New("unterminated front-matter")
+		}
+		if err := yaml.Unmarshal(front, &s); err != nil {
+			return s, fmt.Errorf("invalid front-matter: %w", err)
+		}
+		content = body
+	}
+	s.body = strings.TrimSpace(string(content))
+
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if !validSkillName.MatchString(s.Name) {
+		return s, fmt.Errorf("invalid skill name: %q", s.Name)
+	}
+	for _, a := range s.Arguments {
+		if a.Name == "" {
+			return s, errors.New("argument without name")
+		}
+	}
+
+	return s, nil
+}
+
+// loadSkills reads the markdown files of the directory, a missing directory means no skills
+func loadSkills(dir string) ([]skill, error) {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return nil, err
+	}
+
+	var skills []skill
+	names := make(map[string]string)
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s, err := parseSkill(path, content)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if other, ok := names[s.Name]; ok {
+			return nil, fmt.Errorf("%s: skill %s is already defined in %s", path, s.Name, other)
+		}
+		names[s.Name] = path
+		skills = append(skills, s)
+	}
+
+	return skills, nil
+}
+
+// addSkills publishes every skill as a prompt and as a skill:// resource
+func addSkills(srv *server.MCPServer, skills []skill) {
+	for _, s := range skills {
+		opts := []mcp.PromptOption{mcp.WithPromptDescription(s.Description)}
+		for _, a := range s.Arguments {
+			argOpts := []mcp.ArgumentOption{mcp.ArgumentDescription(a.Description)}
+			if a.Required {
+				argOpts = append(argOpts, mcp.RequiredArgument())
+			}
+			opts = append(opts, mcp.WithArgument(a.Name, argOpts...))
+		}
+		srv.AddPrompt(mcp.NewPrompt(s.Name, opts...), handleSkillPrompt(s))
+
+		srv.AddResource(mcp.NewResource(s.uri(), s.Name,
+			mcp.WithResourceDescription(s.Description),
+			mcp.WithMIMEType("text/markdown"),
+		), handleSkillResource(s))
+	}
+}
+
+// handleSkillPrompt returns the skill body with the arguments as a user message
+func handleSkillPrompt(s skill) server.PromptHandlerFunc {
+	return func(ctx context.Context, req mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		body, err := s.render(req.Params.Arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		return mcp.NewGetPromptResult(s.Description, []mcp.PromptMessage{
+			mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent(body)),
+		}), nil
+	}
+}
+
+// handleSkillResource returns the skill body without replacing the placeholders
+func handleSkillResource(s skill) server.ResourceHandlerFunc {
+	return func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		return []mcp.ResourceContents{mcp.TextResourceContents{
+			URI:      s.uri(),
+			MIMEType: "text/markdown",
+			Text:     s.body,
+		}}, nil
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestLoadSkills(t *testing.T) {
+	if skills, err := loadSkills(filepath.Join(t.TempDir(), "missing")); err != nil || skills != nil {
+		t.Errorf("expected no skills without the directory, got %v %v", skills, err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"review.md": "---\nname: code-review\ndescription: Review a file\narguments:\n  - name: path\n    description: The file\n    required: true\n  - name: focus\n---\n\nReview {{path}}, focus on {{focus}}.\n",
+		"plain.md":  "Just the body.",
+		"notes.txt": "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	skills, err := loadSkills(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skills) != 2 || skills[0].Name != "plain" || skills[1].Name != "code-review" {
+		t.Fatalf("unexpected skills: %+v", skills)
+	}
+
+	review := skills[1]
+	if _, err := review.render(map[string]string{"focus": "errors"}); err == nil {
+		t.Error("expected an error for the missing required argument")
+	}
+
+	var req mcp.GetPromptRequest
+	req.Params.Arguments = map[string]string{"path": "main.go"}
+	res, err := handleSkillPrompt(review)(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := res.Messages[0].Content.(mcp.TextContent).Text; text != "Review main.go, focus on ." || res.Description != "Review a file" {
+		t.Errorf("unexpected prompt: %q %q", text, res.Description)
+	}
+
+	contents, err := handleSkillResource(review)(context.Background(), mcp.ReadResourceRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := contents[0].(mcp.TextResourceContents); c.URI != "skill://code-review" || c.Text != "Review {{path}}, focus on {{focus}}." {
+		t.Errorf("unexpected resource: %+v", c)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "dup.md"), []byte("---\nname: plain\n---\nbody"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSkills(dir); err == nil {
+		t.Error("expected an error for the duplicate name")
+	}
+}
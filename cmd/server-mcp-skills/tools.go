@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
@@ -9,12 +10,14 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
 // ensureSafePath checks if the given path is within the base directory.
-// It returns the absolute safe path or an error if the path traverses outside the base directory.
+// It returns the absolute safe path or an error if the path traverses outside the base directory,
+// either lexically or through a symlink, e.g. one created by execute_command.
 func ensureSafePath(baseDir, reqPath string) (string, error) {
 	if filepath.IsAbs(reqPath) {
 		return "", fmt.Errorf("path traversal attempt detected: absolute path: %s", reqPath)
@@ -31,25 +34,92 @@ func ensureSafePath(baseDir, reqPath string) (string, error) {
 		return "", fmt.Errorf("failed to get absolute target path: %w", err)
 	}
 
-	rel, err := filepath.Rel(absBase, absTarget)
+	if !within(absBase, absTarget) {
+		return "", fmt.Errorf("path traversal attempt detected: %s", reqPath)
+	}
+
+	// The symlinks are resolved on both sides, the base could be under a symlink too (e.g. /tmp on macOS)
+	resolvedBase, err := filepath.EvalSymlinks(absBase)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve base directory: %w", err)
+	}
+	resolvedTarget, err := resolvePath(absTarget, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve path: %w", err)
+	}
+	if !within(resolvedBase, resolvedTarget) {
+		return "", fmt.Errorf("path traversal attempt detected: %s points outside the base directory through a symlink", reqPath)
+	}
+
+	return absTarget, nil
+}
+
+// within reports whether the target is the base or under it
+func within(base, target string) bool {
+	rel, err := filepath.Rel(base, target)
 	if err != nil {
-		return "", fmt.Errorf("failed to get relative path: %w", err)
+		return false
 	}
 
 	// filepath.Rel returns a path starting with ".." if the target is outside the base directory.
 	// It returns "." if target is the same as base directory.
-	if rel == ".." || strings.HasPrefix(rel, "../") || strings.HasPrefix(rel, "..\\") {
-		return "", fmt.Errorf("path traversal attempt detected: %s", reqPath)
+	return rel != ".." && !strings.HasPrefix(rel, "../") && !strings.HasPrefix(rel, "..\\")
+}
+
+// resolvePath resolves the symlinks of the path, the missing parts (e.g. of a new file) are kept as they are.
+// The dangling symlinks are followed too, as writing through them would create their target.
+func resolvePath(path string, hops int) (string, error) {
+	if hops > 40 {
+		return "", fmt.Errorf("too many levels of symbolic links: %s", path)
 	}
 
-	return absTarget, nil
+	// The deepest existing part of the path
+	existing := path
+	var missing []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = parent
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err == nil {
+		return filepath.Join(append([]string{resolved}, missing...)...), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	// The parents exist, so the last part is a dangling symlink
+	link, err := os.Readlink(existing)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(link) {
+		link = filepath.Join(filepath.Dir(existing), link)
+	}
+
+	return resolvePath(filepath.Join(append([]string{link}, missing...)...), hops+1)
 }
 
 // handleListFiles creates the handler for listing files recursively.
-func handleListFiles(baseDir string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func handleListFiles(ws *workspaces) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		pathArg := req.GetString("path", ".")
-		slog.InfoContext(ctx, "audit: list_files called", slog.String("path", pathArg))
+		slog.InfoContext(ctx, "audit: list_files called", slog.String("session", sessionID(ctx)), slog.String("path", pathArg))
+
+		baseDir, err := ws.dir(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 
 		safePath, err := ensureSafePath(baseDir, pathArg)
 		if err != nil {
@@ -90,13 +160,18 @@ func handleListFiles(baseDir string) func(context.Context, mcp.CallToolRequest)
 }
 
 // handleReadFile creates the handler for reading a file's contents.
-func handleReadFile(baseDir string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func handleReadFile(ws *workspaces) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		pathArg := req.GetString("path", "")
 		if pathArg == "" {
 			return mcp.NewToolResultError("path parameter is required"), nil
 		}
-		slog.InfoContext(ctx, "audit: read_file called", slog.String("path", pathArg))
+		slog.InfoContext(ctx, "audit: read_file called", slog.String("session", sessionID(ctx)), slog.String("path", pathArg))
+
+		baseDir, err := ws.dir(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 
 		safePath, err := ensureSafePath(baseDir, pathArg)
 		if err != nil {
@@ -108,12 +183,47 @@ func handleReadFile(baseDir string) func(context.Context, mcp.CallToolRequest) (
 			return mcp.NewToolResultError(fmt.Sprintf("Failed to read file: %v", err)), nil
 		}
 
-		return mcp.NewToolResultText(string(content)), nil
+		startLine := req.GetInt("start_line", 0)
+		endLine := req.GetInt("end_line", 0)
+		if startLine == 0 && endLine == 0 {
+			return mcp.NewToolResultText(string(content)), nil
+		}
+
+		text, err := numberedLines(string(content), startLine, endLine)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("Invalid line range for %s: %v", pathArg, err)), nil
+		}
+
+		return mcp.NewToolResultText(text), nil
+	}
+}
+
+// numberedLines returns the lines of the range prefixed with their numbers, 0 means the start or the end of the file
+func numberedLines(content string, startLine, endLine int) (string, error) {
+	lines, _ := splitLines(content)
+	if startLine == 0 {
+		startLine = 1
+	}
+	if endLine == 0 || endLine > len(lines) {
+		endLine = len(lines)
+	}
+	if startLine < 1 || startLine > len(lines) {
+		return "", fmt.Errorf("start_line %d is out of the file, it has %d lines", startLine, len(lines))
+	}
+	if endLine < startLine {
+		return "", fmt.Errorf("end_line %d is before start_line %d", endLine, startLine)
+	}
+
+	var b strings.Builder
+	for i := startLine; i <= endLine; i++ {
+		fmt.Fprintf(&b, "%6d\t%s\n", i, lines[i-1])
 	}
+
+	return b.String(), nil
 }
 
 // handleWriteFile creates the handler for writing content to a file.
-func handleWriteFile(baseDir string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func handleWriteFile(ws *workspaces) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		pathArg := req.GetString("path", "")
 		if pathArg == "" {
@@ -121,13 +231,27 @@ func handleWriteFile(baseDir string) func(context.Context, mcp.CallToolRequest)
 		}
 
 		contentArg := req.GetString("content", "")
-		slog.InfoContext(ctx, "audit: write_file called", slog.String("path", pathArg), slog.Int("content_len", len(contentArg)))
+		slog.InfoContext(ctx, "audit: write_file called", slog.String("session", sessionID(ctx)), slog.String("path", pathArg), slog.Int("content_len", len(contentArg)))
+
+		baseDir, err := ws.dir(ctx)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 
 		safePath, err := ensureSafePath(baseDir, pathArg)
 		if err != nil {
 			return mcp.NewToolResultError(fmt.Sprintf("Invalid path: %v", err)), nil
 		}
 
+		// The overwritten file is freed
+		extra := int64(len(contentArg))
+		if info, err := os.Stat(safePath); err == nil {
+			extra -= info.Size()
+		}
+		if err := ws.checkQuota(baseDir, extra); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
 		// Ensure the directory exists before writing the file
 		dir := filepath.Dir(safePath)
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -144,29 +268,58 @@ func handleWriteFile(baseDir string) func(context.Context, mcp.CallToolRequest)
 }
 
 // handleExecuteCommand creates the handler for executing a shell command.
-func handleExecuteCommand(baseDir string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+func handleExecuteCommand(ws *workspaces, limits commandLimits, policy *commandPolicy) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		cmdArg := req.GetString("command", "")
 		if cmdArg == "" {
 			return mcp.NewToolResultError("command parameter is required"), nil
 		}
-		slog.InfoContext(ctx, "audit: execute_command called", slog.String("command", cmdArg))
+		// The requested timeout could only shorten the configured one
+		if timeout := time.Duration(req.GetFloat("timeout", 0) * float64(time.Second)); timeout > 0 && timeout < limits.Timeout {
+			limits.Timeout = timeout
+		}
+		slog.InfoContext(ctx, "audit: execute_command called", slog.String("session", sessionID(ctx)), slog.String("command", cmdArg), slog.Duration("timeout", limits.Timeout))
 
-		absBase, err := filepath.Abs(baseDir)
+		baseDir, err := ws.dir(ctx)
 		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("failed to get absolute base directory: %v", err)), nil
+			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		cmd := exec.CommandContext(ctx, "sh", "-c", cmdArg)
-		cmd.Dir = absBase
+		if policy != nil {
+			if err := policy.check(cmdArg, baseDir); err != nil {
+				slog.WarnContext(ctx, "audit: execute_command rejected", slog.String("session", sessionID(ctx)), slog.String("reason", err.Error()))
+				return mcp.NewToolResultError(fmt.Sprintf("Command rejected by the policy: %v", err)), nil
+			}
+		}
+
+		// The commands could write anything, so the full workspaces are refused and a file could only fill the free space
+		if limits.FileBytes, err = ws.fileLimit(baseDir); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 
-		output, err := cmd.CombinedOutput()
+		output, timedOut, err := runCommand(ctx, baseDir, cmdArg, policy.environ(), limits)
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			auditExitCode(ctx, exitErr.ExitCode())
+		case err != nil:
+			auditExitCode(ctx, -1)
+		default:
+			auditExitCode(ctx, 0)
+		}
+		if timedOut {
+			return mcp.NewToolResultError(fmt.Sprintf("Command timed out after %s and was killed\nOutput:\n%s", limits.Timeout, output)), nil
+		}
 		if err != nil {
 			// Include both the output and the error message
-			errorMsg := fmt.Sprintf("Command failed with error: %v\nOutput:\n%s", err, string(output))
+			errorMsg := fmt.Sprintf("Command failed with error: %v\nOutput:\n%s", err, output)
 			return mcp.NewToolResultError(errorMsg), nil
 		}
+		// Several files could still go over the quota
+		if err := ws.checkQuota(baseDir, 0); err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("%v, delete files before running other commands\nOutput:\n%s", err, output)), nil
+		}
 
-		return mcp.NewToolResultText(string(output)), nil
+		return mcp.NewToolResultText(output), nil
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -125,3 +126,83 @@ func TestEnsureSafePath(t *testing.T) {
 		})
 	}
 }
+
+func TestEnsureSafePathSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+
+	root := t.TempDir()
+	baseDir := filepath.Join(root, "base")
+	outside := filepath.Join(root, "outside")
+	for _, dir := range []string{filepath.Join(baseDir, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{
+		"escape":          outside,
+		"relative-escape": "../outside",
+		"file-escape":     filepath.Join(outside, "secret.txt"),
+		"dangling":        filepath.Join(outside, "new.txt"),
+		"chain":           "dangling",
+		"inner":           "sub",
+		"sub/up":          "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(baseDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		reqPath   string
+		expectErr bool
+	}{
+		{reqPath: "escape", expectErr: true},
+		{reqPath: "escape/secret.txt", expectErr: true},
+		{reqPath: "escape/missing/new.txt", expectErr: true},
+		{reqPath: "relative-escape/secret.txt", expectErr: true},
+		{reqPath: "file-escape", expectErr: true},
+		{reqPath: "dangling", expectErr: true},
+		{reqPath: "chain", expectErr: true},
+		{reqPath: "sub/up/escape/secret.txt", expectErr: true},
+		{reqPath: "inner/new.txt", expectErr: false},
+		{reqPath: "sub/up/sub", expectErr: false},
+		{reqPath: "missing/dir/new.txt", expectErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reqPath, func(t *testing.T) {
+			_, err := ensureSafePath(baseDir, tt.reqPath)
+			if tt.expectErr && (err == nil || !strings.Contains(err.Error(), "path traversal attempt detected")) {
+				t.Errorf("expected a traversal error, got %v", err)
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	// The tools could not write through the links either
+	ws, err := newWorkspaces(root, workspaceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ws.dir(context.WithValue(context.Background(), sessionIDKey, "s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if out, isErr := callTool(t, handleWriteFile(ws), "s", map[string]any{"path": "link/pwned.txt", "content": "x"}); !isErr {
+		t.Errorf("expected the write to fail, got %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "pwned.txt")); !os.IsNotExist(err) {
+		t.Errorf("the file was written outside of the workspace: %v", err)
+	}
+}
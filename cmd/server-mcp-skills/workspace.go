@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+type contextKey string
+
+// sessionIDKey holds the x-session-id header of the request
+const sessionIDKey contextKey = "x-session-id"
+
+// defaultWorkspace is used by the clients without session header
+const defaultWorkspace = "default"
+
+var validSessionID = regexp.MustCompile(`^[a-zA-Z0-9@._+-]{1,128}$`)
+
+// workspaceConfig .
+type workspaceConfig struct {
+	Quota int64         // Max bytes of a workspace, 0 means unlimited
+	TTL   time.Duration // Unused workspaces are deleted after this, 0 means never
+
+	OnExpire func(sessionID string) // Called before an expired workspace is deleted, e.g. to stop its processes
+}
+
+// workspaces gives every session its own directory under the base directory
+type workspaces struct {
+	root string
+	cfg  workspaceConfig
+}
+
+func newWorkspaces(baseDir string, cfg workspaceConfig) (*workspaces, error) {
+	root, err := filepath.Abs(filepath.Join(baseDir, "workspaces"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute workspace directory: %w", err)
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create workspace directory: %w", err)
+	}
+
+	return &workspaces{root: root, cfg: cfg}, nil
+}
+
+// sessionID returns the session of the request, the default workspace is used without it
+func sessionID(ctx context.Context) string {
+	if sid, ok := ctx.Value(sessionIDKey).(string); ok && sid != "" {
+		return sid
+	}
+
+	return defaultWorkspace
+}
+
+// dir returns the workspace of the session in the context, it is created on the first use
+func (w *workspaces) dir(ctx context.Context) (string, error) {
+	sid := sessionID(ctx)
+	if !validSessionID.MatchString(sid) || sid == "." || sid == ".." {
+		return "", fmt.Errorf("invalid session ID: %q", sid)
+	}
+
+	dir := filepath.Join(w.root, sid)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create workspace: %w", err)
+	}
+	// The modification time is the last use for the cleanup
+	now := time.Now()
+	if err := os.Chtimes(dir, now, now); err != nil {
+		return "", fmt.Errorf("failed to touch workspace: %w", err)
+	}
+
+	return dir, nil
+}
+
+// usage returns the size of the files in the workspace
+func usage(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
+
+// checkQuota returns an error if the workspace would be over the quota after growing by the extra bytes
+func (w *workspaces) checkQuota(dir string, extra int64) error {
+	if w.cfg.Quota == 0 {
+		return nil
+	}
+
+	size, err := usage(dir)
+	if err != nil {
+		return fmt.Errorf("failed to get workspace usage: %w", err)
+	}
+	if size+extra > w.cfg.Quota {
+		return fmt.Errorf("workspace quota exceeded: %d of %d bytes used", size, w.cfg.Quota)
+	}
+
+	return nil
+}
+
+// fileLimit returns the free bytes of the workspace, which is the max size of a file written by a command, 0 means unlimited
+// The full workspaces are refused, so the commands could only go over the quota with several files.
+func (w *workspaces) fileLimit(dir string) (int64, error) {
+	if w.cfg.Quota == 0 {
+		return 0, nil
+	}
+
+	size, err := usage(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get workspace usage: %w", err)
+	}
+	if size >= w.cfg.Quota {
+		return 0, fmt.Errorf("workspace quota exceeded: %d of %d bytes used", size, w.cfg.Quota)
+	}
+
+	return w.cfg.Quota - size, nil
+}
+
+// cleanup deletes the workspaces which were not used since the TTL
+func (w *workspaces) cleanup(now time.Time) {
+	entries, err := os.ReadDir(w.root)
+	if err != nil {
+		slog.Error("failed to list workspaces", slog.String("err", err.Error()))
+		return
+	}
+
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !e.IsDir() || now.Sub(info.ModTime()) < w.cfg.TTL {
+			continue
+		}
+
+		slog.Info("deleting expired workspace", slog.String("session", e.Name()), slog.Time("lastUsed", info.ModTime()))
+		if w.cfg.OnExpire != nil {
+			w.cfg.OnExpire(e.Name())
+		}
+		if err := os.RemoveAll(filepath.Join(w.root, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("failed to delete workspace", slog.String("session", e.Name()), slog.String("err", err.Error()))
+		}
+	}
+}
+
+// startCleanup deletes the expired workspaces periodically until the context is done
+func (w *workspaces) startCleanup(ctx context.Context) {
+	if w.cfg.TTL == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(min(w.cfg.TTL, time.Minute))
+		defer ticker.Stop()
+
+		for {
+			w.cleanup(time.Now())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func callTool(t *testing.T, handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error), sessionID string, args map[string]any) (string, bool) {
+	t.Helper()
+
+	var req mcp.CallToolRequest
+	req.Params.Arguments = args
+	ctx := context.WithValue(context.Background(), sessionIDKey, sessionID)
+
+	res, err := handler(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text strings.Builder
+	for _, c := range res.Content {
+		if tc, ok := c.(mcp.TextContent); ok {
+			text.WriteString(tc.Text)
+		}
+	}
+
+	return text.String(), res.IsError
+}
+
+func TestWorkspaces(t *testing.T) {
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{Quota: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := handleWriteFile(ws)
+	read := handleReadFile(ws)
+
+	if out, isErr := callTool(t, write, "alice", map[string]any{"path": "a.txt", "content": "hello"}); isErr {
+		t.Fatalf("write failed: %s", out)
+	}
+	if out, isErr := callTool(t, read, "alice", map[string]any{"path": "a.txt"}); isErr || out != "hello" {
+		t.Errorf("unexpected read: %s", out)
+	}
+	if out, isErr := callTool(t, read, "bob", map[string]any{"path": "a.txt"}); !isErr {
+		t.Errorf("bob could read the file of alice: %s", out)
+	}
+
+	// Overwriting the file only counts the difference
+	if out, isErr := callTool(t, write, "alice", map[string]any{"path": "a.txt", "content": "0123456789"}); isErr {
+		t.Errorf("overwrite failed: %s", out)
+	}
+	if out, isErr := callTool(t, write, "alice", map[string]any{"path": "b.txt", "content": "x"}); !isErr || !strings.Contains(out, "quota exceeded") {
+		t.Errorf("expected quota error, got %s", out)
+	}
+	if out, isErr := callTool(t, handleExecuteCommand(ws, commandLimits{Timeout: time.Second, MaxOutput: 100}, nil), "alice", map[string]any{"command": "true"}); !isErr {
+		t.Errorf("expected the command to be refused in a full workspace: %s", out)
+	}
+
+	// A command could only write a file of the free space, and the workspace is checked after it
+	run := handleExecuteCommand(ws, commandLimits{Timeout: 5 * time.Second, MaxOutput: 1000}, nil)
+	if out, isErr := callTool(t, run, "bob", map[string]any{"command": "head -c 100000 /dev/zero > big.bin"}); !isErr {
+		t.Errorf("expected the big file to fail: %s", out)
+	}
+	if info, err := os.Stat(filepath.Join(ws.root, "bob", "big.bin")); err != nil || info.Size() > 512 {
+		t.Errorf("the file is not limited: %v %v", info, err)
+	}
+	if out, isErr := callTool(t, run, "carol", map[string]any{"command": "echo 12345 > a; echo 12345 > b"}); !isErr || !strings.Contains(out, "delete files before running other commands") {
+		t.Errorf("expected the quota error after the command, got %s", out)
+	}
+
+	if out, isErr := callTool(t, read, "../alice", map[string]any{"path": "a.txt"}); !isErr || !strings.Contains(out, "invalid session ID") {
+		t.Errorf("expected invalid session error, got %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(ws.root, defaultWorkspace)); err == nil {
+		t.Error("the default workspace should only be created on use")
+	}
+	if out, isErr := callTool(t, handleListFiles(ws), "", map[string]any{}); isErr || out != "Directory is empty" {
+		t.Errorf("unexpected listing of the default workspace: %s", out)
+	}
+}
+
+func TestWorkspaceCleanup(t *testing.T) {
+	ws, err := newWorkspaces(t.TempDir(), workspaceConfig{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sid := range []string{"old", "new"} {
+		if _, err := ws.dir(context.WithValue(context.Background(), sessionIDKey, sid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(ws.root, "old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.cleanup(time.Now())
+
+	if _, err := os.Stat(filepath.Join(ws.root, "old")); !os.IsNotExist(err) {
+		t.Errorf("expected the old workspace to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(ws.root, "new")); err != nil {
+		t.Errorf("expected the new workspace to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/history"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// branchHistory is implemented by the history.Logic, the history could continue from any earlier message
+type branchHistory interface {
+	Branch(ctx context.Context, sessionID string) ([]history.Node, error)
+	Checkout(ctx context.Context, sessionID string, messageID int) error
+	Fork(ctx context.Context, sessionID, newSessionID string, messageID int) error
+}
+
+func (l *Logic) branchHistory() (branchHistory, error) {
+	h, ok := l.history.(branchHistory)
+	if !ok {
+		return nil, errors.New("the history does not support branches")
+	}
+
+	return h, nil
+}
+
+// Branch returns the messages of the active branch with their IDs
+func (l *Logic) Branch(ctx context.Context, sessionID string) ([]history.Node, error) {
+	h, err := l.branchHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Branch(ctx, sessionID)
+}
+
+// Regenerate answers the last user message again, the previous answer stays in an other branch
+// The message and the files are taken from the history, the other fields of the request (e.g. the channel) are kept.
+func (l *Logic) Regenerate(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error) {
+	h, err := l.branchHistory()
+	if err != nil {
+		return domain.Response{}, err
+	}
+
+	nodes, err := h.Branch(ctx, sessionID)
+	if err != nil {
+		return domain.Response{}, err
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if nodes[i].Message.Role != ai.RoleUser {
+			continue
+		}
+
+		req.Message, req.InlineData = messageContent(nodes[i].Message)
+
+		return l.answerFrom(ctx, h, sessionID, nodes, nodes[i].ParentID, req)
+	}
+
+	return domain.Response{}, fmt.Errorf("%w: no user message to regenerate", history.ErrNotFound)
+}
+
+// Edit replaces the user message of the active branch with the request and continues the conversation from there
+func (l *Logic) Edit(ctx context.Context, sessionID string, messageID int, req domain.Request) (domain.Response, error) {
+	h, err := l.branchHistory()
+	if err != nil {
+		return domain.Response{}, err
+	}
+
+	nodes, err := h.Branch(ctx, sessionID)
+	if err != nil {
+		return domain.Response{}, err
+	}
+	for _, n := range nodes {
+		if n.ID != messageID {
+			continue
+		}
+		if n.Message.Role != ai.RoleUser {
+			return domain.Response{}, fmt.Errorf("%w: message %d is not a user message", history.ErrNotFound, messageID)
+		}
+
+		return l.answerFrom(ctx, h, sessionID, nodes, n.ParentID, req)
+	}
+
+	return domain.Response{}, fmt.Errorf("%w: %d", history.ErrNotFound, messageID)
+}
+
+// Fork copies the active branch until the message into a new session, 0 means the whole branch
+func (l *Logic) Fork(ctx context.Context, sessionID, newSessionID string, messageID int) error {
+	h, err := l.branchHistory()
+	if err != nil {
+		return err
+	}
+
+	return h.Fork(ctx, sessionID, newSessionID, messageID)
+}
+
+// answerFrom answers the request as the next message after the parent, the old head is restored if it fails
+func (l *Logic) answerFrom(ctx context.Context, h branchHistory, sessionID string, nodes []history.Node, parentID int, req domain.Request) (domain.Response, error) {
+	if err := h.Checkout(ctx, sessionID, parentID); err != nil {
+		return domain.Response{}, err
+	}
+
+	resp, err := l.HandleMessage(ctx, sessionID, req)
+	if err != nil && len(nodes) > 0 {
+		if checkoutErr := h.Checkout(ctx, sessionID, nodes[len(nodes)-1].ID); checkoutErr != nil {
+			l.logger.Error("failed to restore the history branch", slog.String("sessionID", sessionID), slog.String("err", checkoutErr.Error()))
+		}
+	}
+
+	return resp, err
+}
+
+// messageContent converts the user message back to the text and the files of a request
+func messageContent(m *ai.Message) (string, []*domain.InlineData) {
+	var text strings.Builder
+	var inlineData []*domain.InlineData
+	for _, p := range m.Content {
+		switch {
+		case p.IsMedia():
+			inlineData = append(inlineData, &domain.InlineData{MimeType: p.ContentType, Data: []byte(p.Text)})
+		case p.IsText():
+			text.WriteString(p.Text)
+		}
+	}
+
+	return text.String(), inlineData
+}
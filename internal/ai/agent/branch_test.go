@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/ai/fake"
+	"hairy-botter/internal/history"
+
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func TestRegenerateAndEdit(t *testing.T) {
+	ctx := context.Background()
+	p := fake.New()
+	g := genkit.Init(ctx, genkit.WithPlugins(p))
+	model, err := p.DefineModel(g, "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	l, err := New(logger, g, model, history.New(logger, t.TempDir(), history.Config{}), nil, staticPersona("You are a test bot."), nil, nil, nil, nil, nil, nil, RetryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, msg := range []string{"first", "second"} {
+		if _, err := l.HandleMessage(ctx, "s", domain.Request{Message: msg}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := l.Regenerate(ctx, "s", domain.Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "echo: second" {
+		t.Errorf("unexpected regenerated answer: %q", resp.Text)
+	}
+
+	nodes, err := l.Branch(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 4 || nodes[2].ID != 5 || nodes[2].ParentID != 2 {
+		t.Fatalf("unexpected branch after the regenerate: %+v", nodes)
+	}
+
+	resp, err = l.Edit(ctx, "s", nodes[0].ID, domain.Request{Message: "edited"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "echo: edited" {
+		t.Errorf("unexpected answer of the edit: %q", resp.Text)
+	}
+	if nodes, _ = l.Branch(ctx, "s"); len(nodes) != 2 || nodes[0].ParentID != 0 || nodes[0].Message.Text() != "edited" {
+		t.Fatalf("unexpected branch after the edit: %+v", nodes)
+	}
+
+	// Only the user messages of the active branch could be edited
+	if _, err := l.Edit(ctx, "s", nodes[1].ID, domain.Request{Message: "x"}); !errors.Is(err, history.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a model message, got %v", err)
+	}
+	if _, err := l.Edit(ctx, "s", 3, domain.Request{Message: "x"}); !errors.Is(err, history.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a message of another branch, got %v", err)
+	}
+
+	// The memory history doesn't support the branches
+	if _, err := newTestLogic(t, g, model, RetryConfig{}).Regenerate(ctx, "s", domain.Request{}); err == nil {
+		t.Error("expected an error without branch support")
+	}
+}
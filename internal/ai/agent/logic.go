@@ -6,23 +6,41 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"strings"
 
 	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/persona"
 	"hairy-botter/internal/rag"
+	"hairy-botter/internal/usage"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
-	genkitMCP "github.com/firebase/genkit/go/plugins/mcp"
 )
 
 type historyLogic interface {
-	Read(ctx context.Context, sessionID string) ([]*ai.Message, error)
-	Save(ctx context.Context, sessionID string, history []*ai.Message) error
+	Read(ctx context.Context, sessionID string) ([]*ai.Message, int, error)
+	Save(ctx context.Context, sessionID string, head int, history []*ai.Message) error
+}
+
+type memoryLogic interface {
+	Prompt(ctx context.Context, userID string) (string, error)
+	Forget(ctx context.Context, userID string) error
+	ExtractAsync(ctx context.Context, userID, userMessage, modelMessage string)
+}
+
+type personaLogic interface {
+	Render(data persona.Data) (string, error)
+}
+
+type usageLogic interface {
+	Middleware(kind string, model func() string) ai.ModelMiddleware
 }
 
 type contextKey string
 
+// ForgetCommand removes every stored fact of the user without calling the model
+const ForgetCommand = "/forget"
+
 // Logic .
 type Logic struct {
 	logger *slog.Logger
@@ -30,55 +48,47 @@ type Logic struct {
 	g       *genkit.Genkit
 	model   ai.Model
 	history historyLogic
-	persona string
+	memory  memoryLogic
+	persona personaLogic
 
 	toolRefs     []ai.ToolRef
 	customConfig any
+	usage        usageLogic
+	retry        RetryConfig
 
 	// RAG related fields
 	ragL *rag.Logic
 }
 
 // New .
-func New(logger *slog.Logger, g *genkit.Genkit, model ai.Model, history historyLogic, mcpClientAddrs []string, ragL *rag.Logic, customConfig any) (*Logic, error) {
+func New(logger *slog.Logger, g *genkit.Genkit, model ai.Model, history historyLogic, memory memoryLogic, personaL personaLogic, mcpClientAddrs []string, mcpHeaders map[string]string, nativeTools []ai.Tool, ragL *rag.Logic, customConfig any, usageL usageLogic, retry RetryConfig) (*Logic, error) {
 	var tools []ai.Tool
-	persona, err := readPersonality()
-	if err != nil {
-		return nil, err
-	}
-
 	if len(mcpClientAddrs) > 0 {
-		mcpServers := make([]genkitMCP.MCPServerConfig, 0, len(mcpClientAddrs))
-		for i, addr := range mcpClientAddrs {
-			mcpServers = append(mcpServers, genkitMCP.MCPServerConfig{
-				Name: fmt.Sprintf("mcp-client-%d", i), // Unique name for each client
-				Config: genkitMCP.MCPClientOptions{
-					StreamableHTTP: &genkitMCP.StreamableHTTPConfig{BaseURL: addr},
-				},
-			})
-		}
-
 		logger.Info("MCP client list is not empty, initializing MCP clients")
-		mcpManager, err := genkitMCP.NewMCPHost(g, genkitMCP.MCPHostOptions{
-			Name:       "hairy-botter-mcp-host",
-			Version:    "1.0.0",
-			MCPServers: mcpServers,
-		})
+		servers, err := connectMCP(context.Background(), mcpClientAddrs, mcpHeaders)
 		if err != nil {
-			return nil, fmt.Errorf("failed to initialize MCP host: %w", err)
+			return nil, err
+		}
+		for _, s := range servers {
+			serverTools, err := mcpTools(context.Background(), s)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get active tools from MCP server: %w", err)
+			}
+			tools = append(tools, serverTools...)
 		}
 
-		tools, err = mcpManager.GetActiveTools(context.Background(), g)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get active tools from MCP host: %w", err)
+		// The prompts of the servers are offered as skills
+		if skills := loadSkills(context.Background(), logger, servers); len(skills) > 0 {
+			tools = append(tools, defineSkillTool(skills))
+			logger.Info("skills loaded", slog.Int("num_skills", len(skills)))
 		}
 	}
 
-	// Convert the ai.Tools to ai.ToolRefs
-	toolRefs := make([]ai.ToolRef, len(tools))
-	for i, tool := range tools {
-		toolRefs[i] = tool
-	}
+	// Merge the in-process tools with the MCP ones
+	tools = append(tools, nativeTools...)
+
+	// Wrap the tools, so the calls are reported in the response
+	toolRefs := trackTools(tools)
 	logger.Info("tools loaded", slog.Int("num_tools", len(toolRefs)))
 
 	return &Logic{
@@ -86,25 +96,44 @@ func New(logger *slog.Logger, g *genkit.Genkit, model ai.Model, history historyL
 		g:            g,
 		model:        model,
 		history:      history,
-		persona:      persona,
+		memory:       memory,
+		persona:      personaL,
 		toolRefs:     toolRefs,
 		customConfig: customConfig,
+		usage:        usageL,
+		retry:        retry,
 		ragL:         ragL,
 	}, nil
 }
 
 // HandleMessage as an internal logic
 // sessionID is unique to be able to get the history
-func (l *Logic) HandleMessage(ctx context.Context, sessionID string, req domain.Request) (string, error) {
+func (l *Logic) HandleMessage(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error) {
 	if sessionID == "" {
-		return "", errors.New("sessionID is empty")
+		return domain.Response{}, errors.New("sessionID is empty")
 	}
 	logger := l.logger.With("sessionID", sessionID)
 	logger.Info("handling message", slog.String("message", req.Message))
 
-	hist, err := l.history.Read(ctx, sessionID)
+	// Native tools could scope their state to the session
+	ctx = domain.WithSessionID(ctx, sessionID)
+	ctx = domain.WithChannel(ctx, req.Channel)
+
+	t := &trace{}
+	ctx = withTrace(ctx, t)
+
+	if l.memory != nil && strings.TrimSpace(req.Message) == ForgetCommand {
+		if err := l.memory.Forget(ctx, sessionID); err != nil {
+			return domain.Response{}, err
+		}
+		logger.Info("user memory deleted")
+
+		return domain.Response{Text: "I have forgotten everything I knew about you."}, nil
+	}
+
+	hist, head, err := l.history.Read(ctx, sessionID)
 	if err != nil {
-		return "", err
+		return domain.Response{}, err
 	}
 
 	logger.Info("generating chat content")
@@ -118,7 +147,7 @@ func (l *Logic) HandleMessage(ctx context.Context, sessionID string, req domain.
 		if err != nil {
 			logger.Error("failed to query RAG content", slog.String("error", err.Error()))
 
-			return "", err
+			return domain.Response{}, err
 		}
 
 		// If we found content, collect it and log
@@ -141,35 +170,85 @@ func (l *Logic) HandleMessage(ctx context.Context, sessionID string, req domain.
 	logger.Debug("message parts sending to LLM", slog.Any("parts", userPromptParts))
 	// TODO: We could re-use a flow here maybe, but for simplicity we create a new generate just for each message. We can optimize later if needed.
 
+	memoryPrompt := ""
+	if l.memory != nil {
+		memoryPrompt, err = l.memory.Prompt(ctx, sessionID)
+		if err != nil {
+			return domain.Response{}, err
+		}
+	}
+
+	var answeredBy string
+	system, err := l.persona.Render(persona.Data{
+		UserID:  sessionID,
+		Channel: req.Channel,
+		Locale:  req.Locale,
+		Memory:  memoryPrompt,
+	})
+	if err != nil {
+		return domain.Response{}, err
+	}
+
 	genOpts := []ai.GenerateOption{
 		ai.WithModel(l.model),
-		ai.WithSystem(l.persona),
+		ai.WithSystem("%s", system), // The persona and the facts could contain formatting verbs
 		ai.WithTools(l.toolRefs...),
 		ai.WithToolChoice(ai.ToolChoiceAuto),
 		ai.WithMessages(hist...),
 		ai.WithConfig(l.customConfig), // It has a nil check internally
 	}
 
+	if req.Schema != nil {
+		genOpts = append(genOpts, ai.WithOutputSchema(req.Schema))
+	}
+
+	// The middleware could be set only once, the first one is the outer, so the usage sees the model which answered after the retries
+	// The output validation is the outermost, so every repeated model call is counted.
+	middlewares := make([]ai.ModelMiddleware, 0, 4)
+	if req.Schema != nil {
+		middlewares = append(middlewares, outputMiddleware(logger, req.Schema, l.retry.OutputRetries))
+	}
+	if l.usage != nil {
+		middlewares = append(middlewares, l.usage.Middleware(usage.KindChat, func() string { return answeredBy }))
+	}
+	middlewares = append(middlewares, traceMiddleware, l.retryMiddleware(logger, &answeredBy))
+	genOpts = append(genOpts, ai.WithMiddleware(middlewares...))
+
 	if len(ragContextDocs) > 0 {
 		genOpts = append(genOpts, ai.WithDocs(ragContextDocs...))
 	}
 
 	resp, err := genkit.Generate(ctx, l.g, genOpts...) // TODO: if we rewrite, make this smarter
 	if err != nil {
-		return "", err
+		return domain.Response{}, err
 	}
 
 	// TODO: Think about a better history management, since this contains the RAG messages too, maybe we want to separate them? For now we just save everything in the history, but we could optimize later if needed.
-	err = l.history.Save(ctx, sessionID, resp.History())
+	err = l.history.Save(ctx, sessionID, head, resp.History())
+	if err != nil {
+		return domain.Response{}, err
+	}
 
-	return resp.Text(), err
-}
+	if l.memory != nil {
+		l.memory.ExtractAsync(ctx, sessionID, req.Message, resp.Text())
+	}
 
-func readPersonality() (string, error) {
-	b, err := os.ReadFile("personality.txt")
-	if err != nil {
-		return "", err
+	var data any
+	if req.Schema != nil {
+		if err := resp.Output(&data); err != nil {
+			return domain.Response{}, fmt.Errorf("failed to parse the structured answer: %w", err)
+		}
 	}
 
-	return string(b), nil
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return domain.Response{
+		Text:    resp.Text(),
+		Data:    data,
+		Model:   answeredBy,
+		Sources: append(ragSources(ragContextDocs), t.sources...),
+		Tools:   t.tools,
+		Usage:   t.usage,
+	}, nil
 }
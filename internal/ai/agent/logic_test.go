@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/ai/fake"
+	"hairy-botter/internal/persona"
+	"hairy-botter/internal/tools"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+type memHistory struct {
+	saved map[string][]*ai.Message
+}
+
+func (h *memHistory) Read(ctx context.Context, sessionID string) ([]*ai.Message, int, error) {
+	return h.saved[sessionID], len(h.saved[sessionID]), nil
+}
+
+func (h *memHistory) Save(ctx context.Context, sessionID string, head int, history []*ai.Message) error {
+	h.saved[sessionID] = history
+	return nil
+}
+
+type staticPersona string
+
+func (p staticPersona) Render(data persona.Data) (string, error) {
+	return string(p), nil
+}
+
+var testModelOpts = &ai.ModelOptions{
+	Supports: &ai.ModelSupports{Multiturn: true, SystemRole: true, Tools: true},
+}
+
+// defineFailingModel returns a model which fails with the given error for the first failures calls, then answers with its name
+func defineFailingModel(g *genkit.Genkit, name string, failures int32, failErr error) (ai.Model, *atomic.Int32) {
+	calls := &atomic.Int32{}
+	m := genkit.DefineModel(g, name, testModelOpts, func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		if calls.Add(1) <= failures {
+			return nil, failErr
+		}
+
+		return &ai.ModelResponse{
+			Request: req,
+			Message: ai.NewModelTextMessage("answer from " + name),
+		}, nil
+	})
+
+	return m, calls
+}
+
+func newTestLogic(t *testing.T, g *genkit.Genkit, model ai.Model, retry RetryConfig) *Logic {
+	t.Helper()
+
+	l, err := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), g, model, &memHistory{saved: make(map[string][]*ai.Message)}, nil, staticPersona("You are a test bot."), nil, nil, nil, nil, nil, nil, retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestRetryAndFallback(t *testing.T) {
+	ctx := context.Background()
+	overloaded := core.NewError(core.UNAVAILABLE, "model is overloaded")
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	t.Run("retry succeeds on the primary model", func(t *testing.T) {
+		g := genkit.Init(ctx)
+		primary, primaryCalls := defineFailingModel(g, "test/primary", 2, overloaded)
+		fallback, fallbackCalls := defineFailingModel(g, "test/fallback", 0, nil)
+
+		l := newTestLogic(t, g, primary, RetryConfig{Policy: policy, Fallbacks: []ai.Model{fallback}})
+		resp, err := l.HandleMessage(ctx, "session", domain.Request{Message: "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Model != "test/primary" || resp.Text != "answer from test/primary" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+		if primaryCalls.Load() != 3 || fallbackCalls.Load() != 0 {
+			t.Errorf("unexpected calls, primary: %d, fallback: %d", primaryCalls.Load(), fallbackCalls.Load())
+		}
+	})
+
+	t.Run("fallback answers when the primary keeps failing", func(t *testing.T) {
+		g := genkit.Init(ctx)
+		primary, primaryCalls := defineFailingModel(g, "test/primary", 100, overloaded)
+		broken, brokenCalls := defineFailingModel(g, "test/broken", 100, errors.New("Error 429, Message: quota exceeded"))
+		fallback, _ := defineFailingModel(g, "test/fallback", 1, overloaded)
+
+		l := newTestLogic(t, g, primary, RetryConfig{Policy: policy, Fallbacks: []ai.Model{broken, fallback}})
+		resp, err := l.HandleMessage(ctx, "session", domain.Request{Message: "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Model != "test/fallback" || resp.Text != "answer from test/fallback" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+		if primaryCalls.Load() != 3 || brokenCalls.Load() != 3 {
+			t.Errorf("unexpected calls, primary: %d, broken: %d", primaryCalls.Load(), brokenCalls.Load())
+		}
+	})
+
+	t.Run("fallback doesn't get the config of the primary", func(t *testing.T) {
+		g := genkit.Init(ctx)
+		primary, _ := defineFailingModel(g, "test/primary", 100, overloaded)
+		var fallbackConfig any = "unset"
+		fallback := genkit.DefineModel(g, "test/fallback", testModelOpts, func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			fallbackConfig = req.Config
+			return &ai.ModelResponse{Request: req, Message: ai.NewModelTextMessage("answer")}, nil
+		})
+
+		l := newTestLogic(t, g, primary, RetryConfig{Policy: policy, Fallbacks: []ai.Model{fallback}})
+		l.customConfig = map[string]any{"thinkingConfig": map[string]any{"thinkingBudget": 0}}
+		if _, err := l.HandleMessage(ctx, "session", domain.Request{Message: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+		if fallbackConfig != nil {
+			t.Errorf("expected no config for the fallback, got %v", fallbackConfig)
+		}
+	})
+
+	t.Run("permanent error is not retried", func(t *testing.T) {
+		g := genkit.Init(ctx)
+		primary, primaryCalls := defineFailingModel(g, "test/primary", 100, core.NewError(core.INVALID_ARGUMENT, "bad request"))
+		fallback, fallbackCalls := defineFailingModel(g, "test/fallback", 0, nil)
+
+		l := newTestLogic(t, g, primary, RetryConfig{Policy: policy, Fallbacks: []ai.Model{fallback}})
+		if _, err := l.HandleMessage(ctx, "session", domain.Request{Message: "hi"}); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+		if primaryCalls.Load() != 1 || fallbackCalls.Load() != 0 {
+			t.Errorf("unexpected calls, primary: %d, fallback: %d", primaryCalls.Load(), fallbackCalls.Load())
+		}
+	})
+
+	t.Run("every model fails", func(t *testing.T) {
+		g := genkit.Init(ctx)
+		primary, _ := defineFailingModel(g, "test/primary", 100, overloaded)
+		fallback, fallbackCalls := defineFailingModel(g, "test/fallback", 100, overloaded)
+
+		l := newTestLogic(t, g, primary, RetryConfig{Policy: RetryPolicy{}, Fallbacks: []ai.Model{fallback}})
+		if _, err := l.HandleMessage(ctx, "session", domain.Request{Message: "hi"}); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+		if fallbackCalls.Load() != 1 {
+			t.Errorf("expected a single fallback call without retry, got %d", fallbackCalls.Load())
+		}
+	})
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+
+	for retry, want := range []time.Duration{100, 200, 300, 300} {
+		want *= time.Millisecond
+		for range 20 {
+			d := p.delay(retry)
+			if d < want/2 || d > want {
+				t.Fatalf("delay(%d) = %v, want between %v and %v", retry, d, want/2, want)
+			}
+		}
+	}
+
+	// A high retry without a max delay must not overflow
+	unlimited := RetryPolicy{BaseDelay: time.Second}
+	for _, retry := range []int{40, 63, 100} {
+		if d := unlimited.delay(retry); d <= 0 {
+			t.Errorf("delay(%d) = %v, want a positive delay", retry, d)
+		}
+	}
+
+	if d := (RetryPolicy{}).delay(3); d != 0 {
+		t.Errorf("expected no delay without a base delay, got %v", d)
+	}
+}
+
+func TestResponseMetadata(t *testing.T) {
+	ctx := context.Background()
+	p := fake.New()
+	g := genkit.Init(ctx, genkit.WithPlugins(p))
+	model, err := p.DefineModel(g, "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	nativeTools, err := tools.New(logger, tools.Config{Enabled: []string{tools.GroupCalculator}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := New(logger, g, model, &memHistory{saved: make(map[string][]*ai.Message)}, nil, staticPersona("You are a test bot."), nil, nil, nativeTools, nil, nil, nil, RetryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := l.HandleMessage(ctx, "session", domain.Request{Message: `/call calculator {"expression": "6*7"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != `calculator returned "42"` {
+		t.Errorf("unexpected answer: %q", resp.Text)
+	}
+	if len(resp.Tools) != 1 || resp.Tools[0].Name != tools.NameCalculator || resp.Tools[0].Error != "" {
+		t.Fatalf("unexpected tool calls: %+v", resp.Tools)
+	}
+	if args, ok := resp.Tools[0].Args.(map[string]any); !ok || args["expression"] != "6*7" {
+		t.Errorf("unexpected tool args: %#v", resp.Tools[0].Args)
+	}
+	// Two model calls, the tool request and the final answer
+	if resp.Usage.InputTokens == 0 || resp.Usage.OutputTokens != 3 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+
+	// The tool error is passed to the model and traced, the answer doesn't fail
+	resp, err = l.HandleMessage(ctx, "session", domain.Request{Message: `/call calculator {"expression": "1/0"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp.Text, "division by zero") {
+		t.Errorf("expected the model to get the tool error, got %q", resp.Text)
+	}
+	if len(resp.Tools) != 1 || !strings.Contains(resp.Tools[0].Error, "division by zero") {
+		t.Errorf("expected the traced tool error, got %+v", resp.Tools)
+	}
+}
+
+func TestTraceArgs(t *testing.T) {
+	long := strings.Repeat("x", 1000)
+	got := traceArgs(map[string]any{"path": "a.txt", "content": long, "lines": []any{long, 3.0}}).(map[string]any)
+
+	if got["path"] != "a.txt" {
+		t.Errorf("expected the short argument as is, got %v", got["path"])
+	}
+	content, _ := got["content"].(string)
+	if !strings.HasPrefix(content, "sha256:") || !strings.HasSuffix(content, "(1000 bytes)") {
+		t.Errorf("expected the hashed content, got %q", content)
+	}
+	if lines := got["lines"].([]any); lines[0] != content || lines[1] != 3.0 {
+		t.Errorf("unexpected nested arguments: %v", lines)
+	}
+
+	if msg := traceError(errors.New(long)); len(msg) > maxTraceError+20 {
+		t.Errorf("expected a truncated error, got %d bytes", len(msg))
+	}
+}
+
+func TestSearchSources(t *testing.T) {
+	resp := &ai.ModelResponse{Custom: map[string]any{
+		"candidates": []map[string]any{{
+			"groundingMetadata": map[string]any{
+				"groundingChunks": []map[string]any{
+					{"web": map[string]any{"uri": "https://example.com/a", "title": "A"}},
+					{"maps": map[string]any{"uri": "https://maps.example.com"}},
+				},
+			},
+		}},
+	}}
+
+	sources := searchSources(resp)
+	if len(sources) != 1 || sources[0].URL != "https://example.com/a" || sources[0].Title != "A" || sources[0].Type != domain.SourceSearch {
+		t.Errorf("unexpected sources: %+v", sources)
+	}
+
+	if sources := searchSources(&ai.ModelResponse{}); len(sources) != 0 {
+		t.Errorf("expected no sources, got %+v", sources)
+	}
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"hairy-botter/internal/ai/domain"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// SessionHeader carries the session of the call to the MCP servers, so they could keep the state of the conversations apart
+const SessionHeader = "x-session-id"
+
+// mcpServer is a connected MCP server, its tools and prompts are offered to the model
+type mcpServer struct {
+	name         string
+	client       *client.Client
+	capabilities mcp.ServerCapabilities
+}
+
+// sessionHeader returns the session of the request for the MCP calls
+func sessionHeader(ctx context.Context) map[string]string {
+	sessionID := domain.SessionIDFromContext(ctx)
+	if sessionID == "" {
+		return nil
+	}
+
+	return map[string]string{SessionHeader: sessionID}
+}
+
+// connectMCP connects to the MCP servers, it fails if any of them is unreachable, so a missing server is noticed at the startup
+// The genkit MCP plugin only sends static headers, so the clients are created here to send the session of every call.
+func connectMCP(ctx context.Context, addrs []string, headers map[string]string) ([]mcpServer, error) {
+	servers := make([]mcpServer, 0, len(addrs))
+	closeAll := func() {
+		for _, s := range servers {
+			_ = s.client.Close()
+		}
+	}
+
+	for i, addr := range addrs {
+		c, err := client.NewStreamableHttpClient(addr, transport.WithHTTPHeaders(headers), transport.WithHTTPHeaderFunc(sessionHeader))
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to create the MCP client of %s: %w", addr, err)
+		}
+
+		capabilities, err := initialize(ctx, c)
+		if err != nil {
+			_ = c.Close()
+			closeAll()
+			return nil, fmt.Errorf("failed to connect to the MCP server %s: %w", addr, err)
+		}
+
+		servers = append(servers, mcpServer{
+			name:         fmt.Sprintf("mcp-client-%d", i), // Unique name for each client
+			client:       c,
+			capabilities: capabilities,
+		})
+	}
+
+	return servers, nil
+}
+
+func initialize(ctx context.Context, c *client.Client) (mcp.ServerCapabilities, error) {
+	if err := c.Start(ctx); err != nil {
+		return mcp.ServerCapabilities{}, err
+	}
+
+	var initReq mcp.InitializeRequest
+	initReq.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initReq.Params.ClientInfo = mcp.Implementation{Name: "hairy-botter", Version: "1.0.0"}
+	res, err := c.Initialize(ctx, initReq)
+	if err != nil {
+		return mcp.ServerCapabilities{}, fmt.Errorf("failed to initialize: %w", err)
+	}
+
+	return res.Capabilities, nil
+}
+
+// mcpTools lists the tools of the server, the names are prefixed with the server name
+func mcpTools(ctx context.Context, s mcpServer) ([]ai.Tool, error) {
+	if s.capabilities.Tools == nil {
+		return nil, nil
+	}
+
+	var tools []ai.Tool
+	var req mcp.ListToolsRequest
+	for {
+		list, err := s.client.ListTools(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the tools of %s: %w", s.name, err)
+		}
+
+		for _, t := range list.Tools {
+			tool, err := mcpTool(s, t)
+			if err != nil {
+				return nil, err
+			}
+			tools = append(tools, tool)
+		}
+
+		if list.NextCursor == "" {
+			return tools, nil
+		}
+		req.Params.Cursor = list.NextCursor
+	}
+}
+
+// mcpTool calls the tool with the context of the generation, so the session header is set
+func mcpTool(s mcpServer, t mcp.Tool) (ai.Tool, error) {
+	var schema map[string]any
+	b, err := json.Marshal(t.InputSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the input schema of %s: %w", t.Name, err)
+	}
+	if err := json.Unmarshal(b, &schema); err != nil || schema == nil {
+		schema = map[string]any{}
+	}
+
+	return ai.NewTool(s.name+"_"+t.Name, t.Description,
+		func(ctx *ai.ToolContext, input any) (any, error) {
+			var req mcp.CallToolRequest
+			req.Params.Name = t.Name
+			req.Params.Arguments = input
+
+			res, err := s.client.CallTool(ctx, req)
+			if err != nil {
+				return nil, fmt.Errorf("failed to call tool %s: %w", t.Name, err)
+			}
+			// The failures of the tool come back as a result, they are returned as errors to be traced
+			if res.IsError {
+				return nil, fmt.Errorf("tool %s failed: %s", t.Name, resultText(res))
+			}
+
+			return res, nil
+		}, ai.WithInputSchema(schema)), nil
+}
+
+// resultText joins the text contents of the result
+func resultText(res *mcp.CallToolResult) string {
+	var texts []string
+	for _, c := range res.Content {
+		if text, ok := mcp.AsTextContent(c); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hairy-botter/internal/ai/domain"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+type sessionKey struct{}
+
+func TestMCPToolsSendSession(t *testing.T) {
+	srv := server.NewMCPServer("test", "1.0.0")
+	srv.AddTool(mcp.NewTool("whoami", mcp.WithDescription("Returns the session")),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			sessionID, _ := ctx.Value(sessionKey{}).(string)
+			return mcp.NewToolResultText(sessionID), nil
+		})
+	srv.AddTool(mcp.NewTool("fail", mcp.WithDescription("Always fails")),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultError("boom"), nil
+		})
+	ts := httptest.NewServer(server.NewStreamableHTTPServer(srv, server.WithHTTPContextFunc(func(ctx context.Context, r *http.Request) context.Context {
+		return context.WithValue(ctx, sessionKey{}, r.Header.Get(SessionHeader))
+	})))
+	defer ts.Close()
+
+	servers, err := connectMCP(context.Background(), []string{ts.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tools, err := mcpTools(context.Background(), servers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]ai.Tool)
+	for _, tool := range tools {
+		byName[tool.Name()] = tool
+	}
+	whoami, fail := byName["mcp-client-0_whoami"], byName["mcp-client-0_fail"]
+	if len(tools) != 2 || whoami == nil || fail == nil {
+		t.Fatalf("unexpected tools: %v", tools)
+	}
+
+	// Every call carries its own session
+	for _, sessionID := range []string{"tg-1", "tg-2"} {
+		out, err := whoami.RunRaw(domain.WithSessionID(context.Background(), sessionID), map[string]any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := out.(map[string]any)["content"].([]any)
+		if len(content) != 1 {
+			t.Fatalf("unexpected result: %#v", out)
+		}
+		if text := content[0].(map[string]any)["text"]; text != sessionID {
+			t.Errorf("expected the session %s, got %q", sessionID, text)
+		}
+	}
+
+	// The failed results are errors, so they are traced
+	if _, err := fail.RunRaw(context.Background(), map[string]any{}); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the tool error, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// invalidOutputPrompt asks the model to correct its answer, the validation error is included
+const invalidOutputPrompt = "Your answer doesn't match the required JSON schema:\n%s\nRespond again with only the corrected JSON."
+
+// extractJSON removes the markdown code fence around the JSON, the models like to add it
+func extractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if rest, ok := strings.CutPrefix(text, "```"); ok {
+		_, rest, _ = strings.Cut(rest, "\n") // Drop the language of the fence
+		text, _, _ = strings.Cut(rest, "```")
+	}
+
+	return strings.TrimSpace(text)
+}
+
+// validateOutput checks the text against the schema
+func validateOutput(schema gojsonschema.JSONLoader, text string) error {
+	text = extractJSON(text)
+	if !json.Valid([]byte(text)) {
+		return errors.New("the answer is not a valid JSON")
+	}
+
+	res, err := gojsonschema.Validate(schema, gojsonschema.NewStringLoader(text))
+	if err != nil {
+		return err
+	}
+	if res.Valid() {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(res.Errors()))
+	for _, e := range res.Errors() {
+		msgs = append(msgs, "- "+e.String())
+	}
+
+	return errors.New(strings.Join(msgs, "\n"))
+}
+
+// outputMiddleware calls the model again with the validation errors when the final answer doesn't match the schema
+// Only the model call is repeated, not the tool calls. The last answer is returned anyway, genkit reports its error.
+func outputMiddleware(logger *slog.Logger, schema map[string]any, retries int) ai.ModelMiddleware {
+	loader := gojsonschema.NewGoLoader(schema)
+
+	return func(next ai.ModelFunc) ai.ModelFunc {
+		return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			original := req
+			for attempt := 0; ; attempt++ {
+				resp, err := next(ctx, req, cb)
+				if err != nil || len(resp.ToolRequests()) > 0 {
+					return resp, err
+				}
+
+				verr := validateOutput(loader, resp.Text())
+				if verr == nil || attempt >= retries {
+					resp.Request = original // Keep the corrections out of the history
+					return resp, nil
+				}
+				logger.Warn("structured answer doesn't match the schema, retrying", slog.Int("attempt", attempt+1), slog.String("error", verr.Error()))
+
+				retryReq := *req
+				retryReq.Messages = append(slices.Clone(req.Messages), resp.Message, ai.NewUserTextMessage(fmt.Sprintf(invalidOutputPrompt, verr)))
+				req = &retryReq
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/ai/fake"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+var triageSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"category": map[string]any{"type": "string", "enum": []any{"spam", "work", "private"}},
+		"priority": map[string]any{"type": "integer"},
+	},
+	"required": []any{"category", "priority"},
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := map[string]string{
+		`{"a": 1}`:                 `{"a": 1}`,
+		"```json\n{\"a\": 1}\n```": `{"a": 1}`,
+		"  ```\n[1, 2]\n```  ":     `[1, 2]`,
+		"not json":                 "not json",
+	}
+	for in, want := range tests {
+		if got := extractJSON(in); got != want {
+			t.Errorf("extractJSON(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	ctx := context.Background()
+
+	newLogic := func(t *testing.T, outputRetries int, script ...fake.Step) *Logic {
+		t.Helper()
+
+		p := fake.New(script...)
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+		model, err := p.DefineModel(g, "scripted", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), g, model, &memHistory{saved: make(map[string][]*ai.Message)}, nil, staticPersona("You are a test bot."), nil, nil, nil, nil, nil, nil, RetryConfig{OutputRetries: outputRetries})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return l
+	}
+
+	t.Run("invalid answer is retried", func(t *testing.T) {
+		l := newLogic(t, 2,
+			fake.Step{Text: "It is spam."},
+			fake.Step{Text: `{"category": "junk", "priority": 1}`},
+			fake.Step{Text: "```json\n{\"category\": \"spam\", \"priority\": 3}\n```"},
+		)
+
+		resp, err := l.HandleMessage(ctx, "session", domain.Request{Message: "Buy cheap watches", Schema: triageSchema})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, ok := resp.Data.(map[string]any)
+		if !ok || data["category"] != "spam" || data["priority"] != float64(3) {
+			t.Errorf("unexpected data: %#v", resp.Data)
+		}
+
+		// The corrections are not saved in the history
+		hist := l.history.(*memHistory).saved["session"]
+		if len(hist) != 3 || hist[1].Text() != "Buy cheap watches" {
+			t.Errorf("unexpected history: %d messages", len(hist))
+		}
+	})
+
+	t.Run("out of retries", func(t *testing.T) {
+		l := newLogic(t, 1, fake.Step{Text: "It is spam."}, fake.Step{Text: `{"category": "spam"}`})
+
+		if _, err := l.HandleMessage(ctx, "session", domain.Request{Message: "Buy cheap watches", Schema: triageSchema}); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("free text without schema", func(t *testing.T) {
+		l := newLogic(t, 2)
+
+		resp, err := l.HandleMessage(ctx, "session", domain.Request{Message: "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Data != nil || resp.Text != "echo: hi" {
+			t.Errorf("unexpected response: %+v", resp)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/history"
+)
+
+// Config describes a named agent
+type Config struct {
+	Name        string            `json:"name"`
+	Personality string            `json:"personality"` // Path of the personality template
+	Model       string            `json:"model"`       // Empty means the default model
+	Fallbacks   []string          `json:"fallbackModels"`
+	MCPServers  []string          `json:"mcpServers"`
+	MCPHeaders  map[string]string `json:"mcpHeaders"`  // Sent to every MCP server of the agent, the env vars are expanded
+	NativeTools []string          `json:"nativeTools"` // Native tool groups, see the tools package
+	RAGPath     string            `json:"ragPath"`     // Empty disables the RAG
+	HistoryPath string            `json:"historyPath"`
+}
+
+// Route selects an agent for the sessions starting with the prefix
+type Route struct {
+	Prefix string `json:"prefix"`
+	Agent  string `json:"agent"`
+}
+
+// RegistryConfig is the content of the agents config file
+type RegistryConfig struct {
+	Default string   `json:"default"` // Used if no other rule matches, the first agent if empty
+	Agents  []Config `json:"agents"`
+	Routes  []Route  `json:"routes"`
+}
+
+// LoadRegistryConfig reads and validates the agents config file
+func LoadRegistryConfig(path string) (RegistryConfig, error) {
+	var cfg RegistryConfig
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse agents config: %w", err)
+	}
+
+	if len(cfg.Agents) == 0 {
+		return cfg, errors.New("no agents configured")
+	}
+
+	names := make(map[string]struct{}, len(cfg.Agents))
+	for _, a := range cfg.Agents {
+		if a.Name == "" || strings.ContainsAny(a.Name, "/\\") {
+			return cfg, fmt.Errorf("invalid agent name: %q", a.Name)
+		}
+		if _, ok := names[a.Name]; ok {
+			return cfg, fmt.Errorf("duplicate agent name: %s", a.Name)
+		}
+		if a.HistoryPath == "" {
+			return cfg, fmt.Errorf("agent %s: historyPath is required", a.Name)
+		}
+		names[a.Name] = struct{}{}
+
+		// The secrets, e.g. the MCP tokens, could come from the environment
+		for k, v := range a.MCPHeaders {
+			a.MCPHeaders[k] = os.ExpandEnv(v)
+		}
+	}
+
+	if cfg.Default == "" {
+		cfg.Default = cfg.Agents[0].Name
+	}
+	if _, ok := names[cfg.Default]; !ok {
+		return cfg, fmt.Errorf("default agent %s is not configured", cfg.Default)
+	}
+
+	for _, r := range cfg.Routes {
+		if _, ok := names[r.Agent]; !ok {
+			return cfg, fmt.Errorf("route %s: agent %s is not configured", r.Prefix, r.Agent)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Registry routes the messages to the named agents
+type Registry struct {
+	agents       map[string]*Logic
+	defaultAgent string
+	routes       []Route
+}
+
+// NewRegistry .
+func NewRegistry(defaultAgent string, routes []Route) *Registry {
+	return &Registry{
+		agents:       make(map[string]*Logic),
+		defaultAgent: defaultAgent,
+		routes:       routes,
+	}
+}
+
+// Register adds a named agent, it is not safe to call it after the server started
+func (r *Registry) Register(name string, l *Logic) {
+	r.agents[name] = l
+}
+
+// Resolve returns the agent name for the request, an explicitly requested agent has priority over the session prefix routes
+func (r *Registry) Resolve(sessionID, requested string) string {
+	if requested != "" {
+		return requested
+	}
+
+	for _, route := range r.routes {
+		if strings.HasPrefix(sessionID, route.Prefix) {
+			return route.Agent
+		}
+	}
+
+	return r.defaultAgent
+}
+
+// HandleMessage passes the message to the selected agent
+func (r *Registry) HandleMessage(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error) {
+	l, err := r.agent(sessionID, req.Agent)
+	if err != nil {
+		return domain.Response{}, err
+	}
+
+	// The tools could store the agent, e.g. the reminders run on the same agent later
+	ctx = domain.WithAgent(ctx, r.Resolve(sessionID, req.Agent))
+
+	return l.HandleMessage(ctx, sessionID, req)
+}
+
+func (r *Registry) agent(sessionID, requested string) (*Logic, error) {
+	name := r.Resolve(sessionID, requested)
+
+	l, ok := r.agents[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", domain.ErrUnknownAgent, name)
+	}
+
+	return l, nil
+}
+
+// Branch returns the active history branch of the session from its agent
+func (r *Registry) Branch(ctx context.Context, sessionID, agent string) ([]history.Node, error) {
+	l, err := r.agent(sessionID, agent)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.Branch(ctx, sessionID)
+}
+
+// Regenerate passes the request to the agent of the session
+func (r *Registry) Regenerate(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error) {
+	l, err := r.agent(sessionID, req.Agent)
+	if err != nil {
+		return domain.Response{}, err
+	}
+
+	ctx = domain.WithAgent(ctx, r.Resolve(sessionID, req.Agent))
+
+	return l.Regenerate(ctx, sessionID, req)
+}
+
+// Edit passes the request to the agent of the session
+func (r *Registry) Edit(ctx context.Context, sessionID string, messageID int, req domain.Request) (domain.Response, error) {
+	l, err := r.agent(sessionID, req.Agent)
+	if err != nil {
+		return domain.Response{}, err
+	}
+
+	ctx = domain.WithAgent(ctx, r.Resolve(sessionID, req.Agent))
+
+	return l.Edit(ctx, sessionID, messageID, req)
+}
+
+// Fork copies the history inside the agent of the session, the new session must be routed to the same agent
+func (r *Registry) Fork(ctx context.Context, sessionID, newSessionID, agent string, messageID int) error {
+	name := r.Resolve(sessionID, agent)
+	if agent == "" && r.Resolve(newSessionID, "") != name {
+		return fmt.Errorf("the new session %s is routed to an other agent than %s", newSessionID, name)
+	}
+
+	l, err := r.agent(sessionID, agent)
+	if err != nil {
+		return err
+	}
+
+	return l.Fork(ctx, sessionID, newSessionID, messageID)
+}
@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hairy-botter/internal/ai/domain"
+)
+
+func TestLoadRegistryConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		expectErr bool
+		wantDef   string
+	}{
+		{
+			name:    "default is the first agent",
+			content: `{"agents": [{"name": "a", "historyPath": "h/a"}, {"name": "b", "historyPath": "h/b"}]}`,
+			wantDef: "a",
+		},
+		{
+			name:      "no agents",
+			content:   `{"agents": []}`,
+			expectErr: true,
+		},
+		{
+			name:      "duplicate name",
+			content:   `{"agents": [{"name": "a", "historyPath": "h/a"}, {"name": "a", "historyPath": "h/b"}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid name",
+			content:   `{"agents": [{"name": "../a", "historyPath": "h/a"}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "missing history path",
+			content:   `{"agents": [{"name": "a"}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "unknown default",
+			content:   `{"default": "x", "agents": [{"name": "a", "historyPath": "h/a"}]}`,
+			expectErr: true,
+		},
+		{
+			name:      "route to unknown agent",
+			content:   `{"agents": [{"name": "a", "historyPath": "h/a"}], "routes": [{"prefix": "tg-", "agent": "x"}]}`,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "agents.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadRegistryConfig(path)
+			if tt.expectErr {
+				if err == nil {
+					t.Error("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.Default != tt.wantDef {
+				t.Errorf("expected default %s, got %s", tt.wantDef, cfg.Default)
+			}
+		})
+	}
+}
+
+func TestLoadRegistryConfigHeaders(t *testing.T) {
+	t.Setenv("TEST_MCP_TOKEN", "secret")
+	path := filepath.Join(t.TempDir(), "agents.json")
+	content := `{"agents": [{"name": "a", "historyPath": "h/a", "mcpHeaders": {"Authorization": "Bearer ${TEST_MCP_TOKEN}"}}]}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadRegistryConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Agents[0].MCPHeaders["Authorization"]; got != "Bearer secret" {
+		t.Errorf("expected the expanded token, got %q", got)
+	}
+}
+
+func TestRegistryResolve(t *testing.T) {
+	r := NewRegistry("support", []Route{
+		{Prefix: "tg-", Agent: "ops"},
+		{Prefix: "fb-", Agent: "support"},
+	})
+
+	tests := []struct {
+		sessionID string
+		requested string
+		want      string
+	}{
+		{sessionID: "tg-123", want: "ops"},
+		{sessionID: "fb-123", want: "support"},
+		{sessionID: "web-session", want: "support"},
+		{sessionID: "tg-123", requested: "support", want: "support"},
+	}
+
+	for _, tt := range tests {
+		if got := r.Resolve(tt.sessionID, tt.requested); got != tt.want {
+			t.Errorf("Resolve(%q, %q) = %s, want %s", tt.sessionID, tt.requested, got, tt.want)
+		}
+	}
+
+	_, err := r.HandleMessage(context.Background(), "tg-123", domain.Request{Message: "hi"})
+	if !errors.Is(err, domain.ErrUnknownAgent) {
+		t.Errorf("expected ErrUnknownAgent, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+)
+
+// RetryPolicy describes how the transient model errors are retried
+type RetryPolicy struct {
+	MaxAttempts int           // Attempts per model, 0 or 1 means no retry
+	BaseDelay   time.Duration // Delay before the first retry, doubled for every next one
+	MaxDelay    time.Duration // Upper limit of the delay, 0 means no limit
+	Retryable   func(error) bool
+}
+
+// RetryConfig .
+type RetryConfig struct {
+	Policy        RetryPolicy
+	Fallbacks     []ai.Model // Tried in order when the previous model keeps failing with a transient error
+	OutputRetries int        // Extra model calls when the structured answer doesn't match the schema
+}
+
+// transientMarkers are matched in the error messages of the providers, the HTTP status codes are included by the Gemini API errors
+var transientMarkers = []string{
+	"Error 429", "Error 500", "Error 502", "Error 503", "Error 504",
+	"RESOURCE_EXHAUSTED", "UNAVAILABLE",
+}
+
+// IsTransient reports whether the error is worth a retry, like rate limits or overloaded servers
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var ge *core.GenkitError
+	if errors.As(err, &ge) {
+		switch ge.Status {
+		case core.RESOURCE_EXHAUSTED, core.UNAVAILABLE, core.DEADLINE_EXCEEDED:
+			return true
+		}
+	}
+
+	msg := err.Error()
+	for _, m := range transientMarkers {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+
+	return IsTransient(err)
+}
+
+// delay returns the backoff before the given retry with jitter, so the clients won't retry at the same time
+func (p RetryPolicy) delay(retry int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	// The shift is clamped, so the delay can't overflow without a max delay
+	d := time.Duration(math.MaxInt64)
+	if shift := min(retry, 62); p.BaseDelay <= d>>shift {
+		d = p.BaseDelay << shift
+	}
+	if p.MaxDelay > 0 {
+		d = min(d, p.MaxDelay)
+	}
+
+	// Use a random delay between the half and the full backoff
+	return d/2 + rand.N(d/2+1)
+}
+
+// retry calls the fn until it succeeds, returns a non-retryable error or runs out of attempts
+func (p RetryPolicy) retry(ctx context.Context, logger *slog.Logger, fn func() (*ai.ModelResponse, error)) (*ai.ModelResponse, error) {
+	attempts := max(p.MaxAttempts, 1)
+
+	var err error
+	for i := range attempts {
+		var resp *ai.ModelResponse
+		resp, err = fn()
+		if err == nil {
+			return resp, nil
+		}
+		if i == attempts-1 || !p.retryable(err) {
+			break
+		}
+
+		d := p.delay(i)
+		logger.Warn("model call failed, retrying", slog.Int("attempt", i+1), slog.Duration("delay", d), slog.String("error", err.Error()))
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	return nil, err
+}
+
+// retryMiddleware retries every model call of the generation and switches to the fallback models on transient errors
+// The tool calls are not repeated, only the failed model call. The name of the answering model is stored in answeredBy.
+func (l *Logic) retryMiddleware(logger *slog.Logger, answeredBy *string) ai.ModelMiddleware {
+	return func(next ai.ModelFunc) ai.ModelFunc {
+		return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			resp, err := l.retry.Policy.retry(ctx, logger, func() (*ai.ModelResponse, error) {
+				return next(ctx, req, cb)
+			})
+			if err == nil {
+				*answeredBy = l.model.Name()
+				return resp, nil
+			}
+
+			// The custom config belongs to the provider of the primary model, e.g. the Gemini settings
+			fallbackReq := *req
+			fallbackReq.Config = nil
+			for _, fallback := range l.retry.Fallbacks {
+				if !l.retry.Policy.retryable(err) || ctx.Err() != nil {
+					return nil, err
+				}
+				logger.Warn("switching to fallback model", slog.String("model", fallback.Name()), slog.String("error", err.Error()))
+
+				resp, err = l.retry.Policy.retry(ctx, logger, func() (*ai.ModelResponse, error) {
+					return fallback.Generate(ctx, &fallbackReq, cb)
+				})
+				if err == nil {
+					*answeredBy = fallback.Name()
+					return resp, nil
+				}
+			}
+
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// SkillToolName is the tool which loads the skill prompts of the MCP servers
+const SkillToolName = "use_skill"
+
+// skill is a prompt of an MCP server
+type skill struct {
+	prompt mcp.Prompt
+	client *client.Client
+}
+
+type skillInput struct {
+	Name      string            `json:"name" jsonschema:"description=The name of the skill"`
+	Arguments map[string]string `json:"arguments,omitempty" jsonschema:"description=The arguments of the skill"`
+}
+
+// loadSkills lists the prompts of the MCP servers, the servers without prompts are skipped
+// The genkit MCP plugin caches the prompts with the first arguments, so the prompts are fetched directly.
+func loadSkills(ctx context.Context, logger *slog.Logger, servers []mcpServer) map[string]skill {
+	skills := make(map[string]skill)
+
+	for _, s := range servers {
+		if s.capabilities.Prompts == nil {
+			continue
+		}
+
+		list, err := s.client.ListPrompts(ctx, mcp.ListPromptsRequest{})
+		if err != nil {
+			logger.Warn("failed to list the skills", slog.String("server", s.name), slog.String("err", err.Error()))
+			continue
+		}
+
+		for _, p := range list.Prompts {
+			if _, ok := skills[p.Name]; ok {
+				logger.Warn("duplicate skill, the first one is used", slog.String("skill", p.Name), slog.String("server", s.name))
+				continue
+			}
+			skills[p.Name] = skill{prompt: p, client: s.client}
+		}
+	}
+
+	return skills
+}
+
+// defineSkillTool lists the skills in the description, so the model knows them without loading every one
+func defineSkillTool(skills map[string]skill) ai.Tool {
+	names := make([]string, 0, len(skills))
+	for name := range skills {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var desc strings.Builder
+	desc.WriteString("Load the instructions of a skill and follow them. Use it when a task matches a skill. The available skills:")
+	for _, name := range names {
+		p := skills[name].prompt
+		fmt.Fprintf(&desc, "\n- %s: %s", name, p.Description)
+
+		args := make([]string, 0, len(p.Arguments))
+		for _, a := range p.Arguments {
+			arg := a.Name
+			if a.Required {
+				arg += " (required)"
+			}
+			if a.Description != "" {
+				arg += ": " + a.Description
+			}
+			args = append(args, arg)
+		}
+		if len(args) > 0 {
+			fmt.Fprintf(&desc, " Arguments: %s.", strings.Join(args, "; "))
+		}
+	}
+
+	return ai.NewTool(SkillToolName, desc.String(),
+		func(ctx *ai.ToolContext, input skillInput) (string, error) {
+			s, ok := skills[input.Name]
+			if !ok {
+				return "", fmt.Errorf("unknown skill: %s", input.Name)
+			}
+
+			var req mcp.GetPromptRequest
+			req.Params.Name = input.Name
+			req.Params.Arguments = input.Arguments
+			res, err := s.client.GetPrompt(ctx, req)
+			if err != nil {
+				return "", fmt.Errorf("failed to load the skill: %w", err)
+			}
+
+			var text strings.Builder
+			for _, m := range res.Messages {
+				if tc, ok := m.Content.(mcp.TextContent); ok {
+					text.WriteString(tc.Text)
+					text.WriteString("\n")
+				}
+			}
+
+			return text.String(), nil
+		})
+}
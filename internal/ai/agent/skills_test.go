@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestSkills(t *testing.T) {
+	srv := server.NewMCPServer("test", "1.0.0")
+	srv.AddPrompt(mcp.NewPrompt("greet",
+		mcp.WithPromptDescription("Greet someone"),
+		mcp.WithArgument("who", mcp.ArgumentDescription("The name"), mcp.RequiredArgument()),
+	), func(ctx context.Context, req mcp.GetPromptRequest) (*mcp.GetPromptResult, error) {
+		return mcp.NewGetPromptResult("Greet someone", []mcp.PromptMessage{
+			mcp.NewPromptMessage(mcp.RoleUser, mcp.NewTextContent("Say hello to "+req.Params.Arguments["who"])),
+		}), nil
+	})
+	withPrompts := httptest.NewServer(server.NewStreamableHTTPServer(srv))
+	defer withPrompts.Close()
+	withoutPrompts := httptest.NewServer(server.NewStreamableHTTPServer(server.NewMCPServer("empty", "1.0.0")))
+	defer withoutPrompts.Close()
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	if _, err := connectMCP(context.Background(), []string{withPrompts.URL, "http://127.0.0.1:1"}, nil); err == nil {
+		t.Fatal("expected an error for the unreachable server")
+	}
+	servers, err := connectMCP(context.Background(), []string{withoutPrompts.URL, withPrompts.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skills := loadSkills(context.Background(), logger, servers)
+	if len(skills) != 1 {
+		t.Fatalf("expected one skill, got %d", len(skills))
+	}
+
+	tool := defineSkillTool(skills)
+	if desc := tool.Definition().Description; !strings.Contains(desc, "- greet: Greet someone Arguments: who (required): The name.") {
+		t.Errorf("unexpected description: %q", desc)
+	}
+
+	// The arguments are sent on every call, not only on the first one
+	for _, who := range []string{"Bob", "Alice"} {
+		out, err := tool.RunRaw(context.Background(), map[string]any{"name": "greet", "arguments": map[string]any{"who": who}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != "Say hello to "+who+"\n" {
+			t.Errorf("unexpected skill text: %q", out)
+		}
+	}
+
+	if _, err := tool.RunRaw(context.Background(), map[string]any{"name": "missing"}); err == nil {
+		t.Error("expected an error for an unknown skill")
+	}
+}
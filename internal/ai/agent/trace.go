@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+const traceKey contextKey = "trace"
+
+const (
+	maxTraceArg   = 256  // Longer string arguments (e.g. file contents) are hashed, the trace is sent to every client
+	maxTraceError = 1024 // Longer tool errors are truncated
+)
+
+// trace collects the metadata of a single answer, the tools could run in parallel
+type trace struct {
+	mu      sync.Mutex
+	sources []domain.Source
+	tools   []domain.ToolCall
+	usage   domain.Usage
+}
+
+func withTrace(ctx context.Context, t *trace) context.Context {
+	return context.WithValue(ctx, traceKey, t)
+}
+
+func traceFromContext(ctx context.Context) *trace {
+	t, _ := ctx.Value(traceKey).(*trace)
+
+	return t
+}
+
+func (t *trace) addTool(call domain.ToolCall) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tools = append(t.tools, call)
+}
+
+func (t *trace) addResponse(resp *ai.ModelResponse) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if resp.Usage != nil {
+		t.usage.InputTokens += resp.Usage.InputTokens
+		t.usage.OutputTokens += resp.Usage.OutputTokens
+		t.usage.ThinkingTokens += resp.Usage.ThoughtsTokens
+	}
+
+	for _, s := range searchSources(resp) {
+		known := false
+		for _, existing := range t.sources {
+			if existing.Type == domain.SourceSearch && existing.URL == s.URL {
+				known = true
+				break
+			}
+		}
+		if !known {
+			t.sources = append(t.sources, s)
+		}
+	}
+}
+
+// traceMiddleware collects the usage and the search sources of every model call
+func traceMiddleware(next ai.ModelFunc) ai.ModelFunc {
+	return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+		resp, err := next(ctx, req, cb)
+		if err == nil && resp != nil {
+			if t := traceFromContext(ctx); t != nil {
+				t.addResponse(resp)
+			}
+		}
+
+		return resp, err
+	}
+}
+
+// groundedCandidate is the part of the Gemini candidate with the search results, it is decoded from JSON to stay provider independent
+type groundedCandidate struct {
+	GroundingMetadata *struct {
+		GroundingChunks []struct {
+			Web *struct {
+				URI   string `json:"uri"`
+				Title string `json:"title"`
+			} `json:"web"`
+		} `json:"groundingChunks"`
+	} `json:"groundingMetadata"`
+}
+
+// searchSources returns the search grounding results, the Gemini plugin puts the raw candidates in the custom field
+func searchSources(resp *ai.ModelResponse) []domain.Source {
+	custom, ok := resp.Custom.(map[string]any)
+	if !ok || custom["candidates"] == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(custom["candidates"])
+	if err != nil {
+		return nil
+	}
+	var candidates []groundedCandidate
+	if err := json.Unmarshal(b, &candidates); err != nil {
+		return nil
+	}
+
+	var sources []domain.Source
+	for _, c := range candidates {
+		if c.GroundingMetadata == nil {
+			continue
+		}
+		for _, chunk := range c.GroundingMetadata.GroundingChunks {
+			if chunk.Web == nil || chunk.Web.URI == "" {
+				continue
+			}
+			sources = append(sources, domain.Source{Type: domain.SourceSearch, URL: chunk.Web.URI, Title: chunk.Web.Title})
+		}
+	}
+
+	return sources
+}
+
+// ragSources returns the IDs and similarities of the retrieved documents
+func ragSources(docs []*ai.Document) []domain.Source {
+	sources := make([]domain.Source, 0, len(docs))
+	for _, doc := range docs {
+		s := domain.Source{Type: domain.SourceRAG}
+		s.ID, _ = doc.Metadata["id"].(string)
+		s.Similarity, _ = doc.Metadata["similarity"].(float32)
+		sources = append(sources, s)
+	}
+
+	return sources
+}
+
+// traceArgs replaces the long strings of the tool input with their hash
+func traceArgs(input any) any {
+	switch v := input.(type) {
+	case string:
+		if len(v) > maxTraceArg {
+			sum := sha256.Sum256([]byte(v))
+			return fmt.Sprintf("sha256:%s (%d bytes)", hex.EncodeToString(sum[:]), len(v))
+		}
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, a := range v {
+			out[k] = traceArgs(a)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, a := range v {
+			out[i] = traceArgs(a)
+		}
+		return out
+	}
+
+	return input
+}
+
+// traceError truncates the long tool errors, e.g. the output of a failed command
+func traceError(err error) string {
+	msg := err.Error()
+	if len(msg) > maxTraceError {
+		return msg[:maxTraceError] + "... [truncated]"
+	}
+
+	return msg
+}
+
+// trackTools wraps the tools, so their calls and errors are added to the trace of the request
+// The wrappers are not registered, genkit resolves them per generate call, so the tools must not be registered either.
+func trackTools(tools []ai.Tool) []ai.ToolRef {
+	refs := make([]ai.ToolRef, 0, len(tools))
+	for _, tool := range tools {
+		def := tool.Definition()
+
+		refs = append(refs, ai.NewMultipartTool(tool.Name(), def.Description,
+			func(ctx *ai.ToolContext, input any) (*ai.MultipartToolResponse, error) {
+				start := time.Now()
+				resp, err := tool.RunRawMultipart(ctx, input)
+				if interrupt, _ := ai.IsToolInterruptError(err); interrupt {
+					return resp, err
+				}
+
+				if t := traceFromContext(ctx); t != nil {
+					call := domain.ToolCall{Name: tool.Name(), Args: traceArgs(input), Duration: time.Since(start)}
+					if err != nil {
+						call.Error = traceError(err)
+					}
+					t.addTool(call)
+				}
+
+				// The error is the output of the tool, so the model could fix the call or explain it instead of failing the answer
+				if err != nil {
+					return &ai.MultipartToolResponse{Output: map[string]any{"error": err.Error()}}, nil
+				}
+
+				return resp, nil
+			},
+			ai.WithInputSchema(def.InputSchema),
+		))
+	}
+
+	return refs
+}
@@ -0,0 +1,47 @@
+package domain
+
+import "context"
+
+type contextKey string
+
+const sessionIDKey contextKey = "sessionID"
+
+// WithSessionID returns a context which carries the sessionID, tools can use it to scope their state to a conversation
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+// SessionIDFromContext returns the sessionID stored by WithSessionID or an empty string
+func SessionIDFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionIDKey).(string)
+
+	return sessionID
+}
+
+const channelKey contextKey = "channel"
+
+// WithChannel returns a context which carries the channel of the request
+func WithChannel(ctx context.Context, channel string) context.Context {
+	return context.WithValue(ctx, channelKey, channel)
+}
+
+// ChannelFromContext returns the channel stored by WithChannel or an empty string
+func ChannelFromContext(ctx context.Context) string {
+	channel, _ := ctx.Value(channelKey).(string)
+
+	return channel
+}
+
+const agentKey contextKey = "agent"
+
+// WithAgent returns a context which carries the name of the agent handling the request
+func WithAgent(ctx context.Context, agent string) context.Context {
+	return context.WithValue(ctx, agentKey, agent)
+}
+
+// AgentFromContext returns the agent stored by WithAgent or an empty string
+func AgentFromContext(ctx context.Context) string {
+	agent, _ := ctx.Value(agentKey).(string)
+
+	return agent
+}
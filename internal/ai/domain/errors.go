@@ -0,0 +1,6 @@
+package domain
+
+import "errors"
+
+// ErrUnknownAgent is returned when the requested agent is not configured
+var ErrUnknownAgent = errors.New("unknown agent")
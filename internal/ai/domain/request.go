@@ -1,10 +1,56 @@
 package domain
 
+import "time"
+
 type Request struct {
 	Message    string
 	InlineData []*InlineData
+	Channel    string         // Source of the message like telegram or web, used by the personality template
+	Locale     string         // Preferred locale of the user, e.g. en-US, empty if unknown
+	Agent      string         // Name of the requested agent, empty means the routing rules decide
+	Schema     map[string]any // JSON schema of a structured answer, nil means free text
 }
 type InlineData struct {
 	MimeType string
 	Data     []byte
 }
+
+// Response is the answer of the agent
+type Response struct {
+	Text    string
+	Data    any    // Parsed structured answer, only set if the request had a schema
+	Model   string // Name of the model which answered, empty if no model was called
+	Sources []Source
+	Tools   []ToolCall
+	Usage   Usage // Summed over every model call of the answer
+}
+
+// Source types
+const (
+	SourceSearch = "search"
+	SourceRAG    = "rag"
+)
+
+// Source is a search result or a RAG document used for the answer
+type Source struct {
+	Type       string
+	URL        string  // Search only
+	Title      string  // Search only
+	ID         string  // RAG document ID
+	Similarity float32 // RAG only
+}
+
+// ToolCall is a tool invoked during the answer
+type ToolCall struct {
+	Name     string
+	Args     any
+	Duration time.Duration
+	Error    string
+}
+
+// Usage is the token usage of the model calls
+type Usage struct {
+	InputTokens    int
+	OutputTokens   int
+	ThinkingTokens int
+}
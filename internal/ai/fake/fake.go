@@ -0,0 +1,234 @@
+// Package fake is a deterministic offline genkit plugin with an echo or scripted model and a hash based embedder
+// It is useful for tests and for running the bot without any API key.
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+const (
+	provider = "fake"
+
+	// CallPrefix makes the echo model call a tool, e.g. "/call calculator {"expression": "1+2"}"
+	CallPrefix = "/call "
+
+	// EmbeddingDim is the size of the embedding vectors
+	EmbeddingDim = 256
+)
+
+// Step is a scripted answer of the model
+type Step struct {
+	Text        string
+	ToolRequest *ai.ToolRequest
+	Err         error
+}
+
+// Plugin .
+type Plugin struct {
+	mu     sync.Mutex
+	script []Step
+}
+
+// New returns a plugin with an echo model, the script steps are answered first in order for any model call
+func New(script ...Step) *Plugin {
+	return &Plugin{script: script}
+}
+
+// Name .
+func (p *Plugin) Name() string {
+	return provider
+}
+
+// Init .
+func (p *Plugin) Init(ctx context.Context) []api.Action {
+	return nil
+}
+
+// DefineModel .
+func (p *Plugin) DefineModel(g *genkit.Genkit, name string, opts *ai.ModelOptions) (ai.Model, error) {
+	if opts == nil {
+		opts = &ai.ModelOptions{
+			Label: "Fake " + name,
+			Supports: &ai.ModelSupports{
+				Multiturn:  true,
+				SystemRole: true,
+				Tools:      true,
+				ToolChoice: true,
+				Media:      true,
+			},
+		}
+	}
+
+	return genkit.DefineModel(g, provider+"/"+name, opts, p.generate), nil
+}
+
+// DefineEmbedder .
+func (p *Plugin) DefineEmbedder(g *genkit.Genkit, name string, embedOpts *ai.EmbedderOptions) (ai.Embedder, error) {
+	return genkit.DefineEmbedder(g, provider+"/"+name, embedOpts, embed), nil
+}
+
+func (p *Plugin) nextStep() (Step, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.script) == 0 {
+		return Step{}, false
+	}
+	s := p.script[0]
+	p.script = p.script[1:]
+
+	return s, true
+}
+
+func (p *Plugin) generate(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+	var msg *ai.Message
+	if s, ok := p.nextStep(); ok {
+		if s.Err != nil {
+			return nil, s.Err
+		}
+		if s.ToolRequest != nil {
+			msg = ai.NewMessage(ai.RoleModel, nil, ai.NewToolRequestPart(s.ToolRequest))
+		} else {
+			msg = ai.NewModelTextMessage(s.Text)
+		}
+	} else {
+		msg = echo(req)
+	}
+
+	resp := &ai.ModelResponse{
+		Request:      req,
+		Message:      msg,
+		FinishReason: ai.FinishReasonStop,
+		Usage: &ai.GenerationUsage{
+			InputTokens:  countRequestTokens(req),
+			OutputTokens: countTokens(msg.Text()),
+		},
+	}
+	resp.Usage.TotalTokens = resp.Usage.InputTokens + resp.Usage.OutputTokens
+
+	if cb != nil {
+		if err := cb(ctx, &ai.ModelResponseChunk{Role: ai.RoleModel, Content: msg.Content}); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// echo answers the last message, the tool responses are summarized and the /call messages are turned into tool requests
+func echo(req *ai.ModelRequest) *ai.Message {
+	if len(req.Messages) == 0 {
+		return ai.NewModelTextMessage("echo: ")
+	}
+	last := req.Messages[len(req.Messages)-1]
+
+	if last.Role == ai.RoleTool {
+		results := make([]string, 0, len(last.Content))
+		for _, part := range last.Content {
+			if !part.IsToolResponse() {
+				continue
+			}
+			out, _ := json.Marshal(part.ToolResponse.Output)
+			results = append(results, fmt.Sprintf("%s returned %s", part.ToolResponse.Name, out))
+		}
+
+		return ai.NewModelTextMessage(strings.Join(results, "\n"))
+	}
+
+	text := last.Text()
+	if rest, ok := strings.CutPrefix(text, CallPrefix); ok {
+		name, input, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		for _, t := range req.Tools {
+			if t.Name != name {
+				continue
+			}
+
+			var in any
+			if strings.TrimSpace(input) != "" {
+				if err := json.Unmarshal([]byte(input), &in); err != nil {
+					return ai.NewModelTextMessage(fmt.Sprintf("invalid tool input: %v", err))
+				}
+			}
+
+			return ai.NewMessage(ai.RoleModel, nil, ai.NewToolRequestPart(&ai.ToolRequest{Name: name, Input: in}))
+		}
+
+		return ai.NewModelTextMessage(fmt.Sprintf("unknown tool: %s", name))
+	}
+
+	return ai.NewModelTextMessage("echo: " + text)
+}
+
+func countRequestTokens(req *ai.ModelRequest) int {
+	n := 0
+	for _, m := range req.Messages {
+		n += countTokens(m.Text())
+	}
+
+	return n
+}
+
+func countTokens(s string) int {
+	return len(strings.Fields(s))
+}
+
+// embed creates a bag of words vector, every word is hashed to a dimension, so the similar texts are close to each other
+func embed(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+	resp := &ai.EmbedResponse{Embeddings: make([]*ai.Embedding, 0, len(req.Input))}
+
+	for _, doc := range req.Input {
+		var sb strings.Builder
+		for _, part := range doc.Content {
+			if part.IsText() {
+				sb.WriteString(part.Text)
+				sb.WriteByte(' ')
+			}
+		}
+
+		resp.Embeddings = append(resp.Embeddings, &ai.Embedding{Embedding: Vector(sb.String())})
+	}
+
+	return resp, nil
+}
+
+// Vector returns the normalized hash embedding of the text
+func Vector(text string) []float32 {
+	v := make([]float32, EmbeddingDim)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, w := range words {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(w))
+		v[h.Sum32()%EmbeddingDim]++
+	}
+
+	var norm float64
+	for _, x := range v {
+		norm += float64(x * x)
+	}
+	if norm == 0 {
+		v[0] = 1 // Avoid a zero vector, it can't be normalized
+
+		return v
+	}
+
+	norm = math.Sqrt(norm)
+	for i := range v {
+		v[i] = float32(float64(v[i]) / norm)
+	}
+
+	return v
+}
@@ -0,0 +1,133 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+type addInput struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func TestEchoModel(t *testing.T) {
+	ctx := context.Background()
+	p := New()
+	g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+	model, err := p.DefineModel(g, "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	add := genkit.DefineTool(g, "add", "Add two numbers", func(ctx *ai.ToolContext, in addInput) (int, error) {
+		return in.A + in.B, nil
+	})
+
+	t.Run("echo", func(t *testing.T) {
+		resp, err := genkit.Generate(ctx, g, ai.WithModel(model), ai.WithPrompt("hello there"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "echo: hello there" {
+			t.Errorf("unexpected answer: %q", resp.Text())
+		}
+		if resp.Usage.InputTokens != 2 || resp.Usage.OutputTokens != 3 {
+			t.Errorf("unexpected usage: %+v", resp.Usage)
+		}
+	})
+
+	t.Run("tool call", func(t *testing.T) {
+		resp, err := genkit.Generate(ctx, g, ai.WithModel(model), ai.WithTools(add), ai.WithPrompt(`/call add {"a": 2, "b": 3}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "add returned 5" {
+			t.Errorf("unexpected answer: %q", resp.Text())
+		}
+		// user, tool request, tool response and the final answer
+		if len(resp.History()) != 4 {
+			t.Errorf("expected 4 messages in the history, got %d", len(resp.History()))
+		}
+	})
+
+	t.Run("unknown tool", func(t *testing.T) {
+		resp, err := genkit.Generate(ctx, g, ai.WithModel(model), ai.WithPrompt(`/call missing {}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "unknown tool: missing" {
+			t.Errorf("unexpected answer: %q", resp.Text())
+		}
+	})
+}
+
+func TestScriptedModel(t *testing.T) {
+	ctx := context.Background()
+	p := New(
+		Step{Err: errors.New("Error 503, Message: overloaded")},
+		Step{ToolRequest: &ai.ToolRequest{Name: "upper", Input: map[string]any{"text": "abc"}}},
+		Step{Text: "done"},
+	)
+	g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+	model, err := p.DefineModel(g, "scripted", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper := genkit.DefineTool(g, "upper", "Uppercase the text", func(ctx *ai.ToolContext, in struct {
+		Text string `json:"text"`
+	}) (string, error) {
+		return strings.ToUpper(in.Text), nil
+	})
+
+	if _, err := genkit.Generate(ctx, g, ai.WithModel(model), ai.WithPrompt("hi")); err == nil {
+		t.Fatal("expected the scripted error")
+	}
+
+	resp, err := genkit.Generate(ctx, g, ai.WithModel(model), ai.WithTools(upper), ai.WithPrompt("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "done" {
+		t.Errorf("unexpected answer: %q", resp.Text())
+	}
+
+	// The script is over, back to echo
+	resp, err = genkit.Generate(ctx, g, ai.WithModel(model), ai.WithPrompt("again"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text() != "echo: again" {
+		t.Errorf("unexpected answer: %q", resp.Text())
+	}
+}
+
+func cosine(a, b []float32) float32 {
+	var dot float32
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return dot
+}
+
+func TestVector(t *testing.T) {
+	a := Vector("The cat sits on the mat")
+	b := Vector("the CAT sits on the mat!")
+	c := Vector("Quarterly revenue report")
+
+	if sim := cosine(a, b); sim < 0.999 {
+		t.Errorf("expected the same vector for the same words, similarity: %f", sim)
+	}
+	if cosine(a, c) >= cosine(a, Vector("a cat on a mat")) {
+		t.Error("expected a similar text to be closer than an unrelated one")
+	}
+	if sim := cosine(Vector(""), Vector("")); sim < 0.999 {
+		t.Errorf("expected a normalized vector for an empty text, got %f", sim)
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"google.golang.org/genai"
 )
 
+// DefaultModel is used when no model name is configured
+const DefaultModel = "gemini-flash-latest"
+
 // AgentConfigurator .
 type AgentConfigurator interface {
 	api.Plugin
@@ -22,15 +25,21 @@ type modelEmbedder interface {
 	DefineEmbedder(g *genkit.Genkit, name string, embedOpts *ai.EmbedderOptions) (ai.Embedder, error)
 }
 
+// ConfigPlugin .
 func ConfigPlugin(apiKey string) AgentConfigurator {
 	return &googlegenai.GoogleAI{APIKey: apiKey}
 }
 
+// ConfigVertexPlugin uses the GOOGLE_CLOUD_PROJECT and GOOGLE_CLOUD_LOCATION environment variables if the arguments are empty
+func ConfigVertexPlugin(projectID, location string) AgentConfigurator {
+	return &googlegenai.VertexAI{ProjectID: projectID, Location: location}
+}
+
 // ConfigModel .
 func ConfigModel(g *genkit.Genkit, ga modelDefiner, modelName string) (ai.Model, error) {
 	geminiModelOptions := (*ai.ModelOptions)(nil)
-	if modelName == "" {
-		modelName = "gemini-flash-latest" // Always use the latest flash model by default
+	if modelName == "" || modelName == DefaultModel {
+		modelName = DefaultModel // Always use the latest flash model by default
 		geminiModelOptions = &ai.ModelOptions{
 			Label:    "Gemini Flash Latest",
 			Versions: []string{},
@@ -61,13 +70,14 @@ func ConfigEmbedder(g *genkit.Genkit, ga modelEmbedder, modelName string) (ai.Em
 	return embedder, nil
 }
 
-// CustomConfig .
-func CustomConfig(searchEnable bool) any {
-	geminiSpecConfig := &genai.GenerateContentConfig{
-		ThinkingConfig: &genai.ThinkingConfig{
+// CustomConfig returns the genai config, an empty thinking level keeps the model default
+func CustomConfig(searchEnable bool, thinkingLevel genai.ThinkingLevel) any {
+	geminiSpecConfig := &genai.GenerateContentConfig{}
+	if thinkingLevel != "" {
+		geminiSpecConfig.ThinkingConfig = &genai.ThinkingConfig{
 			// ThinkingBudget: genai.Ptr[int32](0),
-			ThinkingLevel: genai.ThinkingLevelMinimal, // This is for the Gemini 3, Pro doesn't support it, just flash: https://ai.google.dev/gemini-api/docs/thinking#thinking-levels
-		},
+			ThinkingLevel: thinkingLevel, // This is for the Gemini 3, Pro doesn't support it, just flash: https://ai.google.dev/gemini-api/docs/thinking#thinking-levels
+		}
 	}
 	// If the search is enabled, add this as a custom config, it is GEMINI ONLY!
 	if searchEnable {
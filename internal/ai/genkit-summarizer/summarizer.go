@@ -10,21 +10,28 @@ import (
 )
 
 type summarizer struct {
-	g     *genkit.Genkit
-	model ai.Model
+	g           *genkit.Genkit
+	model       ai.Model
+	middlewares []ai.ModelMiddleware
 }
 
 // New returns a history.Summarizer backed by the given genkit model.
-func New(g *genkit.Genkit, model ai.Model) history.Summarizer {
-	return &summarizer{g: g, model: model}
+// The optional middlewares are applied to every model call, e.g. the usage recording.
+func New(g *genkit.Genkit, model ai.Model, middlewares ...ai.ModelMiddleware) history.Summarizer {
+	return &summarizer{g: g, model: model, middlewares: middlewares}
 }
 
 func (s *summarizer) Summarize(ctx context.Context, systemPrompt, text string) (string, error) {
-	resp, err := genkit.Generate(ctx, s.g,
+	opts := []ai.GenerateOption{
 		ai.WithModel(s.model),
 		ai.WithSystem(systemPrompt),
 		ai.WithMessages(ai.NewUserTextMessage(text)),
-	)
+	}
+	if len(s.middlewares) > 0 {
+		opts = append(opts, ai.WithMiddleware(s.middlewares...))
+	}
+
+	resp, err := genkit.Generate(ctx, s.g, opts...)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/ollama"
+)
+
+// ollamaPlugin adapts the Ollama plugin to the common model and embedder definitions
+type ollamaPlugin struct {
+	*ollama.Ollama
+}
+
+func newOllamaPlugin(serverAddress string) *ollamaPlugin {
+	return &ollamaPlugin{Ollama: &ollama.Ollama{ServerAddress: serverAddress, Timeout: 300}}
+}
+
+// DefineModel defines a chat model, the tool support is not limited to the plugin's own model list, since newer models aren't there
+func (o *ollamaPlugin) DefineModel(g *genkit.Genkit, name string, opts *ai.ModelOptions) (ai.Model, error) {
+	if opts == nil {
+		opts = &ai.ModelOptions{
+			Label: name,
+			Supports: &ai.ModelSupports{
+				Multiturn:  true,
+				SystemRole: true,
+				Tools:      true,
+			},
+		}
+	}
+
+	return o.Ollama.DefineModel(g, ollama.ModelDefinition{Name: name, Type: "chat"}, opts), nil
+}
+
+// DefineEmbedder .
+func (o *ollamaPlugin) DefineEmbedder(g *genkit.Genkit, name string, embedOpts *ai.EmbedderOptions) (ai.Embedder, error) {
+	return o.Ollama.DefineEmbedder(g, o.ServerAddress, name, embedOpts), nil
+}
@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/compat_oai"
+)
+
+// openAIPlugin adapts the OpenAI-compatible plugin to the common model and embedder definitions
+type openAIPlugin struct {
+	*compat_oai.OpenAICompatible
+}
+
+func newOpenAIPlugin(apiKey, baseURL string) *openAIPlugin {
+	return &openAIPlugin{OpenAICompatible: &compat_oai.OpenAICompatible{
+		Provider: OpenAI,
+		APIKey:   apiKey,
+		BaseURL:  baseURL,
+	}}
+}
+
+// DefineModel defines and registers the model, the compatible plugin only creates it
+func (o *openAIPlugin) DefineModel(g *genkit.Genkit, name string, opts *ai.ModelOptions) (ai.Model, error) {
+	if opts == nil {
+		opts = &ai.ModelOptions{
+			Label:    "OpenAI compatible - " + name,
+			Supports: &compat_oai.Multimodal,
+			Versions: []string{},
+		}
+	}
+
+	m := o.OpenAICompatible.DefineModel(OpenAI, name, *opts)
+	genkit.RegisterAction(g, m.(api.Registerable))
+
+	return m, nil
+}
+
+// DefineEmbedder defines and registers the embedder
+func (o *openAIPlugin) DefineEmbedder(g *genkit.Genkit, name string, embedOpts *ai.EmbedderOptions) (ai.Embedder, error) {
+	e := o.OpenAICompatible.DefineEmbedder(OpenAI, name, embedOpts)
+	genkit.RegisterAction(g, e.(api.Registerable))
+
+	return e, nil
+}
@@ -0,0 +1,131 @@
+// Package provider selects the AI backend and builds its plugin, models, embedder and model config
+package provider
+
+import (
+	"errors"
+	"fmt"
+
+	"hairy-botter/internal/ai/fake"
+	"hairy-botter/internal/ai/gemini"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/firebase/genkit/go/genkit"
+	"google.golang.org/genai"
+)
+
+// Supported providers
+const (
+	Gemini = "gemini"
+	Vertex = "vertex"
+	Ollama = "ollama"
+	OpenAI = "openai" // Any OpenAI-compatible API
+	Fake   = "fake"
+)
+
+// Config .
+type Config struct {
+	Provider       string // Empty means Gemini
+	APIKey         string // Gemini or OpenAI-compatible API key
+	BaseURL        string // Ollama server address or OpenAI-compatible base URL
+	EmbeddingModel string // Empty means the provider default
+	SearchEnable   bool   // Ignored by the providers without search grounding
+}
+
+type configurator interface {
+	api.Plugin
+	DefineModel(g *genkit.Genkit, name string, opts *ai.ModelOptions) (ai.Model, error)
+	DefineEmbedder(g *genkit.Genkit, name string, embedOpts *ai.EmbedderOptions) (ai.Embedder, error)
+}
+
+// Provider .
+type Provider struct {
+	name           string
+	plugin         configurator
+	defaultModel   string
+	embeddingModel string
+	customConfig   any
+}
+
+// New .
+func New(cfg Config) (*Provider, error) {
+	p := &Provider{name: cfg.Provider}
+
+	switch cfg.Provider {
+	case "", Gemini:
+		if cfg.APIKey == "" {
+			return nil, errors.New("API key is required for Gemini")
+		}
+		p.name = Gemini
+		p.plugin = gemini.ConfigPlugin(cfg.APIKey)
+		p.defaultModel = gemini.DefaultModel
+		p.embeddingModel = "gemini-embedding-001"
+		p.customConfig = gemini.CustomConfig(cfg.SearchEnable, genai.ThinkingLevelMinimal)
+	case Vertex:
+		p.plugin = gemini.ConfigVertexPlugin("", "")
+		p.defaultModel = "gemini-2.5-flash"
+		p.embeddingModel = "gemini-embedding-001"
+		p.customConfig = gemini.CustomConfig(cfg.SearchEnable, "")
+	case Ollama:
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = "http://localhost:11434"
+		}
+		p.plugin = newOllamaPlugin(cfg.BaseURL)
+		p.defaultModel = "llama3.2"
+		p.embeddingModel = "nomic-embed-text"
+	case OpenAI:
+		p.plugin = newOpenAIPlugin(cfg.APIKey, cfg.BaseURL)
+		p.defaultModel = "gpt-4o-mini"
+		p.embeddingModel = "text-embedding-3-small"
+	case Fake:
+		p.plugin = fake.New()
+		p.defaultModel = "echo"
+		p.embeddingModel = "hash"
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", cfg.Provider)
+	}
+
+	if cfg.EmbeddingModel != "" {
+		p.embeddingModel = cfg.EmbeddingModel
+	}
+
+	return p, nil
+}
+
+// Name .
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// Plugin should be passed to the genkit.Init
+func (p *Provider) Plugin() api.Plugin {
+	return p.plugin
+}
+
+// DefaultModel is used when no model name is configured
+func (p *Provider) DefaultModel() string {
+	return p.defaultModel
+}
+
+// CustomConfig returns the provider specific model config, nil if there is none
+func (p *Provider) CustomConfig() any {
+	return p.customConfig
+}
+
+// Model defines the named model, the empty name means the default model
+func (p *Provider) Model(g *genkit.Genkit, name string) (ai.Model, error) {
+	if name == "" {
+		name = p.defaultModel
+	}
+
+	if p.name == Gemini {
+		return gemini.ConfigModel(g, p.plugin, name)
+	}
+
+	return p.plugin.DefineModel(g, name, nil)
+}
+
+// Embedder defines the configured embedder
+func (p *Provider) Embedder(g *genkit.Genkit) (ai.Embedder, error) {
+	return p.plugin.DefineEmbedder(g, p.embeddingModel, &ai.EmbedderOptions{})
+}
@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"google.golang.org/genai"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          Config
+		wantErr      bool
+		wantProvider string
+		wantModel    string
+		wantSearch   bool
+	}{
+		{name: "gemini by default", cfg: Config{APIKey: "key", SearchEnable: true}, wantProvider: Gemini, wantModel: "gemini-flash-latest", wantSearch: true},
+		{name: "gemini without key", cfg: Config{Provider: Gemini}, wantErr: true},
+		{name: "gemini search disabled", cfg: Config{Provider: Gemini, APIKey: "key"}, wantProvider: Gemini, wantModel: "gemini-flash-latest"},
+		{name: "vertex", cfg: Config{Provider: Vertex, SearchEnable: true}, wantProvider: Vertex, wantModel: "gemini-2.5-flash", wantSearch: true},
+		{name: "ollama", cfg: Config{Provider: Ollama, SearchEnable: true}, wantProvider: Ollama, wantModel: "llama3.2"},
+		{name: "openai", cfg: Config{Provider: OpenAI, BaseURL: "http://localhost:1234/v1", SearchEnable: true}, wantProvider: OpenAI, wantModel: "gpt-4o-mini"},
+		{name: "fake", cfg: Config{Provider: Fake}, wantProvider: Fake, wantModel: "echo"},
+		{name: "unknown", cfg: Config{Provider: "other"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := New(tc.cfg)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Name() != tc.wantProvider || p.DefaultModel() != tc.wantModel {
+				t.Errorf("unexpected provider: %s, model: %s", p.Name(), p.DefaultModel())
+			}
+
+			search := false
+			if c, ok := p.CustomConfig().(*genai.GenerateContentConfig); ok {
+				search = len(c.Tools) > 0
+			}
+			if search != tc.wantSearch {
+				t.Errorf("expected search %v, got %v", tc.wantSearch, search)
+			}
+		})
+	}
+}
+
+func TestDefine(t *testing.T) {
+	ctx := context.Background()
+
+	for _, cfg := range []Config{
+		{Provider: Ollama, BaseURL: "http://localhost:11434"},
+		{Provider: OpenAI, APIKey: "key", BaseURL: "http://localhost:1234/v1"},
+		{Provider: Fake},
+	} {
+		t.Run(cfg.Provider, func(t *testing.T) {
+			p, err := New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := genkit.Init(ctx, genkit.WithPlugins(p.Plugin()))
+
+			m, err := p.Model(g, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Name() != cfg.Provider+"/"+p.DefaultModel() {
+				t.Errorf("unexpected model name: %s", m.Name())
+			}
+			if genkit.LookupModel(g, m.Name()) == nil {
+				t.Errorf("model %s is not registered", m.Name())
+			}
+
+			if _, err := p.Model(g, "other-model"); err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := p.Embedder(g)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if genkit.LookupEmbedder(g, e.Name()) == nil {
+				t.Errorf("embedder %s is not registered", e.Name())
+			}
+		})
+	}
+
+	t.Run("fake answers", func(t *testing.T) {
+		p, _ := New(Config{Provider: Fake})
+		g := genkit.Init(ctx, genkit.WithPlugins(p.Plugin()))
+		m, err := p.Model(g, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := genkit.Generate(ctx, g, ai.WithModel(m), ai.WithPrompt("hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text() != "echo: hi" {
+			t.Errorf("unexpected answer: %q", resp.Text())
+		}
+	})
+}
@@ -0,0 +1,292 @@
+// Package delivery pushes the messages of the server to the users through the callback endpoints of the channel adapters
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"hairy-botter/internal/publicnet"
+)
+
+const fileName = "endpoints.json"
+
+// ErrNoEndpoint is returned if no adapter is registered for the session
+var ErrNoEndpoint = errors.New("no endpoint registered for the session")
+
+// ErrRejected is returned if the callback refused the message with a 4xx status, e.g. the adapter doesn't know the chat, the retries wouldn't help
+var ErrRejected = errors.New("the callback rejected the message")
+
+// Config .
+type Config struct {
+	Attempts  int           // Delivery attempts, 0 means 3
+	BaseDelay time.Duration // Backoff before the first retry, doubled for every next one, 0 means 1 second
+	Client    *http.Client  // nil means a client with 30 seconds timeout
+
+	// AllowedHosts are the host:port pairs of the callback URLs which could be private, e.g. the internal services
+	// The other ad-hoc callback URLs could only reach public addresses, the registered endpoints are always allowed.
+	AllowedHosts []string
+	Public       *http.Client // Client of the ad-hoc callback URLs, nil means a public only client with 30 seconds timeout
+}
+
+// Endpoint is the callback URL of a channel adapter for the sessions starting with the prefix
+type Endpoint struct {
+	Prefix       string    `json:"prefix"`
+	URL          string    `json:"url"`
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// Logic .
+type Logic struct {
+	logger *slog.Logger
+	path   string
+	cfg    Config
+
+	mu        sync.Mutex
+	endpoints []Endpoint
+}
+
+// New loads the registered endpoints
+func New(logger *slog.Logger, deliveryPath string, cfg Config) (*Logic, error) {
+	if err := os.MkdirAll(deliveryPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create delivery directory: %w", err)
+	}
+	if cfg.Attempts < 1 {
+		cfg.Attempts = 3
+	}
+	if cfg.BaseDelay == 0 {
+		cfg.BaseDelay = time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	if cfg.Public == nil {
+		cfg.Public = publicnet.NewClient(30 * time.Second)
+	}
+
+	l := &Logic{
+		logger: logger,
+		path:   filepath.Join(deliveryPath, fileName),
+		cfg:    cfg,
+	}
+
+	b, err := os.ReadFile(l.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &l.endpoints); err != nil {
+			return nil, fmt.Errorf("failed to parse endpoints: %w", err)
+		}
+	}
+
+	return l, nil
+}
+
+// save must be called with the lock held
+func (l *Logic) save() error {
+	b, err := json.MarshalIndent(l.endpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(l.path, b, 0644)
+}
+
+// Register adds or replaces the endpoint of the prefix
+func (l *Logic) Register(ctx context.Context, prefix, callbackURL string) (Endpoint, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return Endpoint{}, errors.New("missing prefix")
+	}
+	if u, err := url.Parse(callbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Endpoint{}, fmt.Errorf("invalid callback URL: %s", callbackURL)
+	}
+
+	e := Endpoint{Prefix: prefix, URL: callbackURL, RegisteredAt: time.Now()}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.endpoints = slices.DeleteFunc(l.endpoints, func(existing Endpoint) bool { return existing.Prefix == prefix })
+	l.endpoints = append(l.endpoints, e)
+	l.logger.Info("delivery endpoint registered", slog.String("prefix", prefix), slog.String("url", callbackURL))
+
+	return e, l.save()
+}
+
+// Unregister removes the endpoint of the prefix
+func (l *Logic) Unregister(ctx context.Context, prefix string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := len(l.endpoints)
+	l.endpoints = slices.DeleteFunc(l.endpoints, func(e Endpoint) bool { return e.Prefix == prefix })
+	if len(l.endpoints) == n {
+		return ErrNoEndpoint
+	}
+
+	return l.save()
+}
+
+// Endpoints returns the registered endpoints ordered by prefix
+func (l *Logic) Endpoints(ctx context.Context) ([]Endpoint, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	endpoints := slices.Clone(l.endpoints)
+	slices.SortFunc(endpoints, func(a, b Endpoint) int { return strings.Compare(a.Prefix, b.Prefix) })
+
+	return endpoints, nil
+}
+
+// resolve returns the endpoint with the longest matching prefix
+func (l *Logic) resolve(sessionID string) (Endpoint, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var found Endpoint
+	for _, e := range l.endpoints {
+		if strings.HasPrefix(sessionID, e.Prefix) && len(e.Prefix) > len(found.Prefix) {
+			found = e
+		}
+	}
+
+	return found, found.Prefix != ""
+}
+
+// CanDeliver reports whether there is an endpoint for the session
+func (l *Logic) CanDeliver(sessionID string) bool {
+	_, ok := l.resolve(sessionID)
+
+	return ok
+}
+
+// Deliver sends the text to the session through the endpoint of its adapter
+func (l *Logic) Deliver(ctx context.Context, sessionID, text string) error {
+	e, ok := l.resolve(sessionID)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoEndpoint, sessionID)
+	}
+
+	return l.DeliverTo(ctx, e.URL, sessionID, text)
+}
+
+// trusted reports whether the URL points to a registered endpoint or an allowed host, these could be private
+func (l *Logic) trusted(callbackURL string) bool {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return false
+	}
+	if slices.Contains(l.cfg.AllowedHosts, u.Host) {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return slices.ContainsFunc(l.endpoints, func(e Endpoint) bool {
+		registered, err := url.Parse(e.URL)
+		return err == nil && registered.Host == u.Host
+	})
+}
+
+// CheckCallback validates an ad-hoc callback URL before it is stored, only the trusted ones could point to private addresses
+func (l *Logic) CheckCallback(ctx context.Context, callbackURL string) error {
+	if u, err := url.Parse(callbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback URL: %s", callbackURL)
+	}
+	if l.trusted(callbackURL) {
+		return nil
+	}
+
+	return publicnet.CheckURL(ctx, callbackURL)
+}
+
+// DeliverTo posts the text and the sessionID as a form to the URL, the network errors and the 429 and 5xx statuses are retried with backoff
+// The URLs which are not registered or allowed could only reach public addresses.
+func (l *Logic) DeliverTo(ctx context.Context, callbackURL, sessionID, text string) error {
+	form := url.Values{
+		"payload":   {text},
+		"sessionId": {sessionID},
+	}
+
+	return l.send(ctx, callbackURL, "application/x-www-form-urlencoded", []byte(form.Encode()))
+}
+
+// PostJSON posts the value as JSON to the URL with the same retries as the deliveries
+func (l *Logic) PostJSON(ctx context.Context, callbackURL string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return l.send(ctx, callbackURL, "application/json", b)
+}
+
+func (l *Logic) send(ctx context.Context, callbackURL, contentType string, body []byte) error {
+	delay := l.cfg.BaseDelay
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = l.post(ctx, callbackURL, contentType, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= l.cfg.Attempts {
+			break
+		}
+
+		l.logger.Warn("delivery failed, retrying", slog.String("url", callbackURL), slog.Int("attempt", attempt), slog.String("err", err.Error()))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	return fmt.Errorf("delivery failed: %w", err)
+}
+
+// post sends a single request and reports whether the error could be retried
+func (l *Logic) post(ctx context.Context, callbackURL, contentType string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	client := l.cfg.Public
+	if l.trusted(callbackURL) {
+		client = l.cfg.Client
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil && !errors.Is(err, publicnet.ErrNotPublic), err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return true, fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("%w: status %d", ErrRejected, resp.StatusCode)
+	}
+
+	return false, nil
+}
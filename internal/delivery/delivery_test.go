@@ -0,0 +1,114 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hairy-botter/internal/publicnet"
+)
+
+func TestDeliver(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dir := t.TempDir()
+
+	var calls atomic.Int32
+	var got atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/bad":
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got.Store(r.URL.Path + " " + r.FormValue("sessionId") + ": " + r.FormValue("payload"))
+	}))
+	defer srv.Close()
+
+	cfg := Config{BaseDelay: time.Millisecond}
+	l, err := New(logger, dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Register(ctx, "tg-", srv.URL+"/telegram"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Register(ctx, "tg-group-", srv.URL+"/flaky"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Register(ctx, "fb-", "not a url"); err == nil {
+		t.Error("expected error for an invalid URL")
+	}
+
+	// The endpoints are persisted
+	l, err = New(logger, dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Deliver(ctx, "tg-123", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Load() != "/telegram tg-123: hello" {
+		t.Errorf("unexpected delivery: %v", got.Load())
+	}
+
+	// The longest prefix wins and the 5xx errors are retried
+	if err := l.Deliver(ctx, "tg-group-1", "hi all"); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 || got.Load() != "/flaky tg-group-1: hi all" {
+		t.Errorf("unexpected delivery after %d calls: %v", calls.Load(), got.Load())
+	}
+
+	calls.Store(0)
+	if err := l.DeliverTo(ctx, srv.URL+"/bad", "tg-1", "hi"); !errors.Is(err, ErrRejected) || calls.Load() != 1 {
+		t.Errorf("expected a single failed attempt, got %d calls and %v", calls.Load(), err)
+	}
+
+	// The ad-hoc URLs could only reach public addresses, unless they are allowed
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls.Add(1) }))
+	defer other.Close()
+	calls.Store(0)
+	if err := l.CheckCallback(ctx, other.URL); !errors.Is(err, publicnet.ErrNotPublic) {
+		t.Errorf("expected ErrNotPublic, got %v", err)
+	}
+	if err := l.DeliverTo(ctx, other.URL, "tg-1", "hi"); !errors.Is(err, publicnet.ErrNotPublic) || calls.Load() != 0 {
+		t.Errorf("expected a refused delivery, got %d calls and %v", calls.Load(), err)
+	}
+	if err := l.CheckCallback(ctx, srv.URL+"/other"); err != nil {
+		t.Errorf("expected the host of a registered endpoint to be allowed, got %v", err)
+	}
+
+	allowed, err := New(logger, dir, Config{BaseDelay: time.Millisecond, AllowedHosts: []string{strings.TrimPrefix(other.URL, "http://")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := allowed.DeliverTo(ctx, other.URL, "tg-1", "hi"); err != nil || calls.Load() != 1 {
+		t.Errorf("expected the allowed host to be reached, got %d calls and %v", calls.Load(), err)
+	}
+
+	if err := l.Deliver(ctx, "web-1", "hi"); !errors.Is(err, ErrNoEndpoint) {
+		t.Errorf("expected ErrNoEndpoint, got %v", err)
+	}
+
+	if err := l.Unregister(ctx, "tg-"); err != nil {
+		t.Fatal(err)
+	}
+	if l.CanDeliver("tg-123") {
+		t.Error("expected no endpoint after unregister")
+	}
+}
@@ -9,7 +9,10 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/firebase/genkit/go/ai"
 )
@@ -26,11 +29,22 @@ type Config struct {
 	Summarizer     Summarizer
 }
 
+// ErrNotFound is returned for unknown message IDs
+var ErrNotFound = errors.New("message not found")
+
+// ErrExists is returned if the target session of a fork already has history
+var ErrExists = errors.New("session already exists")
+
+// ErrConflict is returned if the message which the saved history continues was removed in the meantime, e.g. by a summary
+var ErrConflict = errors.New("the history changed since it was read")
+
 // Logic .
 type Logic struct {
 	logger      *slog.Logger
 	historyPath string
 	config      Config
+
+	mu sync.Mutex // The saves are read-modify-write of the message tree
 }
 
 // New .
@@ -42,22 +56,97 @@ func New(logger *slog.Logger, historyPath string, config Config) *Logic {
 	}
 }
 
+// Node is a message of the conversation tree, the edited and regenerated messages start new branches
+type Node struct {
+	ID        int         `json:"id"`
+	ParentID  int         `json:"parentId,omitempty"` // 0 means the root
+	Message   *ai.Message `json:"message"`
+	CreatedAt time.Time   `json:"createdAt,omitzero"`
+}
+
 type saveFormat struct {
-	History []*ai.Message `json:"history"`
+	History []*ai.Message `json:"history,omitempty"` // Flat history of the older versions, converted to nodes on read
+	Nodes   []*Node       `json:"nodes,omitempty"`
+	Head    int           `json:"head,omitempty"`   // Last message of the active branch
+	LastID  int           `json:"lastId,omitempty"` // The IDs are not reused after a summary, so an old head can't point to a new message
 }
 
-// Read .
-func (l *Logic) Read(ctx context.Context, sessionID string) ([]*ai.Message, error) {
+// branch returns the nodes from the root to the head
+func (f *saveFormat) branch() []*Node {
+	return f.branchOf(f.Head)
+}
+
+// branchOf returns the nodes from the root to the message
+func (f *saveFormat) branchOf(head int) []*Node {
+	byID := make(map[int]*Node, len(f.Nodes))
+	for _, n := range f.Nodes {
+		byID[n.ID] = n
+	}
+
+	var branch []*Node
+	for id := head; id != 0; {
+		n, ok := byID[id]
+		if !ok {
+			break
+		}
+		branch = append(branch, n)
+		id = n.ParentID
+	}
+	slices.Reverse(branch)
+
+	return branch
+}
+
+func (f *saveFormat) has(id int) bool {
+	return slices.ContainsFunc(f.Nodes, func(n *Node) bool { return n.ID == id })
+}
+
+// lastID returns the biggest ID which was ever used, the older files have no LastID
+func (f *saveFormat) lastID() int {
+	lastID := f.LastID
+	for _, n := range f.Nodes {
+		lastID = max(lastID, n.ID)
+	}
+
+	return lastID
+}
+
+// append adds the messages to the head as a chain
+func (f *saveFormat) append(messages []*ai.Message) {
+	lastID := f.lastID()
+	for _, m := range messages {
+		lastID++
+		f.Nodes = append(f.Nodes, &Node{ID: lastID, ParentID: f.Head, Message: m, CreatedAt: time.Now()})
+		f.Head = lastID
+	}
+	f.LastID = lastID
+}
+
+// withoutSystem drops the system messages, the system prompt is rendered for every request
+func withoutSystem(messages []*ai.Message) []*ai.Message {
+	return slices.DeleteFunc(slices.Clone(messages), func(m *ai.Message) bool { return m.Role == ai.RoleSystem })
+}
+
+func (l *Logic) filePath(sessionID string) (string, error) {
 	trimmedID := strings.TrimSpace(sessionID)
 	if trimmedID == "" || trimmedID == "." ||
 		strings.Contains(trimmedID, "/") || strings.Contains(trimmedID, "\\") || strings.Contains(trimmedID, "..") {
-		return nil, errors.New("invalid sessionID")
+		return "", errors.New("invalid sessionID")
+	}
+
+	return filepath.Join(l.historyPath, filepath.Base(trimmedID)), nil
+}
+
+func (l *Logic) load(sessionID string) (*saveFormat, error) {
+	p, err := l.filePath(sessionID)
+	if err != nil {
+		return nil, err
 	}
 
-	b, err := os.ReadFile(filepath.Join(l.historyPath, filepath.Base(trimmedID)))
+	b, err := os.ReadFile(p)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) { // Not yet exists, ignore
-			return make([]*ai.Message, 0), nil
+			return &saveFormat{}, nil
 		}
 		return nil, err
 	}
@@ -68,38 +157,168 @@ func (l *Logic) Read(ctx context.Context, sessionID string) ([]*ai.Message, erro
 		return nil, err
 	}
 
-	return saved.History, nil
+	if len(saved.History) > 0 && len(saved.Nodes) == 0 {
+		saved.append(withoutSystem(saved.History))
+		saved.History = nil
+	}
+
+	return &saved, nil
 }
 
-// Save .
-func (l *Logic) Save(ctx context.Context, sessionID string, history []*ai.Message) error {
-	if strings.Contains(sessionID, "/") || strings.Contains(sessionID, "\\") || strings.Contains(sessionID, "..") {
-		return errors.New("invalid sessionID")
+func (l *Logic) store(sessionID string, saved *saveFormat) error {
+	p, err := l.filePath(sessionID)
+	if err != nil {
+		return err
 	}
 
-	var b []byte
-	var err error
-	if l.config.HistorySummary > 0 && len(history) >= l.config.HistorySummary {
-		l.logger.Info("summarizing history", slog.String("sessionID", sessionID), slog.Int("historyLength", len(history)))
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
 
-		summary, err := l.summarize(ctx, history)
-		if err != nil {
-			return fmt.Errorf("failed to summarize history: %w", err)
-		}
+	return os.WriteFile(p, b, 0644)
+}
+
+// Read returns the messages of the active branch and the ID of its head, the Save continues from that head
+func (l *Logic) Read(ctx context.Context, sessionID string) ([]*ai.Message, int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	saved, err := l.load(sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	history := make([]*ai.Message, 0)
+	for _, n := range saved.branch() {
+		history = append(history, n.Message)
+	}
 
-		b, err = json.Marshal(saveFormat{History: []*ai.Message{summary}})
+	return history, saved.Head, nil
+}
+
+// Save stores the history which starts with the branch of the head returned by Read, the new messages are added to that head
+// The concurrent turns of the session continue their own head, so they end up in separate branches instead of mixing up.
+// The summary is generated without the lock, if the session changed in the meantime the history is saved as is and summarized by the next turn.
+func (l *Logic) Save(ctx context.Context, sessionID string, head int, history []*ai.Message) error {
+	history = withoutSystem(history)
+
+	var summary *ai.Message
+	lastID := -1
+	if l.config.HistorySummary > 0 && len(history) >= l.config.HistorySummary {
+		l.mu.Lock()
+		saved, err := l.load(sessionID)
+		l.mu.Unlock()
 		if err != nil {
 			return err
 		}
-	} else {
-		l.logger.Info("saving history", slog.String("sessionID", sessionID), slog.Int("historyLength", len(history)))
-		b, err = json.Marshal(saveFormat{History: history})
+		lastID = saved.lastID()
+
+		l.logger.Info("summarizing history", slog.String("sessionID", sessionID), slog.Int("historyLength", len(history)))
+		summary, err = l.summarize(ctx, history)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to summarize history: %w", err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	saved, err := l.load(sessionID)
+	if err != nil {
+		return err
+	}
+	if head != 0 && !saved.has(head) {
+		return fmt.Errorf("%w: message %d not found", ErrConflict, head)
+	}
+
+	if summary != nil && saved.lastID() == lastID {
+		// The branches are dropped with the summarized messages
+		saved = &saveFormat{LastID: lastID}
+		saved.append([]*ai.Message{summary})
+
+		return l.store(sessionID, saved)
+	}
+
+	l.logger.Info("saving history", slog.String("sessionID", sessionID), slog.Int("historyLength", len(history)))
+	branch := saved.branchOf(head)
+	saved.Head = head
+	if len(history) < len(branch) { // Not a continuation of the branch, start a new one
+		saved.Head = 0
+		branch = nil
+	}
+	saved.append(history[len(branch):])
+
+	return l.store(sessionID, saved)
+}
+
+// Branch returns the nodes of the active branch
+func (l *Logic) Branch(ctx context.Context, sessionID string) ([]Node, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	saved, err := l.load(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0)
+	for _, n := range saved.branch() {
+		nodes = append(nodes, *n)
+	}
+
+	return nodes, nil
+}
+
+// Checkout makes the message the head of the active branch, the next messages continue from there, 0 means the root
+func (l *Logic) Checkout(ctx context.Context, sessionID string, messageID int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	saved, err := l.load(sessionID)
+	if err != nil {
+		return err
+	}
+	if messageID != 0 && !saved.has(messageID) {
+		return ErrNotFound
+	}
+	saved.Head = messageID
+
+	return l.store(sessionID, saved)
+}
+
+// Fork copies the active branch until the message into a new session, 0 means the whole branch
+func (l *Logic) Fork(ctx context.Context, sessionID, newSessionID string, messageID int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	saved, err := l.load(sessionID)
+	if err != nil {
+		return err
+	}
+	target, err := l.load(newSessionID)
+	if err != nil {
+		return err
+	}
+	if len(target.Nodes) > 0 {
+		return ErrExists
+	}
+
+	branch := saved.branch()
+	if messageID != 0 {
+		i := slices.IndexFunc(branch, func(n *Node) bool { return n.ID == messageID })
+		if i < 0 {
+			return ErrNotFound
 		}
+		branch = branch[:i+1]
+	}
+
+	forked := &saveFormat{Nodes: branch}
+	if len(branch) > 0 {
+		forked.Head = branch[len(branch)-1].ID
 	}
 
-	return os.WriteFile(filepath.Join(l.historyPath, filepath.Base(sessionID)), b, 0644)
+	return l.store(newSessionID, forked)
 }
 
 func (l *Logic) summarize(ctx context.Context, history []*ai.Message) (*ai.Message, error) {
@@ -2,10 +2,15 @@ package history
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
+
+	"github.com/firebase/genkit/go/ai"
 )
 
 func TestPathTraversalFixed(t *testing.T) {
@@ -34,13 +39,13 @@ func TestPathTraversalFixed(t *testing.T) {
 	ctx := context.Background()
 
 	// Test Read with path traversal attempt
-	_, err = l.Read(ctx, "../secret.txt")
+	_, _, err = l.Read(ctx, "../secret.txt")
 	if err == nil || err.Error() != "invalid sessionID" {
 		t.Errorf("Expected 'invalid sessionID' error, got: %v", err)
 	}
 
 	// Test Save with path traversal attempt
-	err = l.Save(ctx, "../attack.txt", nil)
+	err = l.Save(ctx, "../attack.txt", 0, nil)
 	if err == nil || err.Error() != "invalid sessionID" {
 		t.Errorf("Expected 'invalid sessionID' error, got: %v", err)
 	}
@@ -54,3 +59,198 @@ func TestPathTraversalFixed(t *testing.T) {
 		t.Error("Save() unexpectedly wrote file to history directory")
 	}
 }
+
+func TestBranches(t *testing.T) {
+	ctx := context.Background()
+	l := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), t.TempDir(), Config{})
+
+	first := []*ai.Message{
+		ai.NewSystemTextMessage("system"),
+		ai.NewUserTextMessage("hi"),
+		ai.NewModelTextMessage("hello"),
+	}
+	if err := l.Save(ctx, "s", 0, first); err != nil {
+		t.Fatal(err)
+	}
+	second := append(slices.Clone(first[1:]), ai.NewUserTextMessage("how are you?"), ai.NewModelTextMessage("fine"))
+	if err := l.Save(ctx, "s", 2, second); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := l.Branch(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 4 || nodes[0].Message.Role == ai.RoleSystem || nodes[3].ID != 4 || nodes[3].ParentID != 3 {
+		t.Fatalf("unexpected branch: %+v", nodes)
+	}
+
+	// Edit the second question, the old answer stays in the other branch
+	if err := l.Checkout(ctx, "s", 2); err != nil {
+		t.Fatal(err)
+	}
+	history, head, err := l.Read(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || head != 2 {
+		t.Fatalf("expected 2 messages after the checkout, got %d with head %d", len(history), head)
+	}
+	if err := l.Save(ctx, "s", head, append(history, ai.NewUserTextMessage("what's up?"), ai.NewModelTextMessage("nothing"))); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err = l.Branch(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 4 || nodes[2].ID != 5 || nodes[2].ParentID != 2 || nodes[3].Message.Text() != "nothing" {
+		t.Fatalf("unexpected branch after the edit: %+v", nodes)
+	}
+
+	// The old branch is still there
+	if err := l.Checkout(ctx, "s", 4); err != nil {
+		t.Fatal(err)
+	}
+	if history, _, _ = l.Read(ctx, "s"); len(history) != 4 || history[3].Text() != "fine" {
+		t.Fatalf("unexpected old branch: %v", history)
+	}
+	if err := l.Checkout(ctx, "s", 42); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := l.Fork(ctx, "s", "forked", 2); err != nil {
+		t.Fatal(err)
+	}
+	if history, _, _ = l.Read(ctx, "forked"); len(history) != 2 || history[1].Text() != "hello" {
+		t.Fatalf("unexpected forked history: %v", history)
+	}
+	if err := l.Fork(ctx, "s", "forked", 0); !errors.Is(err, ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	if err := l.Fork(ctx, "s", "other", 5); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a message of another branch, got %v", err)
+	}
+}
+
+func TestLegacyHistory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	l := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), dir, Config{})
+
+	b, err := json.Marshal(map[string]any{"history": []*ai.Message{
+		ai.NewSystemTextMessage("system"),
+		ai.NewUserTextMessage("hi"),
+		ai.NewModelTextMessage("hello"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := l.Branch(ctx, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].Message.Text() != "hi" || nodes[1].ParentID != nodes[0].ID {
+		t.Fatalf("unexpected converted history: %+v", nodes)
+	}
+}
+
+type staticSummarizer string
+
+func (s staticSummarizer) Summarize(ctx context.Context, systemPrompt, text string) (string, error) {
+	return string(s), nil
+}
+
+func TestConcurrentTurns(t *testing.T) {
+	ctx := context.Background()
+	l := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), t.TempDir(), Config{HistorySummary: 6, Summarizer: staticSummarizer("summary")})
+
+	// Both turns read the empty history, the second one must not continue the first answer
+	_, head, err := l.Read(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(ctx, "s", head, []*ai.Message{ai.NewUserTextMessage("first"), ai.NewModelTextMessage("one")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(ctx, "s", head, []*ai.Message{ai.NewUserTextMessage("second"), ai.NewModelTextMessage("two")}); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := l.Branch(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].Message.Text() != "second" || nodes[0].ParentID != 0 || nodes[1].ID != 4 {
+		t.Fatalf("unexpected branch: %+v", nodes)
+	}
+
+	// The summary keeps the IDs increasing, so the old head is not found anymore
+	history, head, err := l.Read(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := append(history, ai.NewUserTextMessage("a"), ai.NewModelTextMessage("b"), ai.NewUserTextMessage("c"), ai.NewModelTextMessage("d"))
+	if err := l.Save(ctx, "s", head, long); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, _ = l.Branch(ctx, "s"); len(nodes) != 1 || nodes[0].ID != 5 {
+		t.Fatalf("unexpected summarized branch: %+v", nodes)
+	}
+	if err := l.Save(ctx, "s", head, append(history, ai.NewUserTextMessage("late"))); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+}
+
+// blockingSummarizer waits for the release, so the test could save during the summary
+type blockingSummarizer struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockingSummarizer) Summarize(ctx context.Context, systemPrompt, text string) (string, error) {
+	close(s.started)
+	<-s.release
+
+	return "summary", nil
+}
+
+func TestSaveDuringSummary(t *testing.T) {
+	ctx := context.Background()
+	summarizer := blockingSummarizer{started: make(chan struct{}), release: make(chan struct{})}
+	l := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), t.TempDir(), Config{HistorySummary: 4, Summarizer: summarizer})
+
+	long := []*ai.Message{ai.NewUserTextMessage("a"), ai.NewModelTextMessage("b"), ai.NewUserTextMessage("c"), ai.NewModelTextMessage("d")}
+	done := make(chan error, 1)
+	go func() { done <- l.Save(ctx, "s", 0, long) }()
+	<-summarizer.started
+
+	// The other sessions and the other turns of the session are not blocked by the summary
+	if err := l.Save(ctx, "other", 0, []*ai.Message{ai.NewUserTextMessage("hi")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(ctx, "s", 0, []*ai.Message{ai.NewUserTextMessage("parallel"), ai.NewModelTextMessage("answer")}); err != nil {
+		t.Fatal(err)
+	}
+
+	close(summarizer.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// The session changed during the summary, so the history is saved as is and the parallel turn is kept
+	nodes, err := l.Branch(ctx, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 4 || nodes[0].Message.Text() != "a" || nodes[0].ParentID != 0 {
+		t.Fatalf("unexpected branch: %+v", nodes)
+	}
+	if err := l.Checkout(ctx, "s", 2); err != nil {
+		t.Errorf("expected the parallel turn to be kept, got %v", err)
+	}
+}
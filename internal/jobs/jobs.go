@@ -0,0 +1,502 @@
+// Package jobs runs the messages asynchronously on a bounded worker pool, the state of the jobs survives the restarts
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/history"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// States of the jobs
+const (
+	StateQueued   = "queued"
+	StateRunning  = "running"
+	StateDone     = "done"
+	StateFailed   = "failed"
+	StateCanceled = "canceled"
+)
+
+var (
+	// ErrNotFound is returned for unknown job IDs
+	ErrNotFound = errors.New("job not found")
+	// ErrQueueFull is returned if the queue has no more room
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrFinished is returned when a finished job is canceled
+	ErrFinished = errors.New("job is already finished")
+)
+
+type messageHandler interface {
+	HandleMessage(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error)
+}
+
+// notifier is implemented by the delivery.Logic
+type notifier interface {
+	PostJSON(ctx context.Context, callbackURL string, v any) error
+	CheckCallback(ctx context.Context, callbackURL string) error
+	Deliver(ctx context.Context, sessionID, text string) error
+	DeliverTo(ctx context.Context, callbackURL, sessionID, text string) error
+}
+
+// branchReader is implemented by the agent registry, the handlers without it run the interrupted jobs again
+type branchReader interface {
+	Branch(ctx context.Context, sessionID, agent string) ([]history.Node, error)
+}
+
+// Config .
+type Config struct {
+	Workers   int           // Parallel jobs, 0 means 4
+	QueueSize int           // Max number of queued jobs, 0 means 100
+	Retention time.Duration // Finished jobs are deleted after this, 0 means 24 hours
+	Notifier  notifier      // Posts the finished jobs to their callback URL and the answers to their destination, required if the jobs have any
+}
+
+// Result is the answer of a finished job, the fields match the /message response
+type Result struct {
+	Response string   `json:"response"`
+	Data     any      `json:"data,omitempty"`
+	Model    string   `json:"model,omitempty"`
+	Sources  []Source `json:"sources,omitempty"`
+	Tools    []Tool   `json:"tools,omitempty"`
+	Usage    *Usage   `json:"usage,omitempty"`
+}
+
+// Source is a search result or a RAG document used for the answer
+type Source struct {
+	Type       string  `json:"type"`
+	URL        string  `json:"url,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	ID         string  `json:"id,omitempty"`
+	Similarity float32 `json:"similarity,omitempty"`
+}
+
+// Tool is a tool invoked during the answer
+type Tool struct {
+	Name       string `json:"name"`
+	Args       any    `json:"args,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Usage is the token usage of the answer
+type Usage struct {
+	InputTokens    int `json:"inputTokens"`
+	OutputTokens   int `json:"outputTokens"`
+	ThinkingTokens int `json:"thinkingTokens"`
+}
+
+func newResult(resp domain.Response) *Result {
+	res := &Result{Response: resp.Text, Data: resp.Data, Model: resp.Model}
+	for _, s := range resp.Sources {
+		res.Sources = append(res.Sources, Source(s))
+	}
+	for _, t := range resp.Tools {
+		res.Tools = append(res.Tools, Tool{Name: t.Name, Args: t.Args, DurationMs: t.Duration.Milliseconds(), Error: t.Error})
+	}
+	if resp.Model != "" { // No usage without a model call
+		res.Usage = &Usage{
+			InputTokens:    resp.Usage.InputTokens,
+			OutputTokens:   resp.Usage.OutputTokens,
+			ThinkingTokens: resp.Usage.ThinkingTokens,
+		}
+	}
+
+	return res
+}
+
+// Job is an asynchronous message
+type Job struct {
+	ID             string               `json:"id"`
+	State          string               `json:"state"`
+	SessionID      string               `json:"sessionId"`
+	Agent          string               `json:"agent,omitempty"`
+	Channel        string               `json:"channel,omitempty"`
+	Locale         string               `json:"locale,omitempty"`
+	Message        string               `json:"message"`
+	Schema         map[string]any       `json:"schema,omitempty"`
+	CallbackURL    string               `json:"callbackUrl,omitempty"`
+	Files          []*domain.InlineData `json:"files,omitempty"`
+	Destination    string               `json:"destination,omitempty"`    // Session to push the answer to, e.g. by the webhooks
+	DestinationURL string               `json:"destinationUrl,omitempty"` // URL to post the answer to as a form, used if there is no destination session
+	Result         *Result              `json:"result,omitempty"`
+	Error          string               `json:"error,omitempty"`
+	CreatedAt      time.Time            `json:"createdAt"`
+	StartedAt      time.Time            `json:"startedAt,omitzero"`
+	FinishedAt     time.Time            `json:"finishedAt,omitzero"`
+}
+
+func (j Job) finished() bool {
+	return j.State == StateDone || j.State == StateFailed || j.State == StateCanceled
+}
+
+// Logic .
+type Logic struct {
+	logger *slog.Logger
+	path   string
+	cfg    Config
+
+	mu          sync.Mutex
+	jobs        map[string]*Job
+	cancels     map[string]context.CancelFunc // Cancel of the running jobs
+	interrupted map[string]time.Time          // Start of the jobs which were running when the process stopped
+	queue       chan string
+
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// New loads the persisted jobs, the unfinished ones are queued again when the workers start
+func New(logger *slog.Logger, jobsPath string, cfg Config) (*Logic, error) {
+	if err := os.MkdirAll(jobsPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+	if cfg.Workers < 1 {
+		cfg.Workers = 4
+	}
+	if cfg.QueueSize < 1 {
+		cfg.QueueSize = 100
+	}
+	if cfg.Retention == 0 {
+		cfg.Retention = 24 * time.Hour
+	}
+
+	l := &Logic{
+		logger:      logger,
+		path:        jobsPath,
+		cfg:         cfg,
+		jobs:        make(map[string]*Job),
+		cancels:     make(map[string]context.CancelFunc),
+		interrupted: make(map[string]time.Time),
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Logic) load() error {
+	entries, err := os.ReadDir(l.path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(l.path, e.Name()))
+		if err != nil {
+			return err
+		}
+		var j Job
+		if err := json.Unmarshal(b, &j); err != nil {
+			l.logger.Warn("skipping invalid job file", slog.String("file", e.Name()), slog.String("err", err.Error()))
+			continue
+		}
+		if j.State == StateRunning { // Interrupted by the restart, its turn could be saved already
+			j.State = StateQueued
+			l.interrupted[j.ID] = j.StartedAt
+		}
+		l.jobs[j.ID] = &j
+	}
+
+	return nil
+}
+
+// save must be called with the lock held
+func (l *Logic) save(j *Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(l.path, j.ID+".json"), b, 0644)
+}
+
+// Start starts the workers and queues the unfinished jobs, the handler generates the answers
+func (l *Logic) Start(handler messageHandler) {
+	ctx, stop := context.WithCancel(context.Background())
+	l.stop = stop
+
+	l.mu.Lock()
+	pending := make([]*Job, 0)
+	for _, j := range l.jobs {
+		if j.State == StateQueued {
+			pending = append(pending, j)
+		}
+	}
+	// The restored jobs could be more than the queue size
+	l.queue = make(chan string, max(l.cfg.QueueSize, len(pending)))
+	for _, j := range pending {
+		l.queue <- j.ID
+	}
+	l.mu.Unlock()
+
+	for range l.cfg.Workers {
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-l.queue:
+					l.run(ctx, handler, id)
+				}
+			}
+		}()
+	}
+
+	l.logger.Info("job workers started", slog.Int("workers", l.cfg.Workers), slog.Int("restored", len(pending)))
+}
+
+// Close stops the workers, the interrupted jobs are queued again on the next start
+func (l *Logic) Close() {
+	if l.stop != nil {
+		l.stop()
+	}
+	l.wg.Wait()
+}
+
+// Submit validates and queues the job
+func (l *Logic) Submit(ctx context.Context, j Job) (Job, error) {
+	if j.SessionID == "" {
+		return Job{}, errors.New("missing sessionId")
+	}
+	if strings.TrimSpace(j.Message) == "" {
+		return Job{}, errors.New("missing message")
+	}
+	if (j.CallbackURL != "" || j.Destination != "" || j.DestinationURL != "") && l.cfg.Notifier == nil {
+		return Job{}, errors.New("callbacks are not configured")
+	}
+	for _, u := range []string{j.CallbackURL, j.DestinationURL} {
+		if u == "" {
+			continue
+		}
+		if err := l.cfg.Notifier.CheckCallback(ctx, u); err != nil {
+			return Job{}, err
+		}
+	}
+
+	j.ID = strings.ToLower(rand.Text())
+	j.State = StateQueued
+	j.Result = nil
+	j.Error = ""
+	j.CreatedAt = time.Now()
+	j.StartedAt = time.Time{}
+	j.FinishedAt = time.Time{}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune()
+
+	if l.queue == nil || len(l.queue) == cap(l.queue) {
+		return Job{}, ErrQueueFull
+	}
+	if err := l.save(&j); err != nil {
+		return Job{}, err
+	}
+	l.jobs[j.ID] = &j
+	l.queue <- j.ID
+
+	return j, nil
+}
+
+// Get returns the job
+func (l *Logic) Get(ctx context.Context, id string) (Job, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	j, ok := l.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	return *j, nil
+}
+
+// Cancel stops the running job or drops the queued one
+func (l *Logic) Cancel(ctx context.Context, id string) (Job, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	j, ok := l.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	if j.finished() {
+		return *j, ErrFinished
+	}
+
+	if cancel, ok := l.cancels[id]; ok {
+		cancel() // The worker finishes it as canceled
+	}
+	j.State = StateCanceled
+	j.FinishedAt = time.Now()
+
+	return *j, l.save(j)
+}
+
+// prune deletes the finished jobs after the retention, it must be called with the lock held
+func (l *Logic) prune() {
+	for id, j := range l.jobs {
+		if !j.finished() || time.Since(j.FinishedAt) < l.cfg.Retention {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(l.path, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			l.logger.Error("failed to delete job", slog.String("id", id), slog.String("err", err.Error()))
+			continue
+		}
+		delete(l.jobs, id)
+	}
+}
+
+// start marks the job running, false if it was canceled in the queue
+// The start of the previous run is returned if the job was interrupted by a crash.
+func (l *Logic) start(ctx context.Context, id string) (context.Context, Job, time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	interrupted := l.interrupted[id]
+	delete(l.interrupted, id)
+
+	j, ok := l.jobs[id]
+	if !ok || j.State != StateQueued {
+		return nil, Job{}, time.Time{}, false
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	l.cancels[id] = cancel
+	j.State = StateRunning
+	j.StartedAt = time.Now()
+	if err := l.save(j); err != nil {
+		l.logger.Error("failed to save job", slog.String("id", id), slog.String("err", err.Error()))
+	}
+
+	return jobCtx, *j, interrupted, true
+}
+
+// savedAnswer returns the answer of the job from the history, if its turn was saved after the start of the interrupted run
+func savedAnswer(ctx context.Context, reader branchReader, j Job, since time.Time) (domain.Response, bool) {
+	nodes, err := reader.Branch(ctx, j.SessionID, j.Agent)
+	if err != nil {
+		return domain.Response{}, false
+	}
+
+	for i := len(nodes) - 2; i >= 0; i-- {
+		n := nodes[i]
+		if n.CreatedAt.Before(since) {
+			break
+		}
+		if n.Message.Role == ai.RoleUser && n.Message.Text() == j.Message && nodes[i+1].Message.Role == ai.RoleModel {
+			return domain.Response{Text: nodes[i+1].Message.Text()}, true
+		}
+	}
+
+	return domain.Response{}, false
+}
+
+func (l *Logic) run(ctx context.Context, handler messageHandler, id string) {
+	jobCtx, j, interrupted, ok := l.start(ctx, id)
+	if !ok {
+		return
+	}
+
+	var resp domain.Response
+	var err error
+	answered := false
+	if reader, ok := handler.(branchReader); ok && !interrupted.IsZero() {
+		if resp, answered = savedAnswer(jobCtx, reader, j, interrupted); answered {
+			l.logger.Info("the interrupted job was already answered", slog.String("id", id))
+		}
+	}
+	if !answered {
+		resp, err = handler.HandleMessage(jobCtx, j.SessionID, domain.Request{
+			Message:    j.Message,
+			InlineData: j.Files,
+			Channel:    j.Channel,
+			Locale:     j.Locale,
+			Agent:      j.Agent,
+			Schema:     j.Schema,
+		})
+	}
+
+	finished, notify := l.finish(ctx, id, resp, err)
+	if !notify {
+		return
+	}
+	if finished.State == StateFailed {
+		l.logger.Error("job failed", slog.String("id", id), slog.String("sessionID", j.SessionID), slog.String("err", finished.Error))
+	}
+	if j.CallbackURL != "" {
+		if err := l.cfg.Notifier.PostJSON(ctx, j.CallbackURL, finished); err != nil {
+			l.logger.Error("failed to post job callback", slog.String("id", id), slog.String("err", err.Error()))
+		}
+	}
+	if finished.State == StateDone {
+		if err := l.deliver(ctx, j, finished.Result.Response); err != nil {
+			l.logger.Error("failed to deliver job answer", slog.String("id", id), slog.String("err", err.Error()))
+		}
+	}
+}
+
+// deliver sends the answer to the destination of the job, if it has any
+func (l *Logic) deliver(ctx context.Context, j Job, text string) error {
+	switch {
+	case j.Destination != "":
+		return l.cfg.Notifier.Deliver(ctx, j.Destination, text)
+	case j.DestinationURL != "":
+		return l.cfg.Notifier.DeliverTo(ctx, j.DestinationURL, j.SessionID, text)
+	}
+
+	return nil
+}
+
+// finish stores the result, it returns false if the job was interrupted by the shutdown
+func (l *Logic) finish(ctx context.Context, id string, resp domain.Response, runErr error) (Job, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cancels[id]()
+	delete(l.cancels, id)
+
+	j := l.jobs[id]
+	switch {
+	case j.State == StateCanceled: // Canceled while running, the state is already saved
+		return *j, true
+	case ctx.Err() != nil: // Shutdown, it is run again after the restart
+		j.State = StateQueued
+		j.StartedAt = time.Time{}
+	case runErr != nil:
+		j.State = StateFailed
+		j.Error = runErr.Error()
+		j.FinishedAt = time.Now()
+	default:
+		j.State = StateDone
+		j.Result = newResult(resp)
+		j.FinishedAt = time.Now()
+	}
+
+	if err := l.save(j); err != nil {
+		l.logger.Error("failed to save job", slog.String("id", id), slog.String("err", err.Error()))
+	}
+
+	return *j, j.finished()
+}
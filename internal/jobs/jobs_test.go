@@ -0,0 +1,221 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/history"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// blockingHandler answers the messages only when they are released, or fails when the context is canceled
+type blockingHandler struct {
+	started chan string
+	release chan struct{}
+}
+
+func (h *blockingHandler) HandleMessage(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error) {
+	h.started <- req.Message
+	select {
+	case <-ctx.Done():
+		return domain.Response{}, ctx.Err()
+	case <-h.release:
+		return domain.Response{Text: "answer to " + req.Message, Model: "mock-model"}, nil
+	}
+}
+
+type mockNotifier struct {
+	posted chan Job
+}
+
+func (m *mockNotifier) PostJSON(ctx context.Context, callbackURL string, v any) error {
+	m.posted <- v.(Job)
+
+	return nil
+}
+
+func (m *mockNotifier) CheckCallback(ctx context.Context, callbackURL string) error {
+	return nil
+}
+
+func (m *mockNotifier) Deliver(ctx context.Context, sessionID, text string) error {
+	m.posted <- Job{Destination: sessionID, Result: &Result{Response: text}}
+
+	return nil
+}
+
+func (m *mockNotifier) DeliverTo(ctx context.Context, callbackURL, sessionID, text string) error {
+	m.posted <- Job{DestinationURL: callbackURL, Result: &Result{Response: text}}
+
+	return nil
+}
+
+// savedHandler has the turn of the interrupted job in its history
+type savedHandler struct {
+	blockingHandler
+	nodes []history.Node
+}
+
+func (h *savedHandler) Branch(ctx context.Context, sessionID, agent string) ([]history.Node, error) {
+	return h.nodes, nil
+}
+
+func waitState(t *testing.T, l *Logic, id, state string) Job {
+	t.Helper()
+
+	for range 200 {
+		j, err := l.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.State == state {
+			return j
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not reach the %s state", id, state)
+
+	return Job{}
+}
+
+func TestJobs(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dir := t.TempDir()
+
+	notifier := &mockNotifier{posted: make(chan Job, 10)}
+	cfg := Config{Workers: 1, QueueSize: 2, Notifier: notifier}
+	l, err := New(logger, dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &blockingHandler{started: make(chan string, 10), release: make(chan struct{})}
+	l.Start(handler)
+
+	first, err := l.Submit(ctx, Job{SessionID: "s1", Message: "first", CallbackURL: "http://example.com/done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-handler.started
+
+	// The single worker is busy, so the next ones are queued
+	second, err := l.Submit(ctx, Job{SessionID: "s1", Message: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := l.Submit(ctx, Job{SessionID: "s2", Message: "third"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Submit(ctx, Job{SessionID: "s3", Message: "fourth"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+
+	if _, err := l.Cancel(ctx, second.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.release <- struct{}{}
+	done := waitState(t, l, first.ID, StateDone)
+	if done.Result == nil || done.Result.Response != "answer to first" {
+		t.Errorf("unexpected result: %+v", done.Result)
+	}
+	if posted := <-notifier.posted; posted.ID != first.ID || posted.State != StateDone {
+		t.Errorf("unexpected callback: %+v", posted)
+	}
+
+	// The canceled job is skipped, the third one is interrupted by the shutdown
+	if msg := <-handler.started; msg != "third" {
+		t.Fatalf("expected the third job to run, got %s", msg)
+	}
+	if _, err := l.Cancel(ctx, first.ID); !errors.Is(err, ErrFinished) {
+		t.Errorf("expected ErrFinished, got %v", err)
+	}
+	l.Close()
+
+	// The state survives the restart and the interrupted job runs again
+	l, err = New(logger, dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := l.Get(ctx, second.ID); j.State != StateCanceled {
+		t.Errorf("expected the canceled state, got %s", j.State)
+	}
+	if j, _ := l.Get(ctx, third.ID); j.State != StateQueued {
+		t.Errorf("expected the queued state, got %s", j.State)
+	}
+
+	l.Start(handler)
+	defer l.Close()
+	<-handler.started
+	if _, err := l.Cancel(ctx, third.ID); err != nil {
+		t.Fatal(err)
+	}
+	if j := waitState(t, l, third.ID, StateCanceled); j.Result != nil {
+		t.Errorf("expected no result for a canceled job: %+v", j)
+	}
+
+	// The answer is pushed to the destination
+	pushed, err := l.Submit(ctx, Job{SessionID: "s4", Message: "pushed", Destination: "tg-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-handler.started
+	handler.release <- struct{}{}
+	waitState(t, l, pushed.ID, StateDone)
+	if posted := <-notifier.posted; posted.Destination != "tg-1" || posted.Result.Response != "answer to pushed" {
+		t.Errorf("unexpected delivery: %+v", posted)
+	}
+}
+
+func TestInterruptedJob(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dir := t.TempDir()
+
+	// The process stopped while the jobs were running, the first one was saved to the history already
+	startedAt := time.Now().Add(-time.Minute)
+	for _, j := range []Job{
+		{ID: "saved", State: StateRunning, SessionID: "s1", Message: "first", StartedAt: startedAt},
+		{ID: "lost", State: StateRunning, SessionID: "s1", Message: "second", StartedAt: startedAt},
+	} {
+		b, _ := json.Marshal(j)
+		if err := os.WriteFile(filepath.Join(dir, j.ID+".json"), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := New(logger, dir, Config{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &savedHandler{
+		blockingHandler: blockingHandler{started: make(chan string, 10), release: make(chan struct{}, 10)},
+		nodes: []history.Node{
+			{ID: 1, Message: ai.NewUserTextMessage("first"), CreatedAt: startedAt.Add(-time.Hour)},
+			{ID: 2, Message: ai.NewModelTextMessage("old answer"), CreatedAt: startedAt.Add(-time.Hour)},
+			{ID: 3, Message: ai.NewUserTextMessage("first"), CreatedAt: startedAt.Add(time.Second)},
+			{ID: 4, Message: ai.NewModelTextMessage("saved answer"), CreatedAt: startedAt.Add(time.Second)},
+		},
+	}
+	handler.release <- struct{}{}
+	l.Start(handler)
+	defer l.Close()
+
+	if j := waitState(t, l, "saved", StateDone); j.Result == nil || j.Result.Response != "saved answer" {
+		t.Errorf("expected the saved answer, got %+v", j.Result)
+	}
+	if j := waitState(t, l, "lost", StateDone); j.Result == nil || j.Result.Response != "answer to second" {
+		t.Errorf("expected the job to run again, got %+v", j.Result)
+	}
+	if len(handler.started) != 1 {
+		t.Errorf("expected only the lost job to run again, got %d runs", len(handler.started))
+	}
+}
@@ -0,0 +1,312 @@
+// Package memory stores long-term facts about the users which survive the history resets
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var extractSystemPrompt = "You are a memory extraction AI. Your task is to find durable facts about the user in the latest conversation turn, like name, phone number, location, preferences, family members or other long-term personal details. Ignore temporary information and questions. Only respond with a JSON array of short strings containing the NEW facts which are not in the known facts list. Respond with [] if there is nothing new."
+var extractUserTemplate = "Known facts:\n%s\n\nLatest conversation turn:\nUser: %s\nModel: %s"
+
+// Summarizer is the LLM call used to extract the facts, it is compatible with the history.Summarizer
+type Summarizer interface {
+	Summarize(ctx context.Context, systemPrompt, text string) (string, error)
+}
+
+// Config .
+type Config struct {
+	Summarizer Summarizer // Extraction is disabled when nil, but the stored facts are still available
+	MaxFacts   int        // Max number of facts kept per user, the oldest are dropped, 0 means unlimited
+}
+
+// Fact .
+type Fact struct {
+	ID        int       `json:"id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Logic .
+type Logic struct {
+	logger     *slog.Logger
+	memoryPath string
+	config     Config
+
+	mu          sync.Mutex
+	generations map[string]int // Increased by Forget, so the facts of the extractions started before are dropped
+	wg          sync.WaitGroup
+}
+
+// New .
+func New(logger *slog.Logger, memoryPath string, config Config) (*Logic, error) {
+	if err := os.MkdirAll(memoryPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create memory directory: %w", err)
+	}
+
+	return &Logic{
+		logger:      logger,
+		memoryPath:  memoryPath,
+		config:      config,
+		generations: make(map[string]int),
+	}, nil
+}
+
+type saveFormat struct {
+	Facts []Fact `json:"facts"`
+}
+
+func (l *Logic) filePath(userID string) (string, error) {
+	trimmedID := strings.TrimSpace(userID)
+	if trimmedID == "" || trimmedID == "." ||
+		strings.Contains(trimmedID, "/") || strings.Contains(trimmedID, "\\") || strings.Contains(trimmedID, "..") {
+		return "", errors.New("invalid userID")
+	}
+
+	return filepath.Join(l.memoryPath, filepath.Base(trimmedID)+".json"), nil
+}
+
+func (l *Logic) read(userID string) ([]Fact, error) {
+	p, err := l.filePath(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) { // Not yet exists, ignore
+			return make([]Fact, 0), nil
+		}
+		return nil, err
+	}
+
+	var saved saveFormat
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+
+	return saved.Facts, nil
+}
+
+func (l *Logic) write(userID string, facts []Fact) error {
+	p, err := l.filePath(userID)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(saveFormat{Facts: facts})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(p, b, 0644)
+}
+
+// Facts returns the stored facts of the user
+func (l *Logic) Facts(ctx context.Context, userID string) ([]Fact, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.read(userID)
+}
+
+// Add stores new facts for the user, the already known ones are skipped
+func (l *Logic) Add(ctx context.Context, userID string, texts ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.add(userID, texts)
+}
+
+// add must be called with the lock held
+func (l *Logic) add(userID string, texts []string) error {
+	facts, err := l.read(userID)
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]struct{}, len(facts))
+	for _, f := range facts {
+		known[strings.ToLower(f.Text)] = struct{}{}
+	}
+
+	nextID := 1
+	if len(facts) > 0 {
+		nextID = facts[len(facts)-1].ID + 1
+	}
+
+	added := 0
+	for _, t := range texts {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if _, ok := known[strings.ToLower(t)]; ok {
+			continue
+		}
+		known[strings.ToLower(t)] = struct{}{}
+
+		facts = append(facts, Fact{ID: nextID, Text: t, CreatedAt: time.Now()})
+		nextID++
+		added++
+	}
+
+	if added == 0 {
+		return nil
+	}
+
+	if l.config.MaxFacts > 0 && len(facts) > l.config.MaxFacts {
+		facts = facts[len(facts)-l.config.MaxFacts:]
+	}
+
+	return l.write(userID, facts)
+}
+
+// Delete removes a single fact of the user
+func (l *Logic) Delete(ctx context.Context, userID string, factID int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	facts, err := l.read(userID)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range facts {
+		if f.ID == factID {
+			return l.write(userID, append(facts[:i:i], facts[i+1:]...))
+		}
+	}
+
+	return fmt.Errorf("fact %d not found", factID)
+}
+
+// Forget removes every fact of the user, the running extractions won't store their facts either
+func (l *Logic) Forget(ctx context.Context, userID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, err := l.filePath(userID)
+	if err != nil {
+		return err
+	}
+
+	l.generations[userID]++
+
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Prompt renders the facts of the user to be appended to the system prompt, empty if there is nothing known
+func (l *Logic) Prompt(ctx context.Context, userID string) (string, error) {
+	facts, err := l.Facts(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if len(facts) == 0 {
+		return "", nil
+	}
+
+	return "Known facts about the user:\n" + factsToString(facts), nil
+}
+
+// ExtractAsync runs the fact extraction in the background, the request context cancellation is ignored
+func (l *Logic) ExtractAsync(ctx context.Context, userID, userMessage, modelMessage string) {
+	if l.config.Summarizer == nil {
+		return
+	}
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		if err := l.Extract(context.WithoutCancel(ctx), userID, userMessage, modelMessage); err != nil {
+			l.logger.Error("failed to extract user memory", slog.String("userID", userID), slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// Extract asks the LLM for new durable facts in the conversation turn and stores them
+func (l *Logic) Extract(ctx context.Context, userID, userMessage, modelMessage string) error {
+	if l.config.Summarizer == nil {
+		return errors.New("summarizer is not configured")
+	}
+
+	l.mu.Lock()
+	generation := l.generations[userID]
+	facts, err := l.read(userID)
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	res, err := l.config.Summarizer.Summarize(ctx, extractSystemPrompt, fmt.Sprintf(extractUserTemplate, factsToString(facts), userMessage, modelMessage))
+	if err != nil {
+		return fmt.Errorf("failed to generate facts: %w", err)
+	}
+
+	newFacts, err := parseFacts(res)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.generations[userID] != generation {
+		l.logger.Info("user memory was forgotten during the extraction, dropping the facts", slog.String("userID", userID))
+
+		return nil
+	}
+	l.logger.Info("user memory extracted", slog.String("userID", userID), slog.Int("num_facts", len(newFacts)))
+
+	return l.add(userID, newFacts)
+}
+
+// Close waits for the running extractions
+func (l *Logic) Close() {
+	l.wg.Wait()
+}
+
+func factsToString(facts []Fact) string {
+	if len(facts) == 0 {
+		return "none"
+	}
+
+	var sb strings.Builder
+	for _, f := range facts {
+		sb.WriteString("- ")
+		sb.WriteString(f.Text)
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
+
+// parseFacts reads the JSON array from the model answer, which could be wrapped in a markdown code block
+func parseFacts(s string) ([]string, error) {
+	start := strings.Index(s, "[")
+	end := strings.LastIndex(s, "]")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no JSON array found in the extraction result: %s", s)
+	}
+
+	var facts []string
+	if err := json.Unmarshal([]byte(s[start:end+1]), &facts); err != nil {
+		return nil, fmt.Errorf("failed to parse the extraction result: %w", err)
+	}
+
+	return facts, nil
+}
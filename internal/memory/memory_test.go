@@ -0,0 +1,129 @@
+package memory
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+type mockSummarizer struct {
+	res        string
+	lastPrompt string
+	during     func() // Optional, runs while the extraction waits for the model
+}
+
+func (m *mockSummarizer) Summarize(ctx context.Context, systemPrompt, text string) (string, error) {
+	m.lastPrompt = text
+	if m.during != nil {
+		m.during()
+	}
+	return m.res, nil
+}
+
+func newTestLogic(t *testing.T, cfg Config) *Logic {
+	t.Helper()
+
+	l, err := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), t.TempDir(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestAddDeleteForget(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLogic(t, Config{MaxFacts: 2})
+
+	if err := l.Add(ctx, "user1", "Name is Bob", "name is bob", "Lives in Budapest"); err != nil {
+		t.Fatal(err)
+	}
+
+	facts, err := l.Facts(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(facts) != 2 {
+		t.Fatalf("expected 2 facts after deduplication, got %d", len(facts))
+	}
+
+	// The oldest fact is dropped above the limit
+	if err := l.Add(ctx, "user1", "Has a cat"); err != nil {
+		t.Fatal(err)
+	}
+	facts, _ = l.Facts(ctx, "user1")
+	if len(facts) != 2 || facts[0].Text != "Lives in Budapest" || facts[1].ID != 3 {
+		t.Fatalf("unexpected facts after limit: %+v", facts)
+	}
+
+	if err := l.Delete(ctx, "user1", facts[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Delete(ctx, "user1", 42); err == nil {
+		t.Error("expected error for a missing fact")
+	}
+
+	if err := l.Forget(ctx, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	facts, _ = l.Facts(ctx, "user1")
+	if len(facts) != 0 {
+		t.Errorf("expected no facts after forget, got %d", len(facts))
+	}
+}
+
+func TestExtract(t *testing.T) {
+	ctx := context.Background()
+	s := &mockSummarizer{res: "```json\n[\"Name is Alice\"]\n```"}
+	l := newTestLogic(t, Config{Summarizer: s})
+
+	if err := l.Add(ctx, "user1", "Likes tea"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Extract(ctx, "user1", "Hi, I'm Alice", "Hello Alice!"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(s.lastPrompt, "- Likes tea") {
+		t.Errorf("known facts are missing from the extraction prompt: %s", s.lastPrompt)
+	}
+
+	prompt, err := l.Prompt(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(prompt, "- Likes tea\n- Name is Alice\n") {
+		t.Errorf("unexpected memory prompt: %s", prompt)
+	}
+
+	s.res = "nothing to add"
+	if err := l.Extract(ctx, "user1", "ok", "ok"); err == nil {
+		t.Error("expected error for an invalid extraction result")
+	}
+
+	// A forget during the extraction drops its facts
+	s.res = `["Has a dog"]`
+	s.during = func() {
+		if err := l.Forget(ctx, "user1"); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := l.Extract(ctx, "user1", "I have a dog", "Nice!"); err != nil {
+		t.Fatal(err)
+	}
+	if facts, _ := l.Facts(ctx, "user1"); len(facts) != 0 {
+		t.Errorf("expected no facts after forget, got %+v", facts)
+	}
+}
+
+func TestInvalidUserID(t *testing.T) {
+	l := newTestLogic(t, Config{})
+
+	for _, id := range []string{"", "../secret", "a/b", "."} {
+		if _, err := l.Facts(context.Background(), id); err == nil {
+			t.Errorf("expected error for userID %q", id)
+		}
+	}
+}
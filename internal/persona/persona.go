@@ -0,0 +1,142 @@
+// Package persona renders the system prompt from a Go template file and reloads it when the file changes
+package persona
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Data is available in the personality template
+type Data struct {
+	Now     time.Time // Current time, e.g. {{.Now.Format "2006-01-02 15:04"}}
+	Date    string    // Current date in a human readable format
+	UserID  string
+	Channel string // telegram, messenger, whatsapp, cli or web
+	Locale  string // Locale of the user if the client sent it, e.g. hu-HU
+	Memory  string // Known facts about the user, if the template doesn't use it, it is appended at the end
+}
+
+// Logic .
+type Logic struct {
+	logger *slog.Logger
+	path   string
+
+	mu         sync.RWMutex
+	tmpl       *template.Template
+	usesMemory bool
+	modTime    time.Time
+	size       int64
+}
+
+// New loads the personality template from the given path
+func New(logger *slog.Logger, path string) (*Logic, error) {
+	l := &Logic{
+		logger: logger,
+		path:   path,
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Logic) load() error {
+	st, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(l.path)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("personality").Option("missingkey=zero").Parse(string(b))
+	if err != nil {
+		return fmt.Errorf("failed to parse personality template: %w", err)
+	}
+
+	l.mu.Lock()
+	l.tmpl = tmpl
+	l.usesMemory = strings.Contains(string(b), ".Memory")
+	l.modTime = st.ModTime()
+	l.size = st.Size()
+	l.mu.Unlock()
+
+	return nil
+}
+
+func (l *Logic) changed() bool {
+	st, err := os.Stat(l.path)
+	if err != nil {
+		l.logger.Warn("failed to stat personality file", slog.String("path", l.path), slog.String("error", err.Error()))
+
+		return false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return !st.ModTime().Equal(l.modTime) || st.Size() != l.size
+}
+
+// Watch polls the file and reloads it on change until the context is done
+// A broken template is logged and the previous one is kept.
+func (l *Logic) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !l.changed() {
+				continue
+			}
+
+			if err := l.load(); err != nil {
+				l.logger.Error("failed to reload personality", slog.String("path", l.path), slog.String("error", err.Error()))
+
+				continue
+			}
+			l.logger.Info("personality reloaded", slog.String("path", l.path))
+		}
+	}
+}
+
+// Render executes the template with the given data
+func (l *Logic) Render(data Data) (string, error) {
+	if data.Now.IsZero() {
+		data.Now = time.Now()
+	}
+	if data.Date == "" {
+		data.Date = data.Now.Format("Monday, 2006-01-02")
+	}
+
+	l.mu.RLock()
+	tmpl := l.tmpl
+	usesMemory := l.usesMemory
+	l.mu.RUnlock()
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render personality: %w", err)
+	}
+
+	if !usesMemory && data.Memory != "" {
+		buf.WriteString("\n\n")
+		buf.WriteString(data.Memory)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,100 @@
+package persona
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "personality.txt")
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	now := time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+
+	t.Run("plain text with memory appended", func(t *testing.T) {
+		writeFile(t, path, "You are a bot.", now)
+		l, err := New(logger, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := l.Render(Data{Now: now, Memory: "Known facts"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "You are a bot.\n\nKnown facts" {
+			t.Errorf("unexpected render result: %q", got)
+		}
+	})
+
+	t.Run("template variables", func(t *testing.T) {
+		writeFile(t, path, "Today is {{.Date}}, user {{.UserID}} on {{.Channel}}{{if .Locale}} ({{.Locale}}){{end}}.{{with .Memory}} {{.}}{{end}}", now)
+		l, err := New(logger, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := l.Render(Data{Now: now, UserID: "tg-1", Channel: "telegram", Locale: "hu-HU", Memory: "Name is Bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "Today is Monday, 2025-06-02, user tg-1 on telegram (hu-HU). Name is Bob"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		writeFile(t, path, "{{.Broken", now)
+		if _, err := New(logger, path); err == nil {
+			t.Error("expected error for an invalid template")
+		}
+	})
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "personality.txt")
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	start := time.Now().Add(-time.Hour)
+
+	writeFile(t, path, "first", start)
+	l, err := New(logger, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Watch(ctx, 10*time.Millisecond)
+
+	// A broken template keeps the previous one
+	writeFile(t, path, "{{.Broken", start.Add(time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := l.Render(Data{}); got != "first" {
+		t.Fatalf("expected the previous personality to be kept, got %q", got)
+	}
+
+	writeFile(t, path, "second", start.Add(2*time.Minute))
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if got, _ := l.Render(Data{}); got == "second" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("personality was not reloaded")
+}
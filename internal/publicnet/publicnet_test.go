@@ -0,0 +1,49 @@
+package publicnet
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.20.0.5":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+		"224.0.0.1":        false,
+	} {
+		if got := IsPublic(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("IsPublic(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	ctx := context.Background()
+
+	for _, u := range []string{"http://127.0.0.1:8080/hook", "http://localhost/", "http://[::1]/", "http://169.254.169.254/latest"} {
+		if err := CheckURL(ctx, u); !errors.Is(err, ErrNotPublic) {
+			t.Errorf("CheckURL(%s): expected ErrNotPublic, got %v", u, err)
+		}
+	}
+	for _, u := range []string{"ftp://example.com/", "http://", "::"} {
+		if err := CheckURL(ctx, u); err == nil || errors.Is(err, ErrNotPublic) {
+			t.Errorf("CheckURL(%s): expected an invalid URL error, got %v", u, err)
+		}
+	}
+	if err := CheckURL(ctx, "https://8.8.8.8/"); err != nil {
+		t.Errorf("expected a public address to be allowed, got %v", err)
+	}
+}
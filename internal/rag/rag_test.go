@@ -0,0 +1,71 @@
+package rag
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hairy-botter/internal/ai/fake"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+func fakeEmbedding(ctx context.Context, text string) ([]float32, error) {
+	return fake.Vector(text), nil
+}
+
+func TestRetrieve(t *testing.T) {
+	ragPath := t.TempDir()
+	docs := map[string]string{
+		"pets.txt":    "Our office dog is called Bodri and he likes sausages.",
+		"opening.txt": "The shop is open from 9 to 17 on weekdays.",
+		"wifi.txt":    "The guest wifi password is changed every month.",
+	}
+	for name, content := range docs {
+		if err := os.WriteFile(filepath.Join(ragPath, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), ragPath, fakeEmbedding)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range docs {
+		if _, err := os.Stat(filepath.Join(ragPath, name+".loaded")); err != nil {
+			t.Errorf("expected %s to be marked as loaded: %v", name, err)
+		}
+	}
+
+	resp, err := l.Retrieve(context.Background(), &ai.RetrieverRequest{
+		Query:   ai.DocumentFromText("when is the shop open on weekdays", nil),
+		Options: map[string]any{"limit": 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Documents) != 1 || resp.Documents[0].Content[0].Text != docs["opening.txt"] {
+		t.Fatalf("unexpected documents: %+v", resp.Documents)
+	}
+
+	// The database is persisted and reloaded without the source files
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l, err = New(slog.New(slog.NewTextHandler(os.Stdout, nil)), ragPath, fakeEmbedding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = l.Retrieve(context.Background(), &ai.RetrieverRequest{
+		Query: ai.DocumentFromText("does the office dog like sausages", nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Documents) != 3 || resp.Documents[0].Content[0].Text != docs["pets.txt"] {
+		t.Errorf("unexpected documents after reload: %+v", resp.Documents)
+	}
+}
@@ -0,0 +1,379 @@
+// Package scheduler runs prompts for the sessions on a schedule and delivers the answers to the channel adapters
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/delivery"
+	"hairy-botter/internal/publicnet"
+)
+
+const fileName = "jobs.json"
+
+// ErrNotFound is returned for unknown job IDs
+var ErrNotFound = errors.New("job not found")
+
+// A failed one-off job is retried after retryDelay, it is dropped after maxAttempts
+const (
+	retryDelay  = 5 * time.Minute
+	maxAttempts = 5
+)
+
+// reminderPrompt is sent to the agent when a reminder is due
+const reminderPrompt = "This is a scheduled reminder which the user asked for earlier, the reminder: %s\nWrite a short reminder message to the user."
+
+type messageHandler interface {
+	HandleMessage(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error)
+}
+
+// deliverer is implemented by the delivery.Logic
+type deliverer interface {
+	CanDeliver(sessionID string) bool
+	Deliver(ctx context.Context, sessionID, text string) error
+	DeliverTo(ctx context.Context, callbackURL, sessionID, text string) error
+	CheckCallback(ctx context.Context, callbackURL string) error
+}
+
+// Config .
+type Config struct {
+	Delivery deliverer      // Sends the answers of the jobs without own callback URL
+	Location *time.Location // Timezone of the cron expressions, nil means the local timezone
+	Interval time.Duration  // How often the due jobs are checked, 0 means 15 seconds
+}
+
+// Job is a scheduled prompt, the cron jobs are repeated and the others run once at RunAt
+type Job struct {
+	ID          string    `json:"id"`
+	SessionID   string    `json:"sessionId"`
+	Channel     string    `json:"channel,omitempty"`
+	Agent       string    `json:"agent,omitempty"`
+	Prompt      string    `json:"prompt"`
+	Title       string    `json:"title,omitempty"` // Short description for the listings, e.g. the reminder text
+	Cron        string    `json:"cron,omitempty"`
+	RunAt       time.Time `json:"runAt"` // Next run
+	CallbackURL string    `json:"callbackUrl,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastRun     time.Time `json:"lastRun,omitzero"`
+	LastError   string    `json:"lastError,omitempty"`
+	Attempts    int       `json:"attempts,omitempty"` // Failed runs of a one-off job
+}
+
+// Logic .
+type Logic struct {
+	logger *slog.Logger
+	path   string
+	cfg    Config
+
+	mu      sync.Mutex
+	jobs    []Job
+	running map[string]struct{}
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New loads the persisted jobs, they are only run after Start
+func New(logger *slog.Logger, schedulerPath string, cfg Config) (*Logic, error) {
+	if err := os.MkdirAll(schedulerPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create scheduler directory: %w", err)
+	}
+	if cfg.Delivery == nil {
+		return nil, errors.New("missing delivery")
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 15 * time.Second
+	}
+
+	l := &Logic{
+		logger:  logger,
+		path:    filepath.Join(schedulerPath, fileName),
+		cfg:     cfg,
+		running: make(map[string]struct{}),
+	}
+
+	b, err := os.ReadFile(l.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &l.jobs); err != nil {
+			return nil, fmt.Errorf("failed to parse jobs: %w", err)
+		}
+	}
+
+	return l, nil
+}
+
+// save must be called with the lock held
+func (l *Logic) save() error {
+	b, err := json.MarshalIndent(l.jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(l.path, b, 0644)
+}
+
+// Add validates and stores the job, the ID, the creation time and the next run of the cron jobs are set here
+func (l *Logic) Add(ctx context.Context, job Job) (Job, error) {
+	job.SessionID = strings.TrimSpace(job.SessionID)
+	if job.SessionID == "" {
+		return Job{}, errors.New("missing sessionId")
+	}
+	if strings.TrimSpace(job.Prompt) == "" {
+		return Job{}, errors.New("missing prompt")
+	}
+
+	now := time.Now()
+	if job.Cron != "" {
+		s, err := parseCron(job.Cron)
+		if err != nil {
+			return Job{}, err
+		}
+		job.RunAt = s.next(now.In(l.cfg.Location))
+		if job.RunAt.IsZero() {
+			return Job{}, fmt.Errorf("cron expression %q never runs", job.Cron)
+		}
+	} else if !job.RunAt.After(now) {
+		return Job{}, errors.New("the run time must be in the future")
+	}
+
+	if job.CallbackURL == "" && !l.cfg.Delivery.CanDeliver(job.SessionID) {
+		return Job{}, fmt.Errorf("no callback URL and no registered channel endpoint for %s", job.SessionID)
+	}
+	if job.CallbackURL != "" {
+		if err := l.cfg.Delivery.CheckCallback(ctx, job.CallbackURL); err != nil {
+			return Job{}, err
+		}
+	}
+
+	job.ID = strings.ToLower(rand.Text()[:10])
+	job.CreatedAt = now
+	job.LastRun = time.Time{}
+	job.LastError = ""
+	job.Attempts = 0
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.jobs = append(l.jobs, job)
+
+	return job, l.save()
+}
+
+// AddReminder schedules a one-off reminder about the text for the session, it runs on the given agent
+func (l *Logic) AddReminder(ctx context.Context, sessionID, channel, agent string, at time.Time, text string) (Job, error) {
+	return l.Add(ctx, Job{
+		SessionID: sessionID,
+		Channel:   channel,
+		Agent:     agent,
+		Prompt:    fmt.Sprintf(reminderPrompt, text),
+		Title:     text,
+		RunAt:     at,
+	})
+}
+
+// List returns the jobs of the session ordered by the next run, an empty sessionID means every job
+func (l *Logic) List(ctx context.Context, sessionID string) ([]Job, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	jobs := make([]Job, 0)
+	for _, j := range l.jobs {
+		if sessionID == "" || j.SessionID == sessionID {
+			jobs = append(jobs, j)
+		}
+	}
+	slices.SortFunc(jobs, func(a, b Job) int { return a.RunAt.Compare(b.RunAt) })
+
+	return jobs, nil
+}
+
+// Delete removes the job, if the sessionID is not empty the job must belong to that session
+func (l *Logic) Delete(ctx context.Context, sessionID, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, j := range l.jobs {
+		if j.ID == id && (sessionID == "" || j.SessionID == sessionID) {
+			l.jobs = slices.Delete(l.jobs, i, i+1)
+
+			return l.save()
+		}
+	}
+
+	return ErrNotFound
+}
+
+// Start runs the due jobs in the background until Close, the handler generates the answers
+func (l *Logic) Start(handler messageHandler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(l.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			l.runDue(ctx, handler, time.Now())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the scheduler and waits for the running jobs
+func (l *Logic) Close() {
+	if l.cancel != nil {
+		l.cancel()
+	}
+	l.wg.Wait()
+}
+
+// runDue starts the jobs which are due, the missed runs (e.g. during a restart) are run once
+func (l *Logic) runDue(ctx context.Context, handler messageHandler, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var due []Job
+	remaining := l.jobs[:0]
+	for _, j := range l.jobs {
+		if _, ok := l.running[j.ID]; ok || j.RunAt.After(now) {
+			remaining = append(remaining, j)
+			continue
+		}
+
+		due = append(due, j)
+		if j.Cron == "" {
+			remaining = append(remaining, j) // One-off jobs are removed by finish after a successful run
+			continue
+		}
+
+		s, err := parseCron(j.Cron)
+		if err == nil {
+			j.RunAt = s.next(now.In(l.cfg.Location))
+		}
+		if err != nil || j.RunAt.IsZero() {
+			l.logger.Error("dropping job without next run", slog.String("id", j.ID), slog.String("cron", j.Cron))
+			continue
+		}
+		remaining = append(remaining, j)
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	l.jobs = remaining
+	if err := l.save(); err != nil {
+		l.logger.Error("failed to save jobs", slog.String("err", err.Error()))
+	}
+
+	for _, j := range due {
+		l.running[j.ID] = struct{}{}
+
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+
+			err := l.run(ctx, handler, j)
+			l.finish(j.ID, err)
+		}()
+	}
+}
+
+// finish records the result of the run on the job if it is still scheduled
+// The one-off jobs are removed after a successful run or a refused delivery, the other failures are retried later
+func (l *Logic) finish(id string, runErr error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.running, id)
+
+	for i := range l.jobs {
+		if l.jobs[i].ID != id {
+			continue
+		}
+
+		j := &l.jobs[i]
+		j.LastRun = time.Now()
+		j.LastError = ""
+		if runErr != nil {
+			j.LastError = runErr.Error()
+		}
+
+		if j.Cron == "" {
+			switch {
+			case runErr == nil:
+				l.jobs = slices.Delete(l.jobs, i, i+1)
+			case errors.Is(runErr, delivery.ErrRejected) || errors.Is(runErr, publicnet.ErrNotPublic):
+				// The retries would be rejected the same way
+				l.logger.Error("dropping one-off job, the delivery was refused", slog.String("id", id), slog.String("sessionID", j.SessionID), slog.String("err", runErr.Error()))
+				l.jobs = slices.Delete(l.jobs, i, i+1)
+			default:
+				j.Attempts++
+				j.RunAt = j.LastRun.Add(retryDelay)
+				if j.Attempts >= maxAttempts {
+					l.logger.Error("dropping one-off job after failed attempts", slog.String("id", id), slog.Int("attempts", j.Attempts))
+					l.jobs = slices.Delete(l.jobs, i, i+1)
+				}
+			}
+		}
+
+		if err := l.save(); err != nil {
+			l.logger.Error("failed to save jobs", slog.String("err", err.Error()))
+		}
+
+		return
+	}
+}
+
+func (l *Logic) run(ctx context.Context, handler messageHandler, j Job) error {
+	logger := l.logger.With(slog.String("job", j.ID), slog.String("sessionID", j.SessionID))
+	logger.Info("running scheduled job")
+
+	resp, err := handler.HandleMessage(ctx, j.SessionID, domain.Request{
+		Message: j.Prompt,
+		Channel: j.Channel,
+		Agent:   j.Agent,
+	})
+	if err != nil {
+		logger.Error("scheduled job failed", slog.String("err", err.Error()))
+
+		return err
+	}
+
+	if j.CallbackURL != "" {
+		err = l.cfg.Delivery.DeliverTo(ctx, j.CallbackURL, j.SessionID, resp.Text)
+	} else {
+		err = l.cfg.Delivery.Deliver(ctx, j.SessionID, resp.Text)
+	}
+	if err != nil {
+		logger.Error("failed to deliver scheduled message", slog.String("err", err.Error()))
+
+		return err
+	}
+
+	return nil
+}
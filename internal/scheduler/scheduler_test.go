@@ -0,0 +1,177 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/delivery"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2026, 1, 30, 10, 7, 30, 0, time.UTC) // Friday
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "*/15 * * * *", want: time.Date(2026, 1, 30, 10, 15, 0, 0, time.UTC)},
+		{expr: "0 9 * * *", want: time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)},
+		{expr: "30 8 * * 1-5", want: time.Date(2026, 2, 2, 8, 30, 0, 0, time.UTC)},
+		{expr: "0 0 30 * *", want: time.Date(2026, 3, 30, 0, 0, 0, 0, time.UTC)}, // February has no 30th
+		{expr: "0 12 1 * 0", want: time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)}, // Sunday or the 1st
+		{expr: "@weekly", want: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range tests {
+		s, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%s): %v", tc.expr, err)
+		}
+		if got := s.next(from); !got.Equal(tc.want) {
+			t.Errorf("next(%s) = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%s) expected error, got nil", expr)
+		}
+	}
+}
+
+type mockHandler struct {
+	requests chan domain.Request
+	err      error
+}
+
+func (m *mockHandler) HandleMessage(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error) {
+	m.requests <- req
+	if m.err != nil {
+		return domain.Response{}, m.err
+	}
+
+	return domain.Response{Text: "answer for " + sessionID}, nil
+}
+
+func TestRunDue(t *testing.T) {
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	dir := t.TempDir()
+
+	delivered := make(chan string, 10)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.FormValue("sessionId") + ": " + r.FormValue("payload")
+	}))
+	defer callback.Close()
+
+	d, err := delivery.New(logger, t.TempDir(), delivery.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Register(ctx, "tg-", callback.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Config{Delivery: d, Location: time.UTC}
+	l, err := New(logger, dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.Add(ctx, Job{SessionID: "web-1", Channel: "web", Prompt: "hi", Cron: "@daily"}); err == nil {
+		t.Error("expected error for a channel without callback")
+	}
+	if _, err := l.AddReminder(ctx, "tg-1", "telegram", "", time.Now().Add(-time.Minute), "past"); err == nil {
+		t.Error("expected error for a past reminder")
+	}
+
+	reminder, err := l.AddReminder(ctx, "tg-1", "telegram", "helper", time.Now().Add(time.Hour), "buy milk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily, err := l.Add(ctx, Job{SessionID: "tg-2", Channel: "telegram", Prompt: "Daily news", Cron: "0 9 * * *"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The jobs are persisted
+	l, err = New(logger, dir, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := l.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 jobs, got %d", len(jobs))
+	}
+
+	// A failed one-off job is kept for a retry
+	failing := &mockHandler{requests: make(chan domain.Request, 10), err: errors.New("model error")}
+	l.runDue(ctx, failing, reminder.RunAt)
+	l.wg.Wait()
+
+	if req := <-failing.requests; req.Agent != "helper" {
+		t.Errorf("expected the reminder to run on its agent, got %q", req.Agent)
+	}
+	jobs, err = l.List(ctx, "tg-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Attempts != 1 || jobs[0].LastError != "model error" || jobs[0].RunAt.Equal(reminder.RunAt) {
+		t.Fatalf("expected the failed reminder to be rescheduled, got %+v", jobs)
+	}
+
+	handler := &mockHandler{requests: make(chan domain.Request, 10)}
+	l.runDue(ctx, handler, daily.RunAt.Add(-time.Second))
+	l.runDue(ctx, handler, reminder.RunAt.Add(24*time.Hour))
+	l.wg.Wait()
+
+	if len(handler.requests) != 2 || len(delivered) != 2 {
+		t.Fatalf("expected 2 runs and deliveries, got %d and %d", len(handler.requests), len(delivered))
+	}
+
+	jobs, err = l.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != daily.ID {
+		t.Fatalf("expected only the cron job to stay, got %+v", jobs)
+	}
+	if !jobs[0].RunAt.After(reminder.RunAt.Add(24*time.Hour)) || jobs[0].LastRun.IsZero() || jobs[0].LastError != "" {
+		t.Errorf("unexpected cron job state: %+v", jobs[0])
+	}
+
+	// A rejected delivery is not retried
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer rejecting.Close()
+	if _, err := d.Register(ctx, "tg-3", rejecting.URL); err != nil {
+		t.Fatal(err)
+	}
+	rejected, err := l.AddReminder(ctx, "tg-3", "telegram", "", time.Now().Add(time.Hour), "unknown chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.runDue(ctx, handler, rejected.RunAt)
+	l.wg.Wait()
+	if jobs, _ = l.List(ctx, "tg-3"); len(jobs) != 0 {
+		t.Errorf("expected the rejected reminder to be dropped, got %+v", jobs)
+	}
+
+	if err := l.Delete(ctx, "tg-1", daily.ID); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for an other session, got %v", err)
+	}
+	if err := l.Delete(ctx, "tg-2", daily.ID); err != nil {
+		t.Fatal(err)
+	}
+}
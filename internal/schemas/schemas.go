@@ -0,0 +1,71 @@
+// Package schemas is the registry of the named JSON schemas used for the structured answers
+package schemas
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Registry .
+type Registry struct {
+	schemas map[string]map[string]any
+}
+
+// Load reads every <name>.json file of the directory, a missing directory means an empty registry
+func Load(dir string) (*Registry, error) {
+	r := &Registry{schemas: make(map[string]map[string]any)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return r, nil
+		}
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		schema, err := Parse(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", e.Name(), err)
+		}
+		r.schemas[strings.TrimSuffix(e.Name(), ".json")] = schema
+	}
+
+	return r, nil
+}
+
+// Get returns the named schema
+func (r *Registry) Get(name string) (map[string]any, bool) {
+	s, ok := r.schemas[name]
+
+	return s, ok
+}
+
+// Parse decodes and checks the JSON schema
+func Parse(text string) (map[string]any, error) {
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(text), &schema); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	if _, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schema)); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	return schema, nil
+}
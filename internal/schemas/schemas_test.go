@@ -0,0 +1,44 @@
+package schemas
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "triage.json"), []byte(`{"type": "object", "required": ["category"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a schema"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := r.Get("triage"); !ok || s["type"] != "object" {
+		t.Errorf("unexpected schema: %v", s)
+	}
+	if _, ok := r.Get("notes"); ok {
+		t.Error("expected only the JSON files to be loaded")
+	}
+
+	r, err = Load(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Get("triage"); ok {
+		t.Error("expected an empty registry")
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, text := range []string{`not json`, `{"type": 5}`} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%s) expected error, got nil", text)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/history"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type nodeResponse struct {
+	ID        int       `json:"id"`
+	ParentID  int       `json:"parentId"`
+	Role      string    `json:"role"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+}
+
+// branchError maps the errors of the branch operations to statuses
+func branchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, history.ErrNotFound), errors.Is(err, domain.ErrUnknownAgent):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, history.ErrExists), errors.Is(err, history.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getHistory returns the messages of the active branch, their IDs could be used for the edits and the forks
+func (s *Server) getHistory(w http.ResponseWriter, r *http.Request) {
+	nodes, err := s.cfg.Branches.Branch(r.Context(), chi.URLParam(r, "sessionID"), agentName(r))
+	if err != nil {
+		branchError(w, err)
+
+		return
+	}
+
+	messages := make([]nodeResponse, 0, len(nodes))
+	for _, n := range nodes {
+		var text strings.Builder
+		for _, p := range n.Message.Content {
+			if p.IsText() {
+				text.WriteString(p.Text)
+			}
+		}
+		messages = append(messages, nodeResponse{
+			ID:        n.ID,
+			ParentID:  n.ParentID,
+			Role:      string(n.Message.Role),
+			Text:      text.String(),
+			CreatedAt: n.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Messages []nodeResponse `json:"messages"`
+	}{
+		Messages: messages,
+	})
+}
+
+func (s *Server) postRegenerate(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "sessionID")
+
+	res, err := s.cfg.Branches.Regenerate(r.Context(), sessionID, domain.Request{
+		Channel: channel(r, sessionID),
+		Locale:  locale(r),
+		Agent:   agentName(r),
+	})
+	if s.modelUnavailable(w, r, err) {
+		return
+	}
+	if err != nil {
+		branchError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newMessageResponse(res))
+}
+
+// putMessage replaces the user message, it takes the same form as the /message
+func (s *Server) putMessage(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "sessionID")
+	messageID, err := strconv.Atoi(chi.URLParam(r, "messageID"))
+	if err != nil {
+		http.Error(w, "invalid message ID", http.StatusBadRequest)
+
+		return
+	}
+
+	msg := r.PostFormValue("message")
+	inlineData, err := readInlineData(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+	if strings.TrimSpace(msg) == "" && len(inlineData) == 0 {
+		http.Error(w, "missing message", http.StatusBadRequest)
+
+		return
+	}
+
+	res, err := s.cfg.Branches.Edit(r.Context(), sessionID, messageID, domain.Request{
+		Message:    msg,
+		InlineData: inlineData,
+		Channel:    channel(r, sessionID),
+		Locale:     locale(r),
+		Agent:      agentName(r),
+	})
+	if s.modelUnavailable(w, r, err) {
+		return
+	}
+	if err != nil {
+		branchError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newMessageResponse(res))
+}
+
+// forkRequest copies the branch until the message into the new session, the empty fields mean a generated session and the whole branch
+type forkRequest struct {
+	SessionID string `json:"sessionId"`
+	MessageID int    `json:"messageId"`
+}
+
+func (s *Server) postFork(w http.ResponseWriter, r *http.Request) {
+	var req forkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+	if req.SessionID == "" {
+		req.SessionID = s.genSessionID()
+	}
+
+	err := s.cfg.Branches.Fork(r.Context(), chi.URLParam(r, "sessionID"), req.SessionID, agentName(r), req.MessageID)
+	if err != nil {
+		branchError(w, err)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(struct {
+		SessionID string `json:"sessionId"`
+	}{
+		SessionID: req.SessionID,
+	})
+}
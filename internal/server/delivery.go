@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/delivery"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// requireToken rejects the requests without the bearer token
+func requireToken(token string) func(http.Handler) http.Handler {
+	want := sha256.Sum256([]byte(token))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			// The hashes have the same length, so the comparison doesn't leak the token length
+			sum := sha256.Sum256([]byte(got))
+			if !ok || subtle.ConstantTimeCompare(sum[:], want[:]) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="channels"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type channelRequest struct {
+	Prefix string `json:"prefix"`
+	URL    string `json:"url"`
+}
+
+func (s *Server) postChannel(w http.ResponseWriter, r *http.Request) {
+	var req channelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+
+	e, err := s.cfg.Delivery.Register(r.Context(), req.Prefix, req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(e)
+}
+
+func (s *Server) getChannels(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := s.cfg.Delivery.Endpoints(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Channels []delivery.Endpoint `json:"channels"`
+	}{
+		Channels: endpoints,
+	})
+}
+
+// deleteChannel takes the prefix from the query, it could contain any character
+func (s *Server) deleteChannel(w http.ResponseWriter, r *http.Request) {
+	err := s.cfg.Delivery.Unregister(r.Context(), r.URL.Query().Get("prefix"))
+	if errors.Is(err, delivery.ErrNoEndpoint) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pushRequest relays the message as it is, or generates it from the prompt like a normal message of the session
+type pushRequest struct {
+	Message string `json:"message"`
+	Prompt  string `json:"prompt"`
+	Agent   string `json:"agent"`
+}
+
+func (s *Server) postPush(w http.ResponseWriter, r *http.Request) {
+	sessionID := chi.URLParam(r, "sessionID")
+
+	var req pushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+	if (req.Message == "") == (req.Prompt == "") {
+		http.Error(w, "exactly one of message and prompt must be set", http.StatusBadRequest)
+
+		return
+	}
+	if !s.cfg.Delivery.CanDeliver(sessionID) {
+		http.Error(w, delivery.ErrNoEndpoint.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	text := req.Message
+	if req.Prompt != "" {
+		res, err := s.logic.HandleMessage(r.Context(), sessionID, domain.Request{
+			Message: req.Prompt,
+			Channel: channel(r, sessionID),
+			Agent:   req.Agent,
+		})
+		if errors.Is(err, domain.ErrUnknownAgent) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+		text = res.Text
+	}
+
+	if err := s.cfg.Delivery.Deliver(r.Context(), sessionID, text); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Message string `json:"message"`
+	}{
+		Message: text,
+	})
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"hairy-botter/internal/jobs"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// postJob accepts the same form as the /message without files, and the optional callbackUrl field
+func (s *Server) postJob(w http.ResponseWriter, r *http.Request) {
+	schema, err := s.requestSchema(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	userID := s.userID(w, r)
+	job, err := s.cfg.Jobs.Submit(r.Context(), jobs.Job{
+		SessionID:   userID,
+		Agent:       agentName(r),
+		Channel:     channel(r, userID),
+		Locale:      locale(r),
+		Message:     r.PostFormValue("message"),
+		Schema:      schema,
+		CallbackURL: r.PostFormValue("callbackUrl"),
+	})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.cfg.Jobs.Get(r.Context(), chi.URLParam(r, "jobID"))
+	if errors.Is(err, jobs.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+func (s *Server) deleteJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.cfg.Jobs.Cancel(r.Context(), chi.URLParam(r, "jobID"))
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) getMemory(w http.ResponseWriter, r *http.Request) {
+	facts, err := s.cfg.Memory.Facts(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Facts any `json:"facts"`
+	}{
+		Facts: facts,
+	})
+}
+
+func (s *Server) deleteMemory(w http.ResponseWriter, r *http.Request) {
+	err := s.cfg.Memory.Forget(r.Context(), chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteMemoryFact(w http.ResponseWriter, r *http.Request) {
+	factID, err := strconv.Atoi(chi.URLParam(r, "factID"))
+	if err != nil {
+		http.Error(w, "invalid fact ID", http.StatusBadRequest)
+
+		return
+	}
+
+	err = s.cfg.Memory.Delete(r.Context(), chi.URLParam(r, "userID"), factID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
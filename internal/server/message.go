@@ -3,67 +3,201 @@ package server
 import (
 	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strings"
 
+	"hairy-botter/internal/ai/agent"
 	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/history"
+	"hairy-botter/internal/schemas"
+
+	"github.com/go-chi/chi/v5"
 )
 
 const sessionCookieName = "sessionID"
 
+// retryAfter is sent with the 503 responses in seconds, the retries and the fallbacks are already exhausted by then
+const retryAfter = "30"
+
 func (s *Server) genSessionID() string {
 	return rand.Text()
 }
 
+// channelPrefixes maps the session prefixes of the included clients to channel names
+var channelPrefixes = []struct {
+	prefix  string
+	channel string
+}{
+	{prefix: "tg-", channel: "telegram"},
+	{prefix: "fb-", channel: "messenger"},
+	{prefix: "whatsapp-", channel: "whatsapp"},
+	{prefix: "client-cli", channel: "cli"},
+}
+
+// channel returns the X-Channel header or guesses it from the userID
+func channel(r *http.Request, userID string) string {
+	if ch := r.Header.Get("X-Channel"); ch != "" {
+		return ch
+	}
+
+	for _, p := range channelPrefixes {
+		if strings.HasPrefix(userID, p.prefix) {
+			return p.channel
+		}
+	}
+
+	return "web"
+}
+
+// locale returns the X-Locale header or the first language of the Accept-Language header
+func locale(r *http.Request) string {
+	if l := r.Header.Get("X-Locale"); l != "" {
+		return l
+	}
+
+	lang, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	lang, _, _ = strings.Cut(lang, ";")
+
+	return strings.TrimSpace(lang)
+}
+
+// requestSchema returns the inline JSON schema or the named one from the registry, nil if none of them is set
+func (s *Server) requestSchema(r *http.Request) (map[string]any, error) {
+	if text := r.PostFormValue("schema"); text != "" {
+		return schemas.Parse(text)
+	}
+
+	name := r.PostFormValue("schemaName")
+	if name == "" {
+		return nil, nil
+	}
+	if s.cfg.Schemas != nil {
+		if schema, ok := s.cfg.Schemas.Get(name); ok {
+			return schema, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown schema: %s", name)
+}
+
+// userID returns the X-User-ID header, or the session cookie which is created if needed
+func (s *Server) userID(w http.ResponseWriter, r *http.Request) string {
+	if userID := r.Header.Get("X-User-ID"); userID != "" {
+		return userID
+	}
+
+	sessionCookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		// Cookie not found, create one
+		sessionCookie = &http.Cookie{
+			Name:  sessionCookieName,
+			Value: s.genSessionID(),
+		}
+
+		http.SetCookie(w, sessionCookie)
+	}
+
+	return sessionCookie.Value
+}
+
+// readInlineData returns the uploaded files of a multipart request
+func readInlineData(r *http.Request) ([]*domain.InlineData, error) {
+	var inlineData []*domain.InlineData
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, nil // Not a multipart request
+	}
+
+	for _, fileHeaders := range r.MultipartForm.File {
+		for _, binHeader := range fileHeaders {
+			binReader, err := binHeader.Open()
+			if err != nil {
+				return nil, errors.New("failed to open payload file")
+			}
+			data := make([]byte, binHeader.Size)
+			if _, err := io.ReadFull(binReader, data); err != nil {
+				_ = binReader.Close()
+				return nil, errors.New("failed to read binary data")
+			}
+			_ = binReader.Close()
+			inlineData = append(inlineData, &domain.InlineData{
+				MimeType: binHeader.Header.Get("Content-Type"),
+				Data:     data,
+			})
+		}
+	}
+
+	return inlineData, nil
+}
+
+// modelUnavailable answers 503 to the transient model errors, the provider error is only logged because it could contain the request details
+func (s *Server) modelUnavailable(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !agent.IsTransient(err) {
+		return false
+	}
+
+	s.cfg.Logger.WarnContext(r.Context(), "the model is unavailable", slog.String("path", r.URL.Path), slog.String("err", err.Error()))
+	w.Header().Set("Retry-After", retryAfter)
+	http.Error(w, "the model is temporarily unavailable, please try again later", http.StatusServiceUnavailable)
+
+	return true
+}
+
+// agentName returns the agent from the URL path or from the X-Agent header
+func agentName(r *http.Request) string {
+	if name := chi.URLParam(r, "agent"); name != "" {
+		return name
+	}
+
+	return r.Header.Get("X-Agent")
+}
+
 func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", s.cfg.AllowedOrigin)
 	w.Header().Set("Access-Control-Allow-Methods", s.cfg.AllowedMethods)
 	w.Header().Set("Access-Control-Allow-Headers", s.cfg.AllowedHeaders)
 
 	msg := r.PostFormValue("message")
-	userID := r.Header.Get("X-User-ID") // Optionally pass userID in header
 
-	var inlineData []*domain.InlineData
-	if err := r.ParseMultipartForm(32 << 20); err == nil {
-		for _, fileHeaders := range r.MultipartForm.File {
-			for _, binHeader := range fileHeaders {
-				binReader, err := binHeader.Open()
-				if err != nil {
-					http.Error(w, "failed to open payload file", http.StatusInternalServerError)
-					return
-				}
-				data := make([]byte, binHeader.Size)
-				if _, err := binReader.Read(data); err != nil {
-					_ = binReader.Close()
-					http.Error(w, "failed to read binary data", http.StatusInternalServerError)
-					return
-				}
-				_ = binReader.Close()
-				inlineData = append(inlineData, &domain.InlineData{
-					MimeType: binHeader.Header.Get("Content-Type"),
-					Data:     data,
-				})
-			}
-		}
+	inlineData, err := readInlineData(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
 	}
 
-	if userID == "" { // No userID in header, use a cookie or create one if needed
-		sessionCookie, err := r.Cookie(sessionCookieName)
-		if err != nil {
-			// Cookie not found, create one
-			sessionCookie = &http.Cookie{
-				Name:  sessionCookieName,
-				Value: s.genSessionID(),
-			}
+	schema, err := s.requestSchema(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 
-			http.SetCookie(w, sessionCookie)
-		}
-		userID = sessionCookie.Value
+		return
 	}
 
+	userID := s.userID(w, r)
 	res, err := s.logic.HandleMessage(r.Context(), userID, domain.Request{
 		Message:    msg,
 		InlineData: inlineData,
+		Channel:    channel(r, userID),
+		Locale:     locale(r),
+		Agent:      agentName(r),
+		Schema:     schema,
 	})
+	if errors.Is(err, domain.ErrUnknownAgent) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if errors.Is(err, history.ErrConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+
+		return
+	}
+	if s.modelUnavailable(w, r, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 
@@ -71,9 +205,65 @@ func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(struct {
-		Response string `json:"response"`
-	}{
-		Response: res,
-	})
+	_ = json.NewEncoder(w).Encode(newMessageResponse(res))
+}
+
+type sourceResponse struct {
+	Type       string  `json:"type"`
+	URL        string  `json:"url,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	ID         string  `json:"id,omitempty"`
+	Similarity float32 `json:"similarity,omitempty"`
+}
+
+type toolResponse struct {
+	Name       string `json:"name"`
+	Args       any    `json:"args,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+type usageResponse struct {
+	InputTokens    int `json:"inputTokens"`
+	OutputTokens   int `json:"outputTokens"`
+	ThinkingTokens int `json:"thinkingTokens"`
+}
+
+// messageResponse keeps the original response field, the clients could ignore the others
+type messageResponse struct {
+	Response string           `json:"response"`
+	Data     any              `json:"data,omitempty"`
+	Model    string           `json:"model,omitempty"`
+	Sources  []sourceResponse `json:"sources,omitempty"`
+	Tools    []toolResponse   `json:"tools,omitempty"`
+	Usage    *usageResponse   `json:"usage,omitempty"`
+}
+
+func newMessageResponse(res domain.Response) messageResponse {
+	resp := messageResponse{
+		Response: res.Text,
+		Data:     res.Data,
+		Model:    res.Model,
+	}
+
+	for _, s := range res.Sources {
+		resp.Sources = append(resp.Sources, sourceResponse(s))
+	}
+	for _, t := range res.Tools {
+		resp.Tools = append(resp.Tools, toolResponse{
+			Name:       t.Name,
+			Args:       t.Args,
+			DurationMs: t.Duration.Milliseconds(),
+			Error:      t.Error,
+		})
+	}
+	if res.Model != "" { // No usage without a model call
+		resp.Usage = &usageResponse{
+			InputTokens:    res.Usage.InputTokens,
+			OutputTokens:   res.Usage.OutputTokens,
+			ThinkingTokens: res.Usage.ThinkingTokens,
+		}
+	}
+
+	return resp
 }
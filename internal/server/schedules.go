@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"hairy-botter/internal/scheduler"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) postSchedule(w http.ResponseWriter, r *http.Request) {
+	var job scheduler.Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+
+		return
+	}
+	if job.Channel == "" {
+		job.Channel = channel(r, job.SessionID)
+	}
+
+	job, err := s.cfg.Scheduler.Add(r.Context(), job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+// sessionParam returns the required user query parameter, so the requests only see the jobs of a single session
+func sessionParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	sessionID := r.URL.Query().Get("user")
+	if sessionID == "" {
+		http.Error(w, "missing user", http.StatusBadRequest)
+
+		return "", false
+	}
+
+	return sessionID, true
+}
+
+func (s *Server) getSchedules(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := sessionParam(w, r)
+	if !ok {
+		return
+	}
+
+	jobs, err := s.cfg.Scheduler.List(r.Context(), sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Jobs []scheduler.Job `json:"jobs"`
+	}{
+		Jobs: jobs,
+	})
+}
+
+func (s *Server) deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := sessionParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.cfg.Scheduler.Delete(r.Context(), sessionID, chi.URLParam(r, "jobID"))
+	if errors.Is(err, scheduler.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -4,13 +4,68 @@ package server
 import (
 	"context"
 	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/delivery"
+	"hairy-botter/internal/history"
+	"hairy-botter/internal/jobs"
+	"hairy-botter/internal/memory"
+	"hairy-botter/internal/scheduler"
+	"hairy-botter/internal/usage"
+	"hairy-botter/internal/webhook"
+	"log/slog"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type ai interface {
-	HandleMessage(ctx context.Context, userID string, req domain.Request) (string, error)
+	HandleMessage(ctx context.Context, userID string, req domain.Request) (domain.Response, error)
+}
+
+type memoryStore interface {
+	Facts(ctx context.Context, userID string) ([]memory.Fact, error)
+	Delete(ctx context.Context, userID string, factID int) error
+	Forget(ctx context.Context, userID string) error
+}
+
+type usageReporter interface {
+	Report(ctx context.Context, filter usage.Filter) (usage.Report, error)
+}
+
+type jobScheduler interface {
+	Add(ctx context.Context, job scheduler.Job) (scheduler.Job, error)
+	List(ctx context.Context, sessionID string) ([]scheduler.Job, error)
+	Delete(ctx context.Context, sessionID, id string) error
+}
+
+type outbound interface {
+	Register(ctx context.Context, prefix, callbackURL string) (delivery.Endpoint, error)
+	Unregister(ctx context.Context, prefix string) error
+	Endpoints(ctx context.Context) ([]delivery.Endpoint, error)
+	CanDeliver(sessionID string) bool
+	Deliver(ctx context.Context, sessionID, text string) error
+	DeliverTo(ctx context.Context, callbackURL, sessionID, text string) error
+}
+
+type jobQueue interface {
+	Submit(ctx context.Context, job jobs.Job) (jobs.Job, error)
+	Get(ctx context.Context, id string) (jobs.Job, error)
+	Cancel(ctx context.Context, id string) (jobs.Job, error)
+}
+
+type webhookRenderer interface {
+	Authorize(name, secret string) error
+	Render(name string, fields map[string]string) (webhook.Event, error)
+}
+
+type brancher interface {
+	Branch(ctx context.Context, sessionID, agent string) ([]history.Node, error)
+	Regenerate(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error)
+	Edit(ctx context.Context, sessionID string, messageID int, req domain.Request) (domain.Response, error)
+	Fork(ctx context.Context, sessionID, newSessionID, agent string, messageID int) error
+}
+
+type schemaRegistry interface {
+	Get(name string) (map[string]any, bool)
 }
 
 // Config .
@@ -18,6 +73,19 @@ type Config struct {
 	AllowedOrigin  string
 	AllowedMethods string
 	AllowedHeaders string
+
+	Memory    memoryStore     // Optional, the memory routes are only registered if set together with the ChannelToken
+	Usage     usageReporter   // Optional, the usage route is only registered if set
+	Schemas   schemaRegistry  // Optional, the named schemas of the structured answers
+	Scheduler jobScheduler    // Optional, the schedule routes are only registered if set together with the ChannelToken
+	Delivery  outbound        // Optional, the channel and push routes are only registered if set together with the ChannelToken
+	Webhooks  webhookRenderer // Optional, the webhook routes are only registered if set, the hooks with a destination run on the Jobs
+	Jobs      jobQueue        // Optional, the async job routes are only registered if set
+	Branches  brancher        // Optional, the history editing routes are only registered if set
+
+	ChannelToken string // Bearer token of the memory, channel, push and schedule routes, they could reach any user
+
+	Logger *slog.Logger // nil means the default logger
 }
 
 // Server .
@@ -30,6 +98,9 @@ type Server struct {
 
 // New .
 func New(addr string, aiLogic ai, cfg Config) *Server {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
 	h := chi.NewMux()
 	s := &Server{
 		h:     h,
@@ -44,6 +115,54 @@ func New(addr string, aiLogic ai, cfg Config) *Server {
 
 func (s *Server) addRoutes() {
 	s.h.Post("/message", s.postMessage)
+	s.h.Post("/agents/{agent}/message", s.postMessage)
+
+	if s.cfg.Usage != nil {
+		s.h.Get("/usage", s.getUsage)
+	}
+
+	if s.cfg.ChannelToken != "" {
+		s.h.Group(func(r chi.Router) {
+			r.Use(requireToken(s.cfg.ChannelToken))
+
+			if s.cfg.Memory != nil {
+				r.Get("/users/{userID}/memory", s.getMemory)
+				r.Delete("/users/{userID}/memory", s.deleteMemory)
+				r.Delete("/users/{userID}/memory/{factID}", s.deleteMemoryFact)
+			}
+
+			if s.cfg.Delivery != nil {
+				r.Post("/channels", s.postChannel)
+				r.Get("/channels", s.getChannels)
+				r.Delete("/channels", s.deleteChannel)
+				r.Post("/sessions/{sessionID}/push", s.postPush)
+			}
+
+			if s.cfg.Scheduler != nil {
+				r.Post("/schedules", s.postSchedule)
+				r.Get("/schedules", s.getSchedules)
+				r.Delete("/schedules/{jobID}", s.deleteSchedule)
+			}
+		})
+	}
+
+	if s.cfg.Jobs != nil {
+		s.h.Post("/jobs", s.postJob)
+		s.h.Get("/jobs/{jobID}", s.getJob)
+		s.h.Delete("/jobs/{jobID}", s.deleteJob)
+	}
+
+	if s.cfg.Branches != nil {
+		s.h.Get("/sessions/{sessionID}/history", s.getHistory)
+		s.h.Post("/sessions/{sessionID}/regenerate", s.postRegenerate)
+		s.h.Put("/sessions/{sessionID}/messages/{messageID}", s.putMessage)
+		s.h.Post("/sessions/{sessionID}/fork", s.postFork)
+	}
+
+	if s.cfg.Webhooks != nil {
+		s.h.Post("/webhook", s.postWebhook)
+		s.h.Post("/webhook/{hook}", s.postWebhook)
+	}
 
 	// CORS preflight request handler
 	s.h.Options("/*", func(w http.ResponseWriter, r *http.Request) {
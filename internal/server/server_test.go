@@ -1,24 +1,47 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/delivery"
+	"hairy-botter/internal/history"
+	"hairy-botter/internal/jobs"
+	"hairy-botter/internal/memory"
+	"hairy-botter/internal/scheduler"
+	"hairy-botter/internal/usage"
+	"hairy-botter/internal/webhook"
+
+	genkitai "github.com/firebase/genkit/go/ai" // The ai name is taken by the interface of the server
 )
 
 type mockAI struct {
-	err error
+	err  error
+	resp *domain.Response // Optional, the default is a plain text response
+	req  domain.Request   // The last handled request
 }
 
-func (m *mockAI) HandleMessage(ctx context.Context, userID string, req domain.Request) (string, error) {
+func (m *mockAI) HandleMessage(ctx context.Context, userID string, req domain.Request) (domain.Response, error) {
+	m.req = req
 	if m.err != nil {
-		return "", m.err
+		return domain.Response{}, m.err
+	}
+	if m.resp != nil {
+		return *m.resp, nil
 	}
-	return "mock response", nil
+	return domain.Response{Text: "mock response", Model: "mock-model"}, nil
 }
 
 func checkCORSHeaders(t *testing.T, w *httptest.ResponseRecorder, cfg Config) {
@@ -74,3 +97,584 @@ func TestCORSHeaders(t *testing.T) {
 		checkCORSHeaders(t, w, cfg)
 	})
 }
+
+func TestUsage(t *testing.T) {
+	l, err := usage.New(slog.New(slog.NewTextHandler(os.Stdout, nil)), t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_ = l.Record(ctx, usage.Record{Time: time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC), SessionID: "u1", Kind: usage.KindChat, Model: "m", InputTokens: 10})
+	_ = l.Record(ctx, usage.Record{Time: time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC), SessionID: "u1", Kind: usage.KindChat, Model: "m", InputTokens: 20})
+	_ = l.Record(ctx, usage.Record{Time: time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC), SessionID: "u2", Kind: usage.KindChat, Model: "m", InputTokens: 40})
+
+	srv := New(":8080", &mockAI{}, Config{Usage: l})
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantTokens int
+	}{
+		{query: "", wantStatus: http.StatusOK, wantTokens: 70},
+		{query: "?user=u1", wantStatus: http.StatusOK, wantTokens: 30},
+		{query: "?user=u1&from=2026-01-11", wantStatus: http.StatusOK, wantTokens: 20},
+		{query: "?to=2026-01-10", wantStatus: http.StatusOK, wantTokens: 50},
+		{query: "?from=2026-01-10T13:00:00Z", wantStatus: http.StatusOK, wantTokens: 20},
+		{query: "?from=yesterday", wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/usage"+tc.query, nil)
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, req)
+
+		if w.Code != tc.wantStatus {
+			t.Errorf("%s: expected status %d, got %d", tc.query, tc.wantStatus, w.Code)
+			continue
+		}
+		if tc.wantStatus != http.StatusOK {
+			continue
+		}
+
+		var report usage.Report
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Total.InputTokens != tc.wantTokens {
+			t.Errorf("%s: expected %d input tokens, got %d", tc.query, tc.wantTokens, report.Total.InputTokens)
+		}
+	}
+}
+
+func TestMessageResponse(t *testing.T) {
+	t.Run("metadata", func(t *testing.T) {
+		srv := New(":8080", &mockAI{resp: &domain.Response{
+			Text:  "answer",
+			Model: "mock-model",
+			Sources: []domain.Source{
+				{Type: domain.SourceRAG, ID: "doc-1", Similarity: 0.5},
+				{Type: domain.SourceSearch, URL: "https://example.com", Title: "Example"},
+			},
+			Tools: []domain.ToolCall{{Name: "calculator", Args: map[string]any{"expression": "1/0"}, Duration: 1500 * time.Millisecond, Error: "division by zero"}},
+			Usage: domain.Usage{InputTokens: 10, OutputTokens: 5, ThinkingTokens: 2},
+		}}, Config{})
+		req := httptest.NewRequest(http.MethodPost, "/message", nil)
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, req)
+
+		want := `{"response":"answer","model":"mock-model",` +
+			`"sources":[{"type":"rag","id":"doc-1","similarity":0.5},{"type":"search","url":"https://example.com","title":"Example"}],` +
+			`"tools":[{"name":"calculator","args":{"expression":"1/0"},"durationMs":1500,"error":"division by zero"}],` +
+			`"usage":{"inputTokens":10,"outputTokens":5,"thinkingTokens":2}}` + "\n"
+		if w.Body.String() != want {
+			t.Errorf("unexpected body:\n%s\nwant:\n%s", w.Body.String(), want)
+		}
+	})
+
+	t.Run("without model call", func(t *testing.T) {
+		srv := New(":8080", &mockAI{resp: &domain.Response{Text: "forgotten"}}, Config{})
+		req := httptest.NewRequest(http.MethodPost, "/message", nil)
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, req)
+
+		if w.Body.String() != `{"response":"forgotten"}`+"\n" {
+			t.Errorf("unexpected body: %s", w.Body.String())
+		}
+	})
+}
+
+func TestModelUnavailable(t *testing.T) {
+	srv := New(":8080", &mockAI{err: errors.New("fallback failed: Error 429, Message: quota exceeded for project 123")}, Config{})
+	req := httptest.NewRequest(http.MethodPost, "/message", nil)
+	w := httptest.NewRecorder()
+	srv.h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 503 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if strings.Contains(w.Body.String(), "quota") {
+		t.Errorf("the provider error leaked: %s", w.Body.String())
+	}
+
+	srv = New(":8080", &mockAI{err: errors.New("broken")}, Config{})
+	w = httptest.NewRecorder()
+	srv.h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/message", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for the other errors, got %d", w.Code)
+	}
+}
+
+type mockSchemas map[string]map[string]any
+
+func (m mockSchemas) Get(name string) (map[string]any, bool) {
+	s, ok := m[name]
+
+	return s, ok
+}
+
+func TestStructuredRequest(t *testing.T) {
+	triage := map[string]any{"type": "object"}
+	cfg := Config{Schemas: mockSchemas{"triage": triage}}
+
+	post := func(srv *Server, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, req)
+
+		return w
+	}
+
+	t.Run("named schema", func(t *testing.T) {
+		ai := &mockAI{resp: &domain.Response{Text: `{"category":"spam"}`, Data: map[string]any{"category": "spam"}}}
+		w := post(New(":8080", ai, cfg), url.Values{"message": {"hi"}, "schemaName": {"triage"}})
+
+		if w.Code != http.StatusOK || ai.req.Schema["type"] != "object" {
+			t.Fatalf("unexpected result: %d %v", w.Code, ai.req.Schema)
+		}
+		if w.Body.String() != `{"response":"{\"category\":\"spam\"}","data":{"category":"spam"}}`+"\n" {
+			t.Errorf("unexpected body: %s", w.Body.String())
+		}
+	})
+
+	t.Run("inline schema", func(t *testing.T) {
+		ai := &mockAI{}
+		w := post(New(":8080", ai, cfg), url.Values{"message": {"hi"}, "schema": {`{"type":"array"}`}})
+
+		if w.Code != http.StatusOK || ai.req.Schema["type"] != "array" {
+			t.Errorf("unexpected result: %d %v", w.Code, ai.req.Schema)
+		}
+	})
+
+	for name, form := range map[string]url.Values{
+		"unknown schema": {"message": {"hi"}, "schemaName": {"missing"}},
+		"invalid schema": {"message": {"hi"}, "schema": {`{"type":`}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if w := post(New(":8080", &mockAI{}, cfg), form); w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
+
+type mockOutbound struct {
+	delivered map[string]string
+}
+
+func (m *mockOutbound) Register(ctx context.Context, prefix, callbackURL string) (delivery.Endpoint, error) {
+	return delivery.Endpoint{Prefix: prefix, URL: callbackURL}, nil
+}
+
+func (m *mockOutbound) Unregister(ctx context.Context, prefix string) error {
+	return delivery.ErrNoEndpoint
+}
+
+func (m *mockOutbound) Endpoints(ctx context.Context) ([]delivery.Endpoint, error) {
+	return nil, nil
+}
+
+func (m *mockOutbound) CanDeliver(sessionID string) bool {
+	return strings.HasPrefix(sessionID, "tg-")
+}
+
+func (m *mockOutbound) Deliver(ctx context.Context, sessionID, text string) error {
+	m.delivered[sessionID] = text
+
+	return nil
+}
+
+func (m *mockOutbound) DeliverTo(ctx context.Context, callbackURL, sessionID, text string) error {
+	m.delivered[callbackURL] = text
+
+	return nil
+}
+
+func (m *mockOutbound) PostJSON(ctx context.Context, callbackURL string, v any) error {
+	return nil
+}
+
+func (m *mockOutbound) CheckCallback(ctx context.Context, callbackURL string) error {
+	return nil
+}
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name      string
+		sessionID string
+		token     string
+		body      string
+		status    int
+		delivered string
+	}{
+		{name: "relay", sessionID: "tg-1", body: `{"message":"hello"}`, status: http.StatusOK, delivered: "hello"},
+		{name: "generate", sessionID: "tg-1", body: `{"prompt":"say hello"}`, status: http.StatusOK, delivered: "mock response"},
+		{name: "both", sessionID: "tg-1", body: `{"message":"hello","prompt":"say hello"}`, status: http.StatusBadRequest},
+		{name: "no endpoint", sessionID: "web-1", body: `{"message":"hello"}`, status: http.StatusNotFound},
+		{name: "wrong token", sessionID: "tg-1", token: "guess", body: `{"message":"hello"}`, status: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ai := &mockAI{}
+			out := &mockOutbound{delivered: make(map[string]string)}
+			srv := New(":8080", ai, Config{Delivery: out, ChannelToken: "secret"})
+
+			if tc.token == "" {
+				tc.token = "secret"
+			}
+			req := httptest.NewRequest(http.MethodPost, "/sessions/"+tc.sessionID+"/push", strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			w := httptest.NewRecorder()
+			srv.h.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if out.delivered[tc.sessionID] != tc.delivered {
+				t.Errorf("expected %q delivered, got %q", tc.delivered, out.delivered[tc.sessionID])
+			}
+			if tc.name == "generate" && (ai.req.Message != "say hello" || ai.req.Channel != "telegram") {
+				t.Errorf("unexpected generate request: %+v", ai.req)
+			}
+		})
+	}
+}
+
+type mockScheduler struct {
+	deleted string
+}
+
+func (m *mockScheduler) Add(ctx context.Context, job scheduler.Job) (scheduler.Job, error) {
+	return job, nil
+}
+
+func (m *mockScheduler) List(ctx context.Context, sessionID string) ([]scheduler.Job, error) {
+	return []scheduler.Job{{ID: "1", SessionID: sessionID}}, nil
+}
+
+func (m *mockScheduler) Delete(ctx context.Context, sessionID, id string) error {
+	m.deleted = sessionID + "/" + id
+
+	return nil
+}
+
+func TestSchedules(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		status int
+	}{
+		{name: "list", method: http.MethodGet, target: "/schedules?user=tg-1", token: "secret", status: http.StatusOK},
+		{name: "list without user", method: http.MethodGet, target: "/schedules", token: "secret", status: http.StatusBadRequest},
+		{name: "delete", method: http.MethodDelete, target: "/schedules/1?user=tg-1", token: "secret", status: http.StatusNoContent},
+		{name: "delete without user", method: http.MethodDelete, target: "/schedules/1", token: "secret", status: http.StatusBadRequest},
+		{name: "no token", method: http.MethodGet, target: "/schedules?user=tg-1", status: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sched := &mockScheduler{}
+			srv := New(":8080", &mockAI{}, Config{Scheduler: sched, ChannelToken: "secret"})
+
+			req := httptest.NewRequest(tc.method, tc.target, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			srv.h.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("expected status %d, got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if tc.name == "delete" && sched.deleted != "tg-1/1" {
+				t.Errorf("expected the delete to be scoped to the session, got %q", sched.deleted)
+			}
+		})
+	}
+
+	// Without the token the routes are not registered
+	srv := New(":8080", &mockAI{}, Config{Scheduler: &mockScheduler{}})
+	w := httptest.NewRecorder()
+	srv.h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/schedules?user=tg-1", nil))
+	if w.Code == http.StatusOK {
+		t.Errorf("expected the schedule routes to be disabled without the token, got %d", w.Code)
+	}
+}
+
+func TestMemoryRequiresToken(t *testing.T) {
+	mem, err := memory.New(slog.New(slog.NewTextHandler(os.Stdout, nil)), t.TempDir(), memory.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Add(context.Background(), "tg-1", "Name is Bob"); err != nil {
+		t.Fatal(err)
+	}
+	srv := New(":8080", &mockAI{}, Config{Memory: mem, ChannelToken: "secret"})
+
+	w := httptest.NewRecorder()
+	srv.h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/tg-1/memory", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without the token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/tg-1/memory", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	srv.h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Name is Bob") {
+		t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	hooks, err := webhook.New(webhook.Config{Hooks: []webhook.Hook{
+		{Name: "gmail", Prompt: "Email from {{.Sender}}: {{.Subject}}", Session: "email-{{email .Sender}}", Destination: "tg-1", Secret: "mail-secret"},
+		{Name: "sync", Prompt: "Event: {{.type}}", Session: "events", Secret: "sync-secret"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("multipart with destination", func(t *testing.T) {
+		ai := &mockAI{}
+		out := &mockOutbound{delivered: make(map[string]string)}
+		q, err := jobs.New(slog.New(slog.NewTextHandler(os.Stdout, nil)), t.TempDir(), jobs.Config{Workers: 1, Notifier: out})
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.Start(ai)
+		srv := New(":8080", ai, Config{Delivery: out, Jobs: q, Webhooks: hooks})
+
+		var body bytes.Buffer
+		mpw := multipart.NewWriter(&body)
+		_ = mpw.WriteField("Sender", "John Doe <john@example.com>")
+		_ = mpw.WriteField("Subject", "Invoice")
+		part, _ := mpw.CreateFormFile("payload", "invoice.pdf")
+		_, _ = part.Write([]byte("%PDF-1.4"))
+		_ = mpw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/webhook", &body)
+		req.Header.Set("Content-Type", mpw.FormDataContentType())
+		req.Header.Set(webhook.SecretHeader, "mail-secret")
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, req)
+
+		var accepted struct {
+			SessionID string `json:"sessionId"`
+			JobID     string `json:"jobId"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&accepted); err != nil || w.Code != http.StatusAccepted || accepted.SessionID != "email-john@example.com" {
+			t.Fatalf("unexpected response: %d %+v", w.Code, accepted)
+		}
+		for i := 0; ; i++ {
+			job, err := q.Get(context.Background(), accepted.JobID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.State == jobs.StateDone {
+				break
+			}
+			if i == 200 {
+				t.Fatalf("the job did not finish: %+v", job)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		q.Close() // Waits for the delivery
+
+		if ai.req.Message != "Email from John Doe <john@example.com>: Invoice" || len(ai.req.InlineData) != 1 || ai.req.Channel != "webhook" {
+			t.Errorf("unexpected request: %+v", ai.req)
+		}
+		if out.delivered["tg-1"] != "mock response" {
+			t.Errorf("expected the answer delivered to tg-1, got %v", out.delivered)
+		}
+	})
+
+	t.Run("JSON without destination", func(t *testing.T) {
+		ai := &mockAI{}
+		srv := New(":8080", ai, Config{Webhooks: hooks})
+
+		req := httptest.NewRequest(http.MethodPost, "/webhook/sync", strings.NewReader(`{"type": "deploy"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhook.SecretHeader, "sync-secret")
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || ai.req.Message != "Event: deploy" {
+			t.Errorf("unexpected result: %d %s %+v", w.Code, w.Body.String(), ai.req)
+		}
+	})
+
+	t.Run("wrong secret", func(t *testing.T) {
+		ai := &mockAI{}
+		srv := New(":8080", ai, Config{Webhooks: hooks})
+		req := httptest.NewRequest(http.MethodPost, "/webhook/sync", strings.NewReader(`{"type": "deploy"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhook.SecretHeader, "mail-secret")
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusUnauthorized || ai.req.Message != "" {
+			t.Errorf("expected status 401 without a model call, got %d", w.Code)
+		}
+	})
+
+	t.Run("unknown hook", func(t *testing.T) {
+		srv := New(":8080", &mockAI{}, Config{Webhooks: hooks})
+		req := httptest.NewRequest(http.MethodPost, "/webhook/missing", nil)
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+}
+
+type mockJobs struct {
+	submitted []jobs.Job
+}
+
+func (m *mockJobs) Submit(ctx context.Context, job jobs.Job) (jobs.Job, error) {
+	job.ID = "job-1"
+	job.State = jobs.StateQueued
+	m.submitted = append(m.submitted, job)
+
+	return job, nil
+}
+
+func (m *mockJobs) Get(ctx context.Context, id string) (jobs.Job, error) {
+	if id != "job-1" {
+		return jobs.Job{}, jobs.ErrNotFound
+	}
+
+	return jobs.Job{ID: id, State: jobs.StateDone, Result: &jobs.Result{Response: "done"}}, nil
+}
+
+func (m *mockJobs) Cancel(ctx context.Context, id string) (jobs.Job, error) {
+	job, err := m.Get(ctx, id)
+	if err != nil {
+		return job, err
+	}
+
+	return job, jobs.ErrFinished
+}
+
+func TestJobs(t *testing.T) {
+	q := &mockJobs{}
+	srv := New(":8080", &mockAI{}, Config{Jobs: q})
+
+	form := url.Values{"message": {"long task"}, "callbackUrl": {"http://example.com/hook"}}
+	req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-User-ID", "tg-1")
+	w := httptest.NewRecorder()
+	srv.h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted || w.Header().Get("Location") != "/jobs/job-1" {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+	if len(q.submitted) != 1 || q.submitted[0].SessionID != "tg-1" || q.submitted[0].Channel != "telegram" || q.submitted[0].CallbackURL != "http://example.com/hook" {
+		t.Errorf("unexpected job: %+v", q.submitted)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{method: http.MethodGet, path: "/jobs/job-1", status: http.StatusOK},
+		{method: http.MethodGet, path: "/jobs/missing", status: http.StatusNotFound},
+		{method: http.MethodDelete, path: "/jobs/job-1", status: http.StatusConflict},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, w.Code)
+		}
+	}
+}
+
+type mockBranches struct {
+	edited   int
+	editReq  domain.Request
+	forkedTo string
+}
+
+func (m *mockBranches) Branch(ctx context.Context, sessionID, agent string) ([]history.Node, error) {
+	return []history.Node{
+		{ID: 1, Message: genkitai.NewUserTextMessage("hi")},
+		{ID: 2, ParentID: 1, Message: genkitai.NewModelTextMessage("hello")},
+	}, nil
+}
+
+func (m *mockBranches) Regenerate(ctx context.Context, sessionID string, req domain.Request) (domain.Response, error) {
+	return domain.Response{Text: "hello again"}, nil
+}
+
+func (m *mockBranches) Edit(ctx context.Context, sessionID string, messageID int, req domain.Request) (domain.Response, error) {
+	if messageID != 1 {
+		return domain.Response{}, history.ErrNotFound
+	}
+	m.edited = messageID
+	m.editReq = req
+
+	return domain.Response{Text: "edited answer"}, nil
+}
+
+func (m *mockBranches) Fork(ctx context.Context, sessionID, newSessionID, agent string, messageID int) error {
+	if newSessionID == "taken" {
+		return history.ErrExists
+	}
+	m.forkedTo = newSessionID
+
+	return nil
+}
+
+func TestBranches(t *testing.T) {
+	b := &mockBranches{}
+	srv := New(":8080", &mockAI{}, Config{Branches: b})
+
+	w := httptest.NewRecorder()
+	srv.h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions/s/history", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `{"id":2,"parentId":1,"role":"model","text":"hello"}`) {
+		t.Fatalf("unexpected history: %d %s", w.Code, w.Body.String())
+	}
+
+	form := url.Values{"message": {"hi there"}}
+	req := httptest.NewRequest(http.MethodPut, "/sessions/tg-1/messages/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	srv.h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || b.editReq.Message != "hi there" || b.editReq.Channel != "telegram" {
+		t.Fatalf("unexpected edit: %d %s %+v", w.Code, w.Body.String(), b.editReq)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{method: http.MethodPost, path: "/sessions/s/regenerate", status: http.StatusOK},
+		{method: http.MethodPut, path: "/sessions/s/messages/2", body: "message=x", status: http.StatusNotFound},
+		{method: http.MethodPut, path: "/sessions/s/messages/x", body: "message=x", status: http.StatusBadRequest},
+		{method: http.MethodPut, path: "/sessions/s/messages/1", status: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/sessions/s/fork", body: `{"sessionId":"copy","messageId":1}`, status: http.StatusCreated},
+		{method: http.MethodPost, path: "/sessions/s/fork", body: `{"sessionId":"taken"}`, status: http.StatusConflict},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.method == http.MethodPut {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		srv.h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, w.Code)
+		}
+	}
+	if b.forkedTo != "copy" {
+		t.Errorf("unexpected fork target: %s", b.forkedTo)
+	}
+}
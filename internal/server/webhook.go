@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/jobs"
+	"hairy-botter/internal/webhook"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// eventFields returns the fields of a form or a flat JSON object
+func eventFields(r *http.Request) (map[string]string, error) {
+	fields := make(map[string]string)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, errors.New("invalid JSON body")
+		}
+		for k, v := range body {
+			if s, ok := v.(string); ok {
+				fields[k] = s
+				continue
+			}
+			b, _ := json.Marshal(v)
+			fields[k] = string(b)
+		}
+
+		return fields, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	for k, v := range r.PostForm {
+		fields[k] = v[0]
+	}
+
+	return fields, nil
+}
+
+func (s *Server) postWebhook(w http.ResponseWriter, r *http.Request) {
+	err := s.cfg.Webhooks.Authorize(chi.URLParam(r, "hook"), r.Header.Get(webhook.SecretHeader))
+	if errors.Is(err, webhook.ErrUnknownHook) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+
+		return
+	}
+
+	inlineData, err := readInlineData(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	fields, err := eventFields(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	event, err := s.cfg.Webhooks.Render(chi.URLParam(r, "hook"), fields)
+	if errors.Is(err, webhook.ErrUnknownHook) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	req := domain.Request{
+		Message:    event.Prompt,
+		InlineData: inlineData,
+		Channel:    event.Channel,
+		Agent:      event.Agent,
+	}
+
+	// Without destination the caller gets the answer
+	if event.Destination == "" && event.DestinationURL == "" {
+		res, err := s.logic.HandleMessage(r.Context(), event.SessionID, req)
+		if errors.Is(err, domain.ErrUnknownAgent) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(newMessageResponse(res))
+
+		return
+	}
+
+	if s.cfg.Jobs == nil {
+		http.Error(w, "the webhook has a destination, but the jobs are not configured", http.StatusInternalServerError)
+
+		return
+	}
+
+	// The answer could take longer than the timeout of the sender, so it is generated on the job pool
+	job, err := s.cfg.Jobs.Submit(r.Context(), jobs.Job{
+		SessionID:      event.SessionID,
+		Agent:          event.Agent,
+		Channel:        event.Channel,
+		Message:        event.Prompt,
+		Files:          inlineData,
+		Destination:    event.Destination,
+		DestinationURL: event.DestinationURL,
+	})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(struct {
+		SessionID string `json:"sessionId"`
+		JobID     string `json:"jobId"`
+	}{
+		SessionID: event.SessionID,
+		JobID:     job.ID,
+	})
+}
@@ -0,0 +1,180 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"strconv"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+type calculatorInput struct {
+	Expression string `json:"expression" jsonschema:"description=Arithmetic expression like (2+3)*4 or sqrt(16)"`
+}
+
+var calcFuncs = map[string]func(args ...float64) (float64, error){
+	"sqrt":  unary(math.Sqrt),
+	"abs":   unary(math.Abs),
+	"floor": unary(math.Floor),
+	"ceil":  unary(math.Ceil),
+	"round": unary(math.Round),
+	"log":   unary(math.Log),
+	"log10": unary(math.Log10),
+	"sin":   unary(math.Sin),
+	"cos":   unary(math.Cos),
+	"tan":   unary(math.Tan),
+	"pow": func(args ...float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, errors.New("pow expects 2 arguments")
+		}
+		return math.Pow(args[0], args[1]), nil
+	},
+	"min": func(args ...float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, errors.New("min expects at least 1 argument")
+		}
+		res := args[0]
+		for _, a := range args[1:] {
+			res = math.Min(res, a)
+		}
+		return res, nil
+	},
+	"max": func(args ...float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, errors.New("max expects at least 1 argument")
+		}
+		res := args[0]
+		for _, a := range args[1:] {
+			res = math.Max(res, a)
+		}
+		return res, nil
+	},
+}
+
+var calcConsts = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
+}
+
+func unary(fn func(float64) float64) func(args ...float64) (float64, error) {
+	return func(args ...float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, errors.New("function expects 1 argument")
+		}
+		return fn(args[0]), nil
+	}
+}
+
+func defineCalculator() ai.Tool {
+	return ai.NewTool(NameCalculator,
+		"Evaluate an arithmetic expression. Supports + - * / %, parentheses, the pi and e constants and the sqrt, abs, floor, ceil, round, log, log10, sin, cos, tan, pow, min, max functions.",
+		func(ctx *ai.ToolContext, input calculatorInput) (string, error) {
+			res, err := Calculate(input.Expression)
+			if err != nil {
+				return "", err
+			}
+
+			return strconv.FormatFloat(res, 'g', -1, 64), nil
+		})
+}
+
+// Calculate evaluates a simple arithmetic expression without executing any code
+func Calculate(expression string) (float64, error) {
+	expr, err := parser.ParseExpr(expression)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expression: %w", err)
+	}
+
+	return evalExpr(expr)
+}
+
+func evalExpr(expr ast.Expr) (float64, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.INT && e.Kind != token.FLOAT {
+			return 0, fmt.Errorf("unsupported literal: %s", e.Value)
+		}
+		return strconv.ParseFloat(e.Value, 64)
+
+	case *ast.Ident:
+		v, ok := calcConsts[e.Name]
+		if !ok {
+			return 0, fmt.Errorf("unknown identifier: %s", e.Name)
+		}
+		return v, nil
+
+	case *ast.ParenExpr:
+		return evalExpr(e.X)
+
+	case *ast.UnaryExpr:
+		x, err := evalExpr(e.X)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return -x, nil
+		}
+		return 0, fmt.Errorf("unsupported unary operator: %s", e.Op)
+
+	case *ast.BinaryExpr:
+		x, err := evalExpr(e.X)
+		if err != nil {
+			return 0, err
+		}
+		y, err := evalExpr(e.Y)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x + y, nil
+		case token.SUB:
+			return x - y, nil
+		case token.MUL:
+			return x * y, nil
+		case token.QUO:
+			if y == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return x / y, nil
+		case token.REM:
+			if y == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return math.Mod(x, y), nil
+		}
+		return 0, fmt.Errorf("unsupported operator: %s", e.Op)
+
+	case *ast.CallExpr:
+		ident, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			return 0, errors.New("unsupported function call")
+		}
+		fn, ok := calcFuncs[ident.Name]
+		if !ok {
+			return 0, fmt.Errorf("unknown function: %s", ident.Name)
+		}
+		args := make([]float64, 0, len(e.Args))
+		for _, a := range e.Args {
+			v, err := evalExpr(a)
+			if err != nil {
+				return 0, err
+			}
+			args = append(args, v)
+		}
+		res, err := fn(args...)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", ident.Name, err)
+		}
+		return res, nil
+	}
+
+	return 0, fmt.Errorf("unsupported expression: %T", expr)
+}
@@ -0,0 +1,50 @@
+package tools
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		name      string
+		expr      string
+		want      float64
+		expectErr bool
+	}{
+		{name: "addition", expr: "1 + 2", want: 3},
+		{name: "precedence", expr: "2 + 3 * 4", want: 14},
+		{name: "parentheses", expr: "(2 + 3) * 4", want: 20},
+		{name: "unary minus", expr: "-5 + 2", want: -3},
+		{name: "float division", expr: "7 / 2", want: 3.5},
+		{name: "modulo", expr: "7 % 3", want: 1},
+		{name: "function", expr: "sqrt(16) + pow(2, 10)", want: 1028},
+		{name: "variadic function", expr: "max(1, 5, 3)", want: 5},
+		{name: "constant", expr: "round(pi * 100)", want: 314},
+		{name: "division by zero", expr: "1 / 0", expectErr: true},
+		{name: "unknown function", expr: "exec(1)", expectErr: true},
+		{name: "unknown identifier", expr: "os", expectErr: true},
+		{name: "string literal", expr: `"rm -rf /"`, expectErr: true},
+		{name: "selector call", expr: "os.Exit(1)", expectErr: true},
+		{name: "bitwise operator", expr: "1 << 2", expectErr: true},
+		{name: "invalid syntax", expr: "1 +", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Calculate(tt.expr)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Calculate(%q) expected error, got %v", tt.expr, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Calculate(%q) unexpected error: %v", tt.expr, err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Calculate(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"hairy-botter/internal/publicnet"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+const defaultFetchMaxBytes = 64 << 10
+
+type fetchInput struct {
+	URL string `json:"url" jsonschema:"description=The http or https URL to fetch"`
+}
+
+type fetchOutput struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType"`
+	Body        string `json:"body"`
+	Truncated   bool   `json:"truncated,omitempty"`
+}
+
+func defineFetchURL(maxBytes int64) ai.Tool {
+	if maxBytes <= 0 {
+		maxBytes = defaultFetchMaxBytes
+	}
+	client := publicnet.NewClient(15 * time.Second)
+
+	return ai.NewTool(NameFetchURL,
+		"Fetch the content of a public web page or API by URL with a GET request.",
+		func(ctx *ai.ToolContext, input fetchInput) (fetchOutput, error) {
+			u, err := url.Parse(input.URL)
+			if err != nil {
+				return fetchOutput{}, fmt.Errorf("invalid url: %w", err)
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				return fetchOutput{}, errors.New("only http and https urls are supported")
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+			if err != nil {
+				return fetchOutput{}, err
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				return fetchOutput{}, err
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			b, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+			if err != nil {
+				return fetchOutput{}, err
+			}
+
+			truncated := int64(len(b)) > maxBytes
+			if truncated {
+				b = b[:maxBytes]
+			}
+
+			return fetchOutput{
+				Status:      resp.StatusCode,
+				ContentType: resp.Header.Get("Content-Type"),
+				Body:        string(b),
+				Truncated:   truncated,
+			}, nil
+		})
+}
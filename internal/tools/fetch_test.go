@@ -0,0 +1,27 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hairy-botter/internal/publicnet"
+)
+
+func TestFetchURLRefusesLoopback(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer srv.Close()
+
+	_, err := defineFetchURL(0).RunRaw(context.Background(), map[string]any{"url": srv.URL})
+	if !errors.Is(err, publicnet.ErrNotPublic) {
+		t.Errorf("expected the loopback address to be refused, got %v", err)
+	}
+	if called {
+		t.Error("the request reached the server")
+	}
+}
@@ -0,0 +1,181 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+type note struct {
+	ID        int       `json:"id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type saveNoteInput struct {
+	Text string `json:"text" jsonschema:"description=The note to remember"`
+}
+
+type deleteNoteInput struct {
+	ID int `json:"id" jsonschema:"description=ID of the note to delete"`
+}
+
+// noteStore keeps the notes per session, if the path is empty they are kept in memory only
+type noteStore struct {
+	mu    sync.Mutex
+	path  string
+	notes map[string][]note
+}
+
+func newNoteStore(path string) *noteStore {
+	return &noteStore{
+		path:  path,
+		notes: make(map[string][]note),
+	}
+}
+
+func (s *noteStore) load(sessionID string) ([]note, error) {
+	if n, ok := s.notes[sessionID]; ok {
+		return n, nil
+	}
+	if s.path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(s.path, filepath.Base(sessionID)+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var notes []note
+	if err := json.Unmarshal(b, &notes); err != nil {
+		return nil, err
+	}
+	s.notes[sessionID] = notes
+
+	return notes, nil
+}
+
+func (s *noteStore) store(sessionID string, notes []note) error {
+	s.notes[sessionID] = notes
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(notes)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(s.path, filepath.Base(sessionID)+".json"), b, 0644)
+}
+
+func (s *noteStore) add(sessionID, text string) (note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notes, err := s.load(sessionID)
+	if err != nil {
+		return note{}, err
+	}
+
+	id := 1
+	if len(notes) > 0 {
+		id = notes[len(notes)-1].ID + 1
+	}
+	n := note{ID: id, Text: text, CreatedAt: time.Now()}
+
+	return n, s.store(sessionID, append(notes, n))
+}
+
+func (s *noteStore) list(sessionID string) ([]note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load(sessionID)
+}
+
+func (s *noteStore) delete(sessionID string, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notes, err := s.load(sessionID)
+	if err != nil {
+		return err
+	}
+
+	for i, n := range notes {
+		if n.ID == id {
+			return s.store(sessionID, append(notes[:i:i], notes[i+1:]...))
+		}
+	}
+
+	return fmt.Errorf("note %d not found", id)
+}
+
+func sessionFromToolContext(ctx *ai.ToolContext) (string, error) {
+	sessionID := domain.SessionIDFromContext(ctx)
+	if sessionID == "" || strings.Contains(sessionID, "/") || strings.Contains(sessionID, "\\") || strings.Contains(sessionID, "..") {
+		return "", errors.New("invalid sessionID")
+	}
+
+	return sessionID, nil
+}
+
+func defineNotes(s *noteStore) []ai.Tool {
+	saveNote := ai.NewTool(NameSaveNote,
+		"Save a short note for the current conversation to remember it later.",
+		func(ctx *ai.ToolContext, input saveNoteInput) (string, error) {
+			sessionID, err := sessionFromToolContext(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			n, err := s.add(sessionID, input.Text)
+			if err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("Note saved with ID %d", n.ID), nil
+		})
+
+	listNotes := ai.NewTool(NameListNotes,
+		"List all the saved notes of the current conversation.",
+		func(ctx *ai.ToolContext, _ struct{}) ([]note, error) {
+			sessionID, err := sessionFromToolContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return s.list(sessionID)
+		})
+
+	deleteNote := ai.NewTool(NameDeleteNote,
+		"Delete a saved note of the current conversation by its ID.",
+		func(ctx *ai.ToolContext, input deleteNoteInput) (string, error) {
+			sessionID, err := sessionFromToolContext(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			if err := s.delete(sessionID, input.ID); err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("Note %d deleted", input.ID), nil
+		})
+
+	return []ai.Tool{saveNote, listNotes, deleteNote}
+}
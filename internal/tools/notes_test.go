@@ -0,0 +1,55 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"hairy-botter/internal/ai/domain"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+func TestNotes(t *testing.T) {
+	dir := t.TempDir()
+	tools := defineNotes(newNoteStore(dir))
+	save, list, del := tools[0], tools[1], tools[2]
+
+	ctx := domain.WithSessionID(context.Background(), "s1")
+	for _, text := range []string{"first", "second"} {
+		if _, err := save.RunRaw(ctx, map[string]any{"text": text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := del.RunRaw(ctx, map[string]any{"id": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := del.RunRaw(ctx, map[string]any{"id": 7}); err == nil {
+		t.Error("expected an error for a missing note")
+	}
+
+	// A new store reads the persisted notes
+	reloaded := defineNotes(newNoteStore(dir))[1]
+	for _, l := range []ai.Tool{list, reloaded} {
+		out, err := l.RunRaw(ctx, map[string]any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		notes := out.([]any)
+		if len(notes) != 1 || notes[0].(map[string]any)["text"] != "second" {
+			t.Errorf("unexpected notes: %v", notes)
+		}
+	}
+
+	// The sessions don't see each others notes
+	out, err := list.RunRaw(domain.WithSessionID(context.Background(), "s2"), map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notes, _ := out.([]any); len(notes) != 0 {
+		t.Errorf("expected no notes for another session, got %v", out)
+	}
+
+	if _, err := save.RunRaw(domain.WithSessionID(context.Background(), "../x"), map[string]any{"text": "x"}); err == nil {
+		t.Error("expected an error for an invalid session")
+	}
+}
@@ -0,0 +1,100 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/scheduler"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Scheduler stores the reminders, it is implemented by the scheduler.Logic
+type Scheduler interface {
+	AddReminder(ctx context.Context, sessionID, channel, agent string, at time.Time, text string) (scheduler.Job, error)
+	List(ctx context.Context, sessionID string) ([]scheduler.Job, error)
+	Delete(ctx context.Context, sessionID, id string) error
+}
+
+type createReminderInput struct {
+	Time string `json:"time" jsonschema:"description=Time of the reminder in RFC3339 format with the timezone offset like 2026-01-02T09:00:00+01:00. Use the current_time tool for relative times"`
+	Text string `json:"text" jsonschema:"description=What to remind the user about"`
+}
+
+type deleteReminderInput struct {
+	ID string `json:"id" jsonschema:"description=ID of the reminder to delete"`
+}
+
+type reminder struct {
+	ID   string `json:"id"`
+	Time string `json:"time"`
+	Cron string `json:"cron,omitempty"`
+	Text string `json:"text"`
+}
+
+func defineReminders(s Scheduler) []ai.Tool {
+	createReminder := ai.NewTool(NameCreateReminder,
+		"Schedule a one-off reminder, the user gets a message about it at the given time.",
+		func(ctx *ai.ToolContext, input createReminderInput) (string, error) {
+			sessionID, err := sessionFromToolContext(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			at, err := time.Parse(time.RFC3339, input.Time)
+			if err != nil {
+				return "", fmt.Errorf("invalid time, use RFC3339 with timezone offset: %w", err)
+			}
+
+			job, err := s.AddReminder(ctx, sessionID, domain.ChannelFromContext(ctx), domain.AgentFromContext(ctx), at, input.Text)
+			if err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("Reminder scheduled with ID %s at %s", job.ID, job.RunAt.Format(time.RFC3339)), nil
+		})
+
+	listReminders := ai.NewTool(NameListReminders,
+		"List the scheduled reminders and the repeated jobs of the current conversation.",
+		func(ctx *ai.ToolContext, _ struct{}) ([]reminder, error) {
+			sessionID, err := sessionFromToolContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			jobs, err := s.List(ctx, sessionID)
+			if err != nil {
+				return nil, err
+			}
+
+			reminders := make([]reminder, 0, len(jobs))
+			for _, j := range jobs {
+				text := j.Title
+				if text == "" {
+					text = j.Prompt
+				}
+				reminders = append(reminders, reminder{ID: j.ID, Time: j.RunAt.Format(time.RFC3339), Cron: j.Cron, Text: text})
+			}
+
+			return reminders, nil
+		})
+
+	deleteReminder := ai.NewTool(NameDeleteReminder,
+		"Delete a scheduled reminder or repeated job of the current conversation by its ID.",
+		func(ctx *ai.ToolContext, input deleteReminderInput) (string, error) {
+			sessionID, err := sessionFromToolContext(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			if err := s.Delete(ctx, sessionID, input.ID); err != nil {
+				return "", err
+			}
+
+			return fmt.Sprintf("Reminder %s deleted", input.ID), nil
+		})
+
+	return []ai.Tool{createReminder, listReminders, deleteReminder}
+}
@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // The bot image has no zoneinfo, embed it
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+type timeInput struct {
+	Timezone string `json:"timezone,omitempty" jsonschema:"description=IANA timezone name like Europe/Budapest (default: UTC)"`
+}
+
+type timeOutput struct {
+	Time     string `json:"time"`
+	Weekday  string `json:"weekday"`
+	Timezone string `json:"timezone"`
+}
+
+func defineTime(now func() time.Time) ai.Tool {
+	return ai.NewTool(NameTime,
+		"Get the current date and time in the given timezone.",
+		func(ctx *ai.ToolContext, input timeInput) (timeOutput, error) {
+			tz := input.Timezone
+			if tz == "" {
+				tz = "UTC"
+			}
+
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return timeOutput{}, fmt.Errorf("unknown timezone %q: %w", tz, err)
+			}
+
+			t := now().In(loc)
+
+			return timeOutput{
+				Time:     t.Format(time.RFC3339),
+				Weekday:  t.Weekday().String(),
+				Timezone: loc.String(),
+			}, nil
+		})
+}
@@ -0,0 +1,33 @@
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTime(t *testing.T) {
+	now := func() time.Time { return time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC) }
+	tool := defineTime(now)
+
+	out, err := tool.RunRaw(context.Background(), map[string]any{"timezone": "Europe/Budapest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.(map[string]any)
+	if got["time"] != "2025-06-01T12:00:00+02:00" || got["weekday"] != "Sunday" || got["timezone"] != "Europe/Budapest" {
+		t.Errorf("unexpected output: %v", got)
+	}
+
+	out, err = tool.RunRaw(context.Background(), map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.(map[string]any); got["timezone"] != "UTC" || got["time"] != "2025-06-01T10:00:00Z" {
+		t.Errorf("expected UTC by default, got %v", got)
+	}
+
+	if _, err := tool.RunRaw(context.Background(), map[string]any{"timezone": "Mars/Olympus"}); err == nil {
+		t.Error("expected an error for an unknown timezone")
+	}
+}
@@ -0,0 +1,115 @@
+// Package tools contains native genkit tools which are running inside the bot, no MCP server is needed for them
+package tools
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Tool group names which could be enabled in the Config
+const (
+	GroupTime       = "time"
+	GroupCalculator = "calculator"
+	GroupNotes      = "notes"
+	GroupFetchURL   = "fetch_url"
+	GroupReminders  = "reminders"
+)
+
+// Tool names as the model sees them
+const (
+	NameTime       = "current_time"
+	NameCalculator = "calculator"
+	NameSaveNote   = "save_note"
+	NameListNotes  = "list_notes"
+	NameDeleteNote = "delete_note"
+	NameFetchURL   = "fetch_url"
+
+	NameCreateReminder = "create_reminder"
+	NameListReminders  = "list_reminders"
+	NameDeleteReminder = "delete_reminder"
+)
+
+// groupTools lists the tool names of the groups
+var groupTools = map[string][]string{
+	GroupTime:       {NameTime},
+	GroupCalculator: {NameCalculator},
+	GroupNotes:      {NameSaveNote, NameListNotes, NameDeleteNote},
+	GroupFetchURL:   {NameFetchURL},
+	GroupReminders:  {NameCreateReminder, NameListReminders, NameDeleteReminder},
+}
+
+// Config .
+type Config struct {
+	Enabled       []string  // Enabled tool groups, empty means no native tools
+	NotesPath     string    // Directory to persist the session notes, empty keeps them in memory only
+	FetchMaxBytes int64     // Max response size of the fetch_url tool, 0 means the default 64KiB
+	Scheduler     Scheduler // Required by the reminders group
+}
+
+// New creates the enabled tools and returns them, so they could be passed to the agent
+// The tools are not registered in genkit, they are resolved per generate call like the MCP tools.
+func New(logger *slog.Logger, cfg Config) ([]ai.Tool, error) {
+	var tools []ai.Tool
+
+	seen := make(map[string]struct{})
+	for _, group := range cfg.Enabled {
+		group = strings.TrimSpace(group)
+		if _, ok := seen[group]; ok {
+			continue // The tool names must be unique
+		}
+		seen[group] = struct{}{}
+
+		switch group {
+		case GroupTime:
+			tools = append(tools, defineTime(time.Now))
+		case GroupCalculator:
+			tools = append(tools, defineCalculator())
+		case GroupNotes:
+			if cfg.NotesPath != "" {
+				if err := os.MkdirAll(cfg.NotesPath, 0755); err != nil {
+					return nil, fmt.Errorf("failed to create notes directory: %w", err)
+				}
+			}
+			tools = append(tools, defineNotes(newNoteStore(cfg.NotesPath))...)
+		case GroupFetchURL:
+			tools = append(tools, defineFetchURL(cfg.FetchMaxBytes))
+		case GroupReminders:
+			if cfg.Scheduler == nil {
+				return nil, fmt.Errorf("the %s tool group needs a scheduler", group)
+			}
+			tools = append(tools, defineReminders(cfg.Scheduler)...)
+		case "":
+			continue
+		default:
+			return nil, fmt.Errorf("unknown native tool group: %s", group)
+		}
+
+		logger.Info("native tool group enabled", slog.String("group", group))
+	}
+
+	return tools, nil
+}
+
+// Select returns the tools of the given groups, the agents share the tools and their state
+func Select(tools []ai.Tool, groups []string) []ai.Tool {
+	names := make(map[string]struct{})
+	for _, group := range groups {
+		for _, name := range groupTools[strings.TrimSpace(group)] {
+			names[name] = struct{}{}
+		}
+	}
+
+	selected := make([]ai.Tool, 0, len(names))
+	for _, t := range tools {
+		if _, ok := names[t.Name()]; ok {
+			selected = append(selected, t)
+		}
+	}
+
+	return selected
+}
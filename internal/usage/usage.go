@@ -0,0 +1,300 @@
+// Package usage records the token usage and the estimated cost of every model call
+package usage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+const fileName = "usage.jsonl"
+
+// Kinds of the recorded calls
+const (
+	KindChat      = "chat"
+	KindSummary   = "summary" // History summary and memory extraction
+	KindEmbedding = "embedding"
+)
+
+// Price of a model in USD per million tokens
+type Price struct {
+	Input    float64 `json:"input"`
+	Output   float64 `json:"output"`
+	Thinking float64 `json:"thinking"` // The output price is used if it is 0
+}
+
+// Record is a single model call
+type Record struct {
+	Time           time.Time `json:"time"`
+	SessionID      string    `json:"sessionId"`
+	Channel        string    `json:"channel,omitempty"`
+	Kind           string    `json:"kind"`
+	Model          string    `json:"model"`
+	InputTokens    int       `json:"inputTokens"`
+	OutputTokens   int       `json:"outputTokens"`
+	ThinkingTokens int       `json:"thinkingTokens"`
+	Cost           float64   `json:"cost"`
+	Estimated      bool      `json:"estimated,omitempty"` // The provider didn't return the usage, it is counted from the text length
+}
+
+// Filter of the report, the empty fields are ignored
+type Filter struct {
+	SessionID string
+	From      time.Time
+	To        time.Time // Exclusive
+}
+
+// Summary is the aggregated usage of the records
+type Summary struct {
+	Calls          int     `json:"calls"`
+	InputTokens    int     `json:"inputTokens"`
+	OutputTokens   int     `json:"outputTokens"`
+	ThinkingTokens int     `json:"thinkingTokens"`
+	Cost           float64 `json:"cost"`
+}
+
+func (s *Summary) add(r Record) {
+	s.Calls++
+	s.InputTokens += r.InputTokens
+	s.OutputTokens += r.OutputTokens
+	s.ThinkingTokens += r.ThinkingTokens
+	s.Cost += r.Cost
+}
+
+// Report .
+type Report struct {
+	Total     Summary            `json:"total"`
+	ByModel   map[string]Summary `json:"byModel"`
+	ByKind    map[string]Summary `json:"byKind"`
+	BySession map[string]Summary `json:"bySession"`
+	ByChannel map[string]Summary `json:"byChannel"`
+}
+
+// LoadPrices reads the price table, the keys are the model names with or without the provider prefix
+func LoadPrices(path string) (map[string]Price, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var prices map[string]Price
+	if err := json.Unmarshal(b, &prices); err != nil {
+		return nil, fmt.Errorf("failed to parse price table: %w", err)
+	}
+
+	return prices, nil
+}
+
+// Logic .
+type Logic struct {
+	logger *slog.Logger
+	path   string
+	prices map[string]Price
+
+	mu sync.Mutex
+}
+
+// New .
+func New(logger *slog.Logger, usagePath string, prices map[string]Price) (*Logic, error) {
+	if err := os.MkdirAll(usagePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create usage directory: %w", err)
+	}
+
+	return &Logic{
+		logger: logger,
+		path:   filepath.Join(usagePath, fileName),
+		prices: prices,
+	}, nil
+}
+
+// price looks up the model with and without the provider prefix, e.g. "googleai/gemini-flash-latest"
+func (l *Logic) price(model string) (Price, bool) {
+	if p, ok := l.prices[model]; ok {
+		return p, true
+	}
+	if _, name, ok := strings.Cut(model, "/"); ok {
+		p, ok := l.prices[name]
+		return p, ok
+	}
+
+	return Price{}, false
+}
+
+// Cost returns the estimated cost of the call, 0 if the model is not in the price table
+func (l *Logic) Cost(model string, inputTokens, outputTokens, thinkingTokens int) float64 {
+	p, ok := l.price(model)
+	if !ok {
+		return 0
+	}
+
+	thinking := p.Thinking
+	if thinking == 0 {
+		thinking = p.Output
+	}
+
+	return (float64(inputTokens)*p.Input + float64(outputTokens)*p.Output + float64(thinkingTokens)*thinking) / 1_000_000
+}
+
+// Record stores the call, the session and the channel are taken from the context if they are empty
+func (l *Logic) Record(ctx context.Context, r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	if r.SessionID == "" {
+		r.SessionID = domain.SessionIDFromContext(ctx)
+	}
+	if r.Channel == "" {
+		r.Channel = domain.ChannelFromContext(ctx)
+	}
+	r.Cost = l.Cost(r.Model, r.InputTokens, r.OutputTokens, r.ThinkingTokens)
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Report aggregates the stored records matching the filter
+func (l *Logic) Report(ctx context.Context, filter Filter) (Report, error) {
+	report := Report{
+		ByModel:   make(map[string]Summary),
+		ByKind:    make(map[string]Summary),
+		BySession: make(map[string]Summary),
+		ByChannel: make(map[string]Summary),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) { // Nothing recorded yet
+			return report, nil
+		}
+		return report, err
+	}
+	defer func() { _ = f.Close() }()
+
+	add := func(m map[string]Summary, key string, r Record) {
+		s := m[key]
+		s.add(r)
+		m[key] = s
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			l.logger.Warn("skipping invalid usage record", slog.String("err", err.Error()))
+			continue
+		}
+
+		if filter.SessionID != "" && r.SessionID != filter.SessionID {
+			continue
+		}
+		if !filter.From.IsZero() && r.Time.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !r.Time.Before(filter.To) {
+			continue
+		}
+
+		report.Total.add(r)
+		add(report.ByModel, r.Model, r)
+		add(report.ByKind, r.Kind, r)
+		add(report.BySession, r.SessionID, r)
+		add(report.ByChannel, r.Channel, r)
+	}
+
+	return report, scanner.Err()
+}
+
+// Middleware records every successful model call, the model func returns the name of the answering model
+func (l *Logic) Middleware(kind string, model func() string) ai.ModelMiddleware {
+	return func(next ai.ModelFunc) ai.ModelFunc {
+		return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			resp, err := next(ctx, req, cb)
+			if err != nil {
+				return resp, err
+			}
+
+			r := Record{Kind: kind, Model: model()}
+			if resp.Usage != nil && (resp.Usage.InputTokens > 0 || resp.Usage.OutputTokens > 0) {
+				r.InputTokens = resp.Usage.InputTokens
+				r.OutputTokens = resp.Usage.OutputTokens
+				r.ThinkingTokens = resp.Usage.ThoughtsTokens
+			} else {
+				r.Estimated = true
+				for _, m := range req.Messages {
+					r.InputTokens += estimateTokens(m.Text())
+				}
+				if resp.Message != nil {
+					r.OutputTokens = estimateTokens(resp.Message.Text())
+				}
+			}
+
+			if err := l.Record(ctx, r); err != nil {
+				l.logger.Error("failed to record usage", slog.String("err", err.Error()))
+			}
+
+			return resp, nil
+		}
+	}
+}
+
+// EmbedFunc is compatible with the genkit_embedding.Func
+type EmbedFunc func(ctx context.Context, text string) ([]float32, error)
+
+// Embedding records the calls of the embedding function, the embedders don't return the usage, so it is always estimated
+func (l *Logic) Embedding(model string, fn EmbedFunc) EmbedFunc {
+	return func(ctx context.Context, text string) ([]float32, error) {
+		v, err := fn(ctx, text)
+		if err != nil {
+			return v, err
+		}
+
+		err = l.Record(ctx, Record{
+			Kind:        KindEmbedding,
+			Model:       model,
+			InputTokens: estimateTokens(text),
+			Estimated:   true,
+		})
+		if err != nil {
+			l.logger.Error("failed to record usage", slog.String("err", err.Error()))
+		}
+
+		return v, nil
+	}
+}
+
+// estimateTokens uses the usual 4 characters per token rule
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
@@ -0,0 +1,138 @@
+package usage
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"hairy-botter/internal/ai/domain"
+	"hairy-botter/internal/ai/fake"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+func newTestLogic(t *testing.T, prices map[string]Price) *Logic {
+	t.Helper()
+
+	l, err := New(slog.New(slog.NewTextHandler(os.Stdout, nil)), t.TempDir(), prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestCost(t *testing.T) {
+	l := newTestLogic(t, map[string]Price{
+		"gemini-flash-latest": {Input: 0.3, Output: 2.5},
+		"fake/echo":           {Input: 1, Output: 2, Thinking: 4},
+	})
+
+	tests := []struct {
+		model string
+		want  float64
+	}{
+		{model: "googleai/gemini-flash-latest", want: (1000*0.3 + 100*2.5 + 10*2.5) / 1_000_000},
+		{model: "fake/echo", want: (1000*1 + 100*2 + 10*4) / 1_000_000.0},
+		{model: "unknown", want: 0},
+	}
+	for _, tc := range tests {
+		if got := l.Cost(tc.model, 1000, 100, 10); math.Abs(got-tc.want) > 1e-12 {
+			t.Errorf("Cost(%s) = %v, want %v", tc.model, got, tc.want)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+	l := newTestLogic(t, map[string]Price{"model": {Input: 1, Output: 1}})
+
+	day := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: day, SessionID: "tg-1", Channel: "telegram", Kind: KindChat, Model: "model", InputTokens: 100, OutputTokens: 10},
+		{Time: day.Add(time.Hour), SessionID: "tg-1", Channel: "telegram", Kind: KindSummary, Model: "model", InputTokens: 50, OutputTokens: 5},
+		{Time: day.AddDate(0, 0, 1), SessionID: "web-1", Channel: "web", Kind: KindChat, Model: "other", InputTokens: 10, OutputTokens: 1},
+	}
+	for _, r := range records {
+		if err := l.Record(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := l.Report(ctx, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.Calls != 3 || report.Total.InputTokens != 160 || report.Total.OutputTokens != 16 {
+		t.Errorf("unexpected total: %+v", report.Total)
+	}
+	if report.ByChannel["telegram"].Calls != 2 || report.ByKind[KindSummary].InputTokens != 50 {
+		t.Errorf("unexpected grouping: %+v", report)
+	}
+	if math.Abs(report.ByModel["model"].Cost-165.0/1_000_000) > 1e-12 || report.ByModel["other"].Cost != 0 {
+		t.Errorf("unexpected cost: %+v", report.ByModel)
+	}
+
+	report, err = l.Report(ctx, Filter{SessionID: "tg-1", From: day.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.Calls != 1 || report.Total.InputTokens != 50 {
+		t.Errorf("unexpected filtered total: %+v", report.Total)
+	}
+
+	report, err = l.Report(ctx, Filter{To: day.AddDate(0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.Calls != 2 {
+		t.Errorf("expected the records before the end only, got %d", report.Total.Calls)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx := domain.WithChannel(domain.WithSessionID(context.Background(), "client-cli"), "cli")
+	l := newTestLogic(t, nil)
+
+	p := fake.New()
+	g := genkit.Init(ctx, genkit.WithPlugins(p))
+	model, err := p.DefineModel(g, "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = genkit.Generate(ctx, g,
+		ai.WithModel(model),
+		ai.WithPrompt("one two three"),
+		ai.WithMiddleware(l.Middleware(KindChat, model.Name)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	embed := l.Embedding("fake/hash", func(ctx context.Context, text string) ([]float32, error) {
+		return fake.Vector(text), nil
+	})
+	if _, err := embed(ctx, "12345678"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := l.Report(ctx, Filter{SessionID: "client-cli"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := report.ByKind[KindChat]
+	if chat.Calls != 1 || chat.InputTokens != 3 || chat.OutputTokens != 4 {
+		t.Errorf("unexpected chat usage: %+v", chat)
+	}
+	if e := report.ByKind[KindEmbedding]; e.Calls != 1 || e.InputTokens != 2 {
+		t.Errorf("unexpected embedding usage: %+v", e)
+	}
+	if report.ByChannel["cli"].Calls != 2 || report.ByModel["fake/echo"].Calls != 1 {
+		t.Errorf("unexpected grouping: %+v", report)
+	}
+}
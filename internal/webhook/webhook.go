@@ -0,0 +1,205 @@
+// Package webhook maps the fields of the incoming events (e.g. emails) to prompts by configurable templates
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// SecretHeader carries the secret of the hook in the requests
+const SecretHeader = "X-Webhook-Secret"
+
+var (
+	// ErrUnknownHook is returned if no hook is configured with the name
+	ErrUnknownHook = errors.New("unknown webhook")
+	// ErrUnauthorized is returned if the request doesn't have the secret of the hook
+	ErrUnauthorized = errors.New("invalid webhook secret")
+)
+
+// Hook describes how an event is turned into a message
+type Hook struct {
+	Name           string `json:"name"`
+	Prompt         string `json:"prompt"`         // Template of the prompt, the fields are available like {{.Subject}}
+	Session        string `json:"session"`        // Template of the sessionID, e.g. email-{{email .Sender}}
+	Agent          string `json:"agent"`          // Empty means the routing rules decide
+	Channel        string `json:"channel"`        // Channel of the message, "webhook" if empty
+	Destination    string `json:"destination"`    // Session to push the answer to, e.g. tg-123456
+	DestinationURL string `json:"destinationUrl"` // Callback URL to post the answer to, used if there is no destination session
+	Secret         string `json:"secret"`         // Required, the callers send it in the SecretHeader
+}
+
+// Config is the content of the webhooks config file
+type Config struct {
+	Default string `json:"default"` // Hook of the /webhook path, the first hook if empty
+	Hooks   []Hook `json:"hooks"`
+}
+
+// Event is the rendered hook
+type Event struct {
+	SessionID      string
+	Prompt         string
+	Agent          string
+	Channel        string
+	Destination    string
+	DestinationURL string
+}
+
+type compiledHook struct {
+	Hook
+	prompt  *template.Template
+	session *template.Template
+}
+
+// Registry .
+type Registry struct {
+	defaultHook string
+	hooks       map[string]compiledHook
+}
+
+var (
+	unsafeSessionChars = regexp.MustCompile(`[^a-zA-Z0-9@._+-]+|\.{2,}`)
+	repeatedDashes     = regexp.MustCompile(`-{2,}`)
+)
+
+// funcs are available in the templates
+var funcs = template.FuncMap{
+	// email returns the address of a "Name <address>" field
+	"email": func(s string) string {
+		if a, err := mail.ParseAddress(s); err == nil {
+			return a.Address
+		}
+		return strings.TrimSpace(s)
+	},
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	// truncate keeps the first n characters, e.g. of a long email body
+	"truncate": func(n int, s string) string {
+		if r := []rune(s); len(r) > n {
+			return string(r[:n]) + "..."
+		}
+		return s
+	},
+}
+
+// LoadConfig reads the config file and compiles the templates
+func LoadConfig(path string) (*Registry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse webhooks config: %w", err)
+	}
+
+	return New(cfg)
+}
+
+// New compiles the templates of the hooks
+func New(cfg Config) (*Registry, error) {
+	if len(cfg.Hooks) == 0 {
+		return nil, errors.New("no webhooks configured")
+	}
+
+	r := &Registry{
+		defaultHook: cfg.Default,
+		hooks:       make(map[string]compiledHook, len(cfg.Hooks)),
+	}
+	if r.defaultHook == "" {
+		r.defaultHook = cfg.Hooks[0].Name
+	}
+
+	for _, h := range cfg.Hooks {
+		if h.Name == "" {
+			return nil, errors.New("webhook without name")
+		}
+		if _, ok := r.hooks[h.Name]; ok {
+			return nil, fmt.Errorf("duplicate webhook name: %s", h.Name)
+		}
+		if h.Prompt == "" || h.Session == "" {
+			return nil, fmt.Errorf("webhook %s: prompt and session are required", h.Name)
+		}
+		if h.Secret == "" {
+			return nil, fmt.Errorf("webhook %s: secret is required", h.Name)
+		}
+		if h.Channel == "" {
+			h.Channel = "webhook"
+		}
+
+		prompt, err := template.New(h.Name).Funcs(funcs).Option("missingkey=zero").Parse(h.Prompt)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: invalid prompt template: %w", h.Name, err)
+		}
+		session, err := template.New(h.Name).Funcs(funcs).Option("missingkey=zero").Parse(h.Session)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: invalid session template: %w", h.Name, err)
+		}
+
+		r.hooks[h.Name] = compiledHook{Hook: h, prompt: prompt, session: session}
+	}
+
+	if _, ok := r.hooks[r.defaultHook]; !ok {
+		return nil, fmt.Errorf("default webhook %s is not configured", r.defaultHook)
+	}
+
+	return r, nil
+}
+
+// Authorize checks the secret of the named hook, an empty name means the default hook
+func (r *Registry) Authorize(name, secret string) error {
+	if name == "" {
+		name = r.defaultHook
+	}
+	h, ok := r.hooks[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownHook, name)
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(h.Secret)) != 1 {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// Render maps the fields by the named hook, an empty name means the default hook
+func (r *Registry) Render(name string, fields map[string]string) (Event, error) {
+	if name == "" {
+		name = r.defaultHook
+	}
+	h, ok := r.hooks[name]
+	if !ok {
+		return Event{}, fmt.Errorf("%w: %s", ErrUnknownHook, name)
+	}
+
+	var prompt, session strings.Builder
+	if err := h.prompt.Execute(&prompt, fields); err != nil {
+		return Event{}, fmt.Errorf("failed to render prompt: %w", err)
+	}
+	if err := h.session.Execute(&session, fields); err != nil {
+		return Event{}, fmt.Errorf("failed to render session: %w", err)
+	}
+
+	// The sessionID is used in file names, so only the safe characters are kept
+	sessionID := unsafeSessionChars.ReplaceAllString(session.String(), "-")
+	sessionID = strings.Trim(repeatedDashes.ReplaceAllString(sessionID, "-"), "-.")
+	if sessionID == "" {
+		return Event{}, errors.New("the session template rendered an invalid sessionID")
+	}
+
+	return Event{
+		SessionID:      sessionID,
+		Prompt:         prompt.String(),
+		Agent:          h.Agent,
+		Channel:        h.Channel,
+		Destination:    h.Destination,
+		DestinationURL: h.DestinationURL,
+	}, nil
+}
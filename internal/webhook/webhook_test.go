@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	r, err := New(Config{Hooks: []Hook{{
+		Name:    "gmail",
+		Prompt:  "{{.Subject}}\n{{truncate 5 .Body}}{{.Missing}}",
+		Session: "email-{{lower (email .Sender)}}",
+		Secret:  "s3cret",
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := r.Render("", map[string]string{"Sender": "Jane <Jane@Example.com>", "Subject": "Hi", "Body": "Hello world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SessionID != "email-jane@example.com" || e.Prompt != "Hi\nHello..." || e.Channel != "webhook" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	// The unsafe characters of the session are replaced
+	e, err = r.Render("gmail", map[string]string{"Sender": "../../etc/passwd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SessionID != "email-etc-passwd" {
+		t.Errorf("unexpected sessionID: %s", e.SessionID)
+	}
+
+	if _, err := r.Render("missing", nil); !errors.Is(err, ErrUnknownHook) {
+		t.Errorf("expected ErrUnknownHook, got %v", err)
+	}
+
+	if err := r.Authorize("", "s3cret"); err != nil {
+		t.Errorf("expected the secret to be accepted, got %v", err)
+	}
+	if err := r.Authorize("gmail", "guess"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+	if err := r.Authorize("missing", "s3cret"); !errors.Is(err, ErrUnknownHook) {
+		t.Errorf("expected ErrUnknownHook, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := map[string]Config{
+		"empty":            {},
+		"missing session":  {Hooks: []Hook{{Name: "a", Prompt: "x", Secret: "k"}}},
+		"missing secret":   {Hooks: []Hook{{Name: "a", Prompt: "x", Session: "s"}}},
+		"invalid template": {Hooks: []Hook{{Name: "a", Prompt: "{{.X", Session: "s", Secret: "k"}}},
+		"unknown default":  {Default: "b", Hooks: []Hook{{Name: "a", Prompt: "x", Session: "s", Secret: "k"}}},
+	}
+	for name, cfg := range tests {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
@@ -93,3 +93,32 @@ func (l *Logic) Send(userID string, msg string, payloads [][]byte) (string, erro
 
 	return response.Response, nil
 }
+
+// Register sets the callback URL of the sessions starting with the prefix, the server pushes the scheduled and relayed messages there
+// The token is the CHANNEL_TOKEN of the server
+func (l *Logic) Register(prefix, callbackURL, token string) error {
+	b, err := json.Marshal(map[string]string{"prefix": prefix, "url": callbackURL})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/channels", l.baseURL), bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to register callback: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	return nil
+}